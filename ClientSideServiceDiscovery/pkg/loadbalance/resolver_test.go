@@ -7,8 +7,8 @@ import (
 
 	"github.com/GergesHany/Event-Streaming-System/ClientSideServiceDiscovery/pkg/loadbalance"
 	"github.com/GergesHany/Event-Streaming-System/SecurityAndObservability/pkg/config"
-	api "github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1"
 	"github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/pkg/server"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/attributes"
@@ -19,8 +19,8 @@ import (
 
 type getServers struct{}
 
-func (s *getServers) GetServers() ([]*api.Server, error) {
-	return []*api.Server{{
+func (s *getServers) GetServers() ([]*apiv2.Server, error) {
+	return []*apiv2.Server{{
 		Id:       "leader",
 		RpcAddr:  "localhost:9001",
 		IsLeader: true,
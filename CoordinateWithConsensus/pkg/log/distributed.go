@@ -1,28 +1,30 @@
 package log
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"io"
 
 	api "github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1"
 	SDWPApi "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
 
 	. "github.com/GergesHany/Event-Streaming-System/WriteALogPackage/log"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb" // implementation of both a LogStore and StableStore.
 	"google.golang.org/protobuf/proto"
-)
-
-var (
-	// Encode data to persist it to a disk
-	enc = binary.BigEndian
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // DistributedLog is a log that is distributed across multiple nodes using the Raft consensus algorithm.
@@ -30,6 +32,18 @@ type DistributedLog struct {
 	config Config
 	log    *Log
 	raft   *raft.Raft
+
+	// Background retention and compaction, running on every node but acting only on the leader,
+	// and the grouping of appends into Raft entries, see group.go
+	maintenanceStop chan struct{}
+	maintenance     sync.WaitGroup
+	appends         chan *pendingAppend
+
+	raftStores []io.Closer // Raft's log and stable stores, closed once Raft is shut down
+
+	// The term this node leads in, once it applied every entry committed before the term,
+	// see CheckConsistency
+	leaseTerm atomic.Uint64
 }
 
 type Server struct {
@@ -40,6 +54,8 @@ type Server struct {
 
 type fsm struct {
 	log *Log
+	// Snapshots link the log's segments into dirs under checkpointDir, on the same filesystem as the log
+	checkpointDir string
 }
 
 type logStore struct {
@@ -49,11 +65,26 @@ type logStore struct {
 type RequestType uint8
 
 type snapshot struct {
-	reader io.Reader
+	checkpoint *Checkpoint
+	keys       KeyProvider // encrypts the snapshot when set
 }
 
+// AppendRequestType and BatchRequestType carry v1 API requests. New entries carry v2 API
+// requests, which hold the canonical records, but the v1 types are still applied so Raft logs
+// written before v2 replay as they did.
 const (
-	AppendRequestType RequestType = 0
+	AppendRequestType   RequestType = 0
+	TruncateRequestType RequestType = 1
+	CompactRequestType  RequestType = 2
+	BatchRequestType    RequestType = 3
+
+	AppendV2RequestType RequestType = 4
+	BatchV2RequestType  RequestType = 5
+	// GroupV2RequestType carries the records of appends grouped into one entry as a
+	// ProduceBatchRequest, each appended on its own, see group.go
+	GroupV2RequestType RequestType = 6
+	// RollRequestType carries the base offset of the active segment the leader rolls
+	RollRequestType RequestType = 7
 )
 
 func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
@@ -69,6 +100,14 @@ func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
 		return nil, err
 	}
 
+	l.maintenanceStop = make(chan struct{})
+	l.appends = make(chan *pendingAppend)
+	l.maintenance.Add(2)
+	go l.groupAppends()
+	go l.watchLeadership()
+	l.startLeaderTask(l.config.Retention.CheckInterval, l.EnforceRetention)
+	l.startLeaderTask(l.config.Compaction.CheckInterval, l.Compact)
+	l.startLeaderTask(l.config.Segment.MaxAge/10, l.Roll)
 	return l, nil
 }
 
@@ -77,8 +116,16 @@ func (l *DistributedLog) setupLog(dataDir string) error {
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return err
 	}
+	// Retention and compaction must delete the same records on every replica, and segments must
+	// be rolled at the same records, so the local log never runs them on its own clock; the
+	// leader replicates each pass and each roll instead
+	logConfig := l.config
+	logConfig.Retention.CheckInterval = 0
+	logConfig.Compaction.CheckInterval = 0
+	logConfig.Segment.MaxAge = 0
+
 	var err error
-	l.log, err = NewLog(logDir, l.config)
+	l.log, err = NewLog(logDir, logConfig)
 	return err
 }
 
@@ -95,11 +142,17 @@ func (l *DistributedLog) setupLog(dataDir string) error {
 func (l *DistributedLog) setupRaft(dataDir string) error {
 	// 1- A finite-state machine that applies the commands you give Raft
 
-	fsm := &fsm{log: l.log}
+	// Checkpoints left behind by a crash are of no use, Raft only restores the snapshots it persisted
+	checkpointDir := filepath.Join(dataDir, "checkpoints")
+	if err := os.RemoveAll(checkpointDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(checkpointDir, 0755); err != nil {
+		return err
+	}
+	fsm := &fsm{log: l.log, checkpointDir: checkpointDir}
 
 	// 2- A log store where Raft stores those commands;
-	// Use BoltDB for Raft's log store instead of custom implementation
-	// This is more reliable and avoids EOF errors during commit
 
 	// Ensure the raft directory exists
 	raftDir := filepath.Join(dataDir, "raft")
@@ -107,9 +160,7 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 		return err
 	}
 
-	// Use separate BoltDB instances for log store and stable store
-	// to avoid transaction conflicts ("Rollback failed: tx closed")
-	logStore, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "raft-log.db"))
+	logStore, err := l.setupLogStore(raftDir)
 	if err != nil {
 		return err
 	}
@@ -120,6 +171,7 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 	if err != nil {
 		return err
 	}
+	l.raftStores = append(l.raftStores, stableStore)
 
 	// 4- A snapshot store where Raft stores compact snapshots of its data;
 
@@ -171,6 +223,20 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 		config.CommitTimeout = l.config.Raft.CommitTimeout
 	}
 
+	// Raft snapshots once SnapshotThreshold entries were applied since the last one, checking every SnapshotInterval
+	if l.config.Raft.SnapshotThreshold > 0 {
+		config.SnapshotThreshold = l.config.Raft.SnapshotThreshold
+	}
+
+	if l.config.Raft.SnapshotInterval > 0 {
+		config.SnapshotInterval = l.config.Raft.SnapshotInterval
+	}
+
+	// The entries kept after a snapshot, so a follower that's only a little behind doesn't need it
+	if l.config.Raft.TrailingLogs > 0 {
+		config.TrailingLogs = l.config.Raft.TrailingLogs
+	}
+
 	l.raft, err = raft.NewRaft(
 		config,
 		fsm,
@@ -212,14 +278,63 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 	return nil
 }
 
-func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
-	res, err := l.apply(AppendRequestType, &api.ProduceRequest{Record: record})
+// setupLogStore opens the store of Raft's log in raftDir: a segmented Log with
+// Raft.SegmentedLogStore set, BoltDB otherwise.
+func (l *DistributedLog) setupLogStore(raftDir string) (raft.LogStore, error) {
+	boltPath := filepath.Join(raftDir, "raft-log.db")
+	segmentedDir := filepath.Join(raftDir, "log")
+
+	if !l.config.Raft.SegmentedLogStore {
+		if _, err := os.Stat(segmentedDir); err == nil {
+			return nil, fmt.Errorf("%s holds a segmented raft log, set Raft.SegmentedLogStore", segmentedDir)
+		}
+		// Use separate BoltDB instances for log store and stable store
+		// to avoid transaction conflicts ("Rollback failed: tx closed")
+		store, err := raftboltdb.NewBoltStore(boltPath)
+		if err != nil {
+			return nil, err
+		}
+		l.raftStores = append(l.raftStores, store)
+		return store, nil
+	}
+
+	// The entries in one store would be lost on switching to the other
+	if _, err := os.Stat(boltPath); err == nil {
+		return nil, fmt.Errorf("%s holds a BoltDB raft log, unset Raft.SegmentedLogStore", boltPath)
+	}
+	if err := os.MkdirAll(segmentedDir, 0755); err != nil {
+		return nil, err
+	}
+	store, err := newLogStore(segmentedDir, l.config)
+	if err != nil {
+		return nil, err
+	}
+	l.raftStores = append(l.raftStores, store)
+	return store, nil
+}
+
+// Append appends the record in a Raft entry it may share with concurrent appends, see group.go.
+func (l *DistributedLog) Append(record *SDWPApi.Record) (uint64, error) {
+	p := &pendingAppend{record: record, done: make(chan appendResult, 1)}
+	select {
+	case l.appends <- p:
+	case <-l.maintenanceStop:
+		return 0, raft.ErrRaftShutdown
+	}
 
+	res := <-p.done
+	return res.offset, res.err
+}
+
+// AppendBatch appends the records as a single Raft entry, so the whole batch is committed
+// and applied on every node at once. It returns the offset of the first record.
+func (l *DistributedLog) AppendBatch(records []*SDWPApi.Record, c apiv2.Compression) (uint64, error) {
+	res, err := l.apply(BatchV2RequestType, &apiv2.ProduceBatchRequest{Records: records, Compression: c})
 	if err != nil {
 		return 0, err
 	}
 
-	return res.(*api.ProduceResponse).Offset, nil
+	return res.(*apiv2.ProduceBatchResponse).Offset, nil
 }
 
 /*
@@ -231,6 +346,15 @@ func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
  */
 
 func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interface{}, error) {
+	future, err := l.applyAsync(reqType, req)
+	if err != nil {
+		return nil, err
+	}
+	return l.response(future)
+}
+
+// applyAsync hands the command to Raft without waiting for it to be committed.
+func (l *DistributedLog) applyAsync(reqType RequestType, req proto.Message) (raft.ApplyFuture, error) {
 	var buf bytes.Buffer
 	_, err := buf.Write([]byte{byte(reqType)})
 	if err != nil {
@@ -247,11 +371,21 @@ func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interfac
 		return nil, err
 	}
 
-	timeout := 10 * time.Second
-	future := l.raft.Apply(buf.Bytes(), timeout)
+	timeout := l.config.Raft.ApplyTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return l.raft.Apply(buf.Bytes(), timeout), nil
+}
 
-	if future.Error() != nil {
-		return nil, future.Error()
+// response waits for the command to be applied and returns what the FSM returned for it.
+func (l *DistributedLog) response(future raft.ApplyFuture) (interface{}, error) {
+	if err := future.Error(); err != nil {
+		// Only the leader applies entries, tell the caller which server that is
+		if errors.Is(err, raft.ErrNotLeader) {
+			return nil, api.ErrNotLeader{Leader: string(l.raft.Leader())}
+		}
+		return nil, err
 	}
 
 	res := future.Response()
@@ -262,8 +396,91 @@ func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interfac
 	return res, nil
 }
 
-func (l *DistributedLog) Read(offset uint64) (*api.Record, error) {
-	// Convert the returned record to the correct type
+// EnforceRetention deletes the segments the retention policy no longer allows to keep.
+// The cut-off offset is decided here and committed through Raft, so every node
+// deletes exactly the same records and offsets stay consistent across the cluster.
+func (l *DistributedLog) EnforceRetention() error {
+	off, ok := l.log.RetentionOffset(time.Now())
+	if !ok {
+		return nil
+	}
+
+	_, err := l.apply(TruncateRequestType, wrapperspb.UInt64(off))
+	return err
+}
+
+// Compact removes the records replaced by newer records with the same key and expired tombstones.
+// The time the pass runs at is committed through Raft, so every node drops the same tombstones.
+func (l *DistributedLog) Compact() error {
+	_, err := l.apply(CompactRequestType, wrapperspb.Int64(time.Now().UnixNano()))
+	return err
+}
+
+// Roll starts a new segment once the active one's first record is older than Segment.MaxAge.
+// The leader decides on its clock and commits the roll through Raft, so every node rolls its
+// segments at the same record.
+func (l *DistributedLog) Roll() error {
+	base, ok := l.log.RollOffset(time.Now(), l.config.Segment.MaxAge)
+	if !ok {
+		return nil
+	}
+
+	_, err := l.apply(RollRequestType, wrapperspb.UInt64(base))
+	return err
+}
+
+// watchLeadership records the term once this node, elected leader, applied every entry in its
+// log: a barrier commits after the first entry of the term, so every earlier entry is applied
+// once it returns. Leader lease reads are served from then on, until Close.
+func (l *DistributedLog) watchLeadership() {
+	defer l.maintenance.Done()
+
+	for {
+		select {
+		case <-l.maintenanceStop:
+			return
+		case leader := <-l.raft.LeaderCh():
+			if !leader {
+				continue
+			}
+			term := l.raft.CurrentTerm()
+			// A barrier that fails lost the leadership, the next election is notified again
+			if err := l.raft.Barrier(0).Error(); err == nil {
+				l.leaseTerm.Store(term)
+			}
+		}
+	}
+}
+
+// startLeaderTask runs fn on the leader every interval until Close.
+func (l *DistributedLog) startLeaderTask(interval time.Duration, fn func() error) {
+	if interval <= 0 {
+		return
+	}
+
+	l.maintenance.Add(1)
+	go func() {
+		defer l.maintenance.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.maintenanceStop:
+				return
+			case <-ticker.C:
+				// Followers apply what the leader commits
+				if l.raft.State() != raft.Leader {
+					continue
+				}
+				// A failed pass is retried on the next tick
+				_ = fn()
+			}
+		}
+	}()
+}
+
+func (l *DistributedLog) Read(offset uint64) (*SDWPApi.Record, error) {
 	record, err := l.log.Read(offset)
 	if err != nil {
 		// Check if it's an offset out of range error and convert to the proper type
@@ -272,11 +489,83 @@ func (l *DistributedLog) Read(offset uint64) (*api.Record, error) {
 		}
 		return nil, err
 	}
-	converted := &api.Record{
-		Value:  record.Value,
-		Offset: record.Offset,
+	return record, nil
+}
+
+// Wait blocks until the record at offset, or a later one, was replicated to this node, or until
+// ctx is done. See Log.Wait.
+func (l *DistributedLog) Wait(ctx context.Context, offset uint64) error {
+	return l.log.Wait(ctx, offset)
+}
+
+// CheckConsistency returns once reads from this node meet the consistency c, or an
+// api.ErrConsistencyUnavailable if it can't meet it:
+//   - local reads whatever the node applied so far.
+//   - leader lease needs the leader, which steps down after LeaderLeaseTimeout without a quorum,
+//     to have applied every entry committed before its term.
+//   - linearizable needs the leader to confirm with a quorum that it still is, and then to apply
+//     every entry in its log from before the read, like a Raft read index.
+//   - max lag needs the leader, or a follower that heard from it within maxLag and applied what
+//     it was told is committed.
+func (l *DistributedLog) CheckConsistency(ctx context.Context, c apiv2.Consistency, maxLag time.Duration) error {
+	unavailable := func(reason string) error {
+		return api.ErrConsistencyUnavailable{Consistency: c, Reason: reason}
 	}
-	return converted, nil
+
+	switch c {
+	case apiv2.Consistency_CONSISTENCY_UNSPECIFIED, apiv2.Consistency_CONSISTENCY_LOCAL:
+		return nil
+	case apiv2.Consistency_CONSISTENCY_LEADER_LEASE:
+		if l.raft.State() != raft.Leader {
+			return unavailable("not the leader")
+		}
+		// A new leader may not have applied what earlier leaders committed yet
+		if l.leaseTerm.Load() != l.raft.CurrentTerm() {
+			return unavailable("the leader hasn't applied the entries of earlier terms yet")
+		}
+		return nil
+	case apiv2.Consistency_CONSISTENCY_LINEARIZABLE:
+		if l.raft.State() != raft.Leader {
+			return unavailable("not the leader")
+		}
+		// Entries past the read index were appended after the read started
+		readIndex := l.raft.LastIndex()
+		if err := l.raft.VerifyLeader().Error(); err != nil {
+			return unavailable(err.Error())
+		}
+		return l.waitApplied(ctx, readIndex, unavailable)
+	case apiv2.Consistency_CONSISTENCY_MAX_LAG:
+		if l.raft.State() == raft.Leader {
+			return nil
+		}
+		if lag := time.Since(l.raft.LastContact()); lag > maxLag {
+			return unavailable(fmt.Sprintf("last heard from the leader %s ago", lag.Round(time.Millisecond)))
+		}
+		return l.waitApplied(ctx, l.raft.CommitIndex(), unavailable)
+	}
+	return unavailable("unknown consistency")
+}
+
+// waitApplied blocks until the FSM applied the Raft entry at index.
+func (l *DistributedLog) waitApplied(ctx context.Context, index uint64, unavailable func(string) error) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	for l.raft.AppliedIndex() < index {
+		select {
+		case <-ctx.Done():
+			return unavailable(fmt.Sprintf("entry %d not applied: %s", index, ctx.Err()))
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// OffsetForTime returns the offset of the first record appended at or after t.
+// Records are stamped with the time the leader appended them to the Raft log,
+// so every node answers the same way.
+func (l *DistributedLog) OffsetForTime(t time.Time) (uint64, error) {
+	return l.log.OffsetForTime(t)
 }
 
 // Compile-time check!
@@ -299,72 +588,188 @@ func (l *fsm) Apply(record *raft.Log) interface{} {
 
 	switch reqType {
 	case AppendRequestType:
-		return l.applyAppend(buf[1:])
+		return l.applyAppendV1(buf[1:], record.AppendedAt)
+	case TruncateRequestType:
+		return l.applyTruncate(buf[1:])
+	case CompactRequestType:
+		return l.applyCompact(buf[1:])
+	case BatchRequestType:
+		return l.applyBatchV1(buf[1:], record.AppendedAt)
+	case AppendV2RequestType:
+		return l.applyAppend(buf[1:], record.AppendedAt)
+	case BatchV2RequestType:
+		return l.applyBatch(buf[1:], record.AppendedAt)
+	case GroupV2RequestType:
+		return l.applyGroup(buf[1:], record.AppendedAt)
+	case RollRequestType:
+		return l.applyRoll(buf[1:])
 	}
 
 	return nil
 }
 
-func (l *fsm) applyAppend(b []byte) interface{} {
+func (l *fsm) applyAppend(b []byte, appendedAt time.Time) interface{} {
+	var req apiv2.ProduceRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+
+	return l.appendRecords([]*SDWPApi.Record{req.Record}, apiv2.Compression_COMPRESSION_UNSPECIFIED, appendedAt, false)
+}
+
+func (l *fsm) applyBatch(b []byte, appendedAt time.Time) interface{} {
+	var req apiv2.ProduceBatchRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+
+	return l.appendRecords(req.Records, req.Compression, appendedAt, true)
+}
+
+// applyGroup appends the records of grouped appends one by one and returns a response per record.
+func (l *fsm) applyGroup(b []byte, appendedAt time.Time) interface{} {
+	var req apiv2.ProduceBatchRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+
+	responses := make([]interface{}, len(req.Records))
+	for i, record := range req.Records {
+		responses[i] = l.appendRecords([]*SDWPApi.Record{record}, apiv2.Compression_COMPRESSION_UNSPECIFIED, appendedAt, false)
+	}
+	return responses
+}
+
+// applyAppendV1 applies an append written to the Raft log before v2 requests replaced it.
+func (l *fsm) applyAppendV1(b []byte, appendedAt time.Time) interface{} {
 	var req api.ProduceRequest
-	err := proto.Unmarshal(b, &req)
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
 
-	if err != nil {
+	return l.appendRecords([]*SDWPApi.Record{req.Record.Canonical()}, apiv2.Compression_COMPRESSION_UNSPECIFIED, appendedAt, false)
+}
+
+// applyBatchV1 applies a batch written to the Raft log before v2 requests replaced it.
+func (l *fsm) applyBatchV1(b []byte, appendedAt time.Time) interface{} {
+	var req api.ProduceBatchRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
 		return err
 	}
 
-	structureRecord := &SDWPApi.Record{
-		Value:  req.Record.Value,
-		Offset: req.Record.Offset,
+	return l.appendRecords(api.CanonicalRecords(req.Records), req.Compression.Canonical(), appendedAt, true)
+}
+
+// appendRecords appends the records of an applied entry and returns the response the leader
+// hands back to the caller: a ProduceBatchResponse for a batch, a ProduceResponse otherwise.
+func (l *fsm) appendRecords(records []*SDWPApi.Record, c apiv2.Compression, appendedAt time.Time, batch bool) interface{} {
+	// Use the time the leader appended the entry rather than the local clock,
+	// so every replica stores the same timestamp
+	if !appendedAt.IsZero() {
+		for _, record := range records {
+			record.Timestamp = appendedAt.UnixNano()
+		}
 	}
 
-	offset, err := l.log.Append(structureRecord)
+	var offset uint64
+	var err error
+	switch {
+	case !batch:
+		offset, err = l.log.Append(records[0])
+	case c == apiv2.Compression_COMPRESSION_UNSPECIFIED:
+		offset, err = l.log.AppendBatch(records)
+	default:
+		// The API values are the log's shifted by one to make room for unspecified
+		offset, err = l.log.AppendCompressedBatch(records, Compression(c-1))
+	}
 	if err != nil {
 		return err
 	}
 
-	return &api.ProduceResponse{Offset: offset}
+	if batch {
+		return &apiv2.ProduceBatchResponse{Offset: offset}
+	}
+	return &apiv2.ProduceResponse{Offset: offset}
 }
 
+func (l *fsm) applyTruncate(b []byte) interface{} {
+	var req wrapperspb.UInt64Value
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+
+	// The value is the highest offset to delete. The cut is exact rather than at the segment
+	// boundaries of each node, which may differ from the leader's
+	return l.log.TruncateBefore(req.Value + 1)
+}
+
+func (l *fsm) applyCompact(b []byte) interface{} {
+	var req wrapperspb.Int64Value
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+
+	return l.log.Compact(time.Unix(0, req.Value))
+}
+
+func (l *fsm) applyRoll(b []byte) interface{} {
+	var req wrapperspb.UInt64Value
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+
+	return l.log.RollSegment(req.Value)
+}
+
+// Snapshot takes a checkpoint of the log: its sealed segments are hard-linked rather than copied,
+// and only the part of the active segment already applied is in it. Persist streams the files.
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
-	r := f.log.Reader()
-	return &snapshot{reader: r}, nil
+	dir, err := ioutil.TempDir(f.checkpointDir, "snapshot")
+	if err != nil {
+		return nil, err
+	}
+	c, err := f.log.Checkpoint(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot{checkpoint: c, keys: f.log.Config.Encryption.Keys}, nil
 }
 
-func (f *fsm) Restore(r io.ReadCloser) error {
-	b := make([]byte, LenWidth)
-	var buf bytes.Buffer
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	// Snapshots taken before encryption was turned on are read as is
+	dr, err := DecryptStream(rc, f.log.Config.Encryption.Keys)
+	if err != nil {
+		return err
+	}
 
+	// A checkpoint installs the segment files as they are
+	r := bufio.NewReader(dr)
+	if IsCheckpoint(r) {
+		return f.log.Restore(r)
+	}
+
+	// Snapshots taken before checkpoints hold the records, which are appended again
 	for i := 0; ; i++ {
-		_, err := io.ReadFull(r, b) // Read the length prefix
+		// Read the next frame, which holds one record or a compressed batch
+		records, err := ReadFrame(r)
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return err
 		}
 
-		size := int64(enc.Uint64(b))
-		if _, err = io.CopyN(&buf, r, size); err != nil {
-			return err
-		}
-
-		record := &SDWPApi.Record{}
-		if err = proto.Unmarshal(buf.Bytes(), record); err != nil {
-			return err
-		}
-
 		if i == 0 {
-			f.log.Config.Segment.InitialOffset = record.Offset
+			f.log.Config.Segment.InitialOffset = records[0].Offset
 			if err := f.log.Reset(); err != nil {
 				return err
 			}
 		}
 
-		if _, err = f.log.Append(record); err != nil {
-			return err
+		for _, record := range records {
+			if _, err = f.log.Append(record); err != nil {
+				return err
+			}
 		}
-
-		buf.Reset()
 	}
 	return nil
 }
@@ -372,47 +777,88 @@ func (f *fsm) Restore(r io.ReadCloser) error {
 var _ raft.FSMSnapshot = (*snapshot)(nil)
 
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
-	if _, err := io.Copy(sink, s.reader); err != nil {
+	var w io.Writer = sink
+	var ew io.WriteCloser
+	if s.keys != nil {
+		var err error
+		if ew, err = EncryptStream(sink, s.keys); err != nil {
+			_ = sink.Cancel()
+			return err
+		}
+		w = ew
+	}
+
+	if err := s.checkpoint.Stream(w); err != nil {
 		_ = sink.Cancel()
 		return err
 	}
+	if ew != nil {
+		if err := ew.Close(); err != nil {
+			_ = sink.Cancel()
+			return err
+		}
+	}
 	return sink.Close()
 }
 
 // Release is required to implement raft.FSMSnapshot interface.
-// It is called when we are done with the snapshot.
-func (s *snapshot) Release() {}
+// It is called when we are done with the snapshot, and removes the checkpoint's links.
+func (s *snapshot) Release() {
+	_ = s.checkpoint.Release()
+}
 
 var _ raft.LogStore = (*logStore)(nil)
 
-func (l *logStore) FirstIndex() (uint64, error) {
-	// First check if the log is empty
-	lastOffset, err := l.HighestOffset()
+// extensionsHeader is the header a raft.Log's Extensions are stored in
+const extensionsHeader = "raft-extensions"
+
+// newLogStore opens a log store in dir. Raft indexes start at 1, and so does the log, so an
+// empty store is told apart from one holding index 0.
+//
+// The store's Config is built from scratch rather than copied from the user's: Raft deletes its
+// entries itself, once they're in a snapshot or conflict with the leader, so the store never
+// rolls on age, retains, compacts, offloads or compresses. It shares only the user's segment
+// sizes and open-segment limit, which bound its files like the records' log's, and encryption
+// keys, since its entries hold the records too.
+func newLogStore(dir string, user Config) (*logStore, error) {
+	var c Config
+	c.Segment.InitialOffset = 1
+	c.Segment.MaxStoreBytes = user.Segment.MaxStoreBytes
+	c.Segment.MaxIndexBytes = user.Segment.MaxIndexBytes
+	c.Segment.MaxOpenSegments = user.Segment.MaxOpenSegments
+	c.Encryption.Keys = user.Encryption.Keys
+	// StoreLogs fsyncs the entries it appended once, before Raft counts on them
+	c.Segment.Sync = SyncOSManaged
+	l, err := NewLog(dir, c)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	// If highest offset is 0, log is empty, return 0
-	if lastOffset == 0 {
-		return 0, nil
+	return &logStore{l}, nil
+}
+
+// bounds returns the first and last index in the store, or 0 for both if it's empty.
+func (l *logStore) bounds() (first, last uint64, err error) {
+	if first, err = l.LowestOffset(); err != nil {
+		return 0, 0, err
 	}
-	// Log has entries, return the lowest offset
-	offset, err := l.LowestOffset()
-	if err != nil {
-		return 0, err
+	if last, err = l.HighestOffset(); err != nil {
+		return 0, 0, err
 	}
-	return offset, nil
+	// An empty log ends right before it starts
+	if last < first {
+		return 0, 0, nil
+	}
+	return first, last, nil
+}
+
+func (l *logStore) FirstIndex() (uint64, error) {
+	first, _, err := l.bounds()
+	return first, err
 }
 
 func (l *logStore) LastIndex() (uint64, error) {
-	off, err := l.HighestOffset()
-	if err != nil {
-		return 0, err
-	}
-	// Raft expects 0 for empty log
-	if off == 0 {
-		return 0, nil
-	}
-	return off, err
+	_, last, err := l.bounds()
+	return last, err
 }
 
 func (l *logStore) GetLog(index uint64, out *raft.Log) error {
@@ -425,11 +871,22 @@ func (l *logStore) GetLog(index uint64, out *raft.Log) error {
 		}
 		return err
 	}
+	// Read skips to the next record if there's none at the offset
+	if in.Offset != index {
+		return raft.ErrLogNotFound
+	}
 
 	out.Data = in.Value
 	out.Index = in.Offset
 	out.Type = raft.LogType(in.Type)
 	out.Term = in.Term
+	out.AppendedAt = time.Unix(0, in.Timestamp)
+	out.Extensions = nil
+	for _, h := range in.Headers {
+		if h.Key == extensionsHeader {
+			out.Extensions = h.Value
+		}
+	}
 
 	return nil
 }
@@ -438,22 +895,65 @@ func (l *logStore) StoreLog(record *raft.Log) error {
 	return l.StoreLogs([]*raft.Log{record})
 }
 
+// StoreLogs appends the entries, each at the offset of its index. They must follow the last
+// entry in the store, or can start anywhere if it's empty.
 func (l *logStore) StoreLogs(records []*raft.Log) error {
 	for _, record := range records {
-		if _, err := l.Append(&SDWPApi.Record{
+		first, last, err := l.bounds()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case first == 0:
+			// An empty log starts over at the index, whichever side of its next offset it's on
+			if err = l.TruncateBefore(record.Index); err == nil {
+				err = l.TruncateAfter(record.Index - 1)
+			}
+			if err != nil {
+				return err
+			}
+		case record.Index != last+1:
+			return fmt.Errorf("raft log index %d doesn't follow the last index %d", record.Index, last)
+		}
+
+		in := &SDWPApi.Record{
 			Value: record.Data,
 			Term:  record.Term,
 			Type:  uint32(record.Type),
-		}); err != nil {
+		}
+		if !record.AppendedAt.IsZero() {
+			in.Timestamp = record.AppendedAt.UnixNano()
+		}
+		if len(record.Extensions) > 0 {
+			in.Headers = []*SDWPApi.Header{{Key: extensionsHeader, Value: record.Extensions}}
+		}
+		if _, err = l.Append(in); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	// Raft takes stored entries as durable, a crash before this only tears entries it never counted on
+	return l.Sync()
 }
 
+// DeleteRange deletes the entries from min to max. Raft only deletes a prefix that a snapshot
+// covers or a suffix that conflicts with the leader's log, which are the ends a Log can cut.
 func (l *logStore) DeleteRange(min, max uint64) error {
-	return l.Truncate(max)
+	first, last, err := l.bounds()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case first == 0 || max < first || min > last:
+		return nil
+	case min <= first:
+		return l.TruncateBefore(max + 1)
+	case max >= last:
+		return l.TruncateAfter(min - 1)
+	}
+	return fmt.Errorf("can't delete raft log entries %d to %d from the middle of the log", min, max)
 }
 
 // The StreamLayer type is responsible for managing the network connections between Raft nodes.
@@ -511,10 +1011,18 @@ func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
 }
 
 func (l *DistributedLog) Close() error {
+	close(l.maintenanceStop)
+	l.maintenance.Wait()
+
 	future := l.raft.Shutdown()
 	if err := future.Error(); err != nil {
 		return err
 	}
+	for _, store := range l.raftStores {
+		if err := store.Close(); err != nil {
+			return err
+		}
+	}
 	return l.log.Close()
 }
 
@@ -1,22 +1,43 @@
 package log
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/GergesHany/Event-Streaming-System/WriteALogPackage/log"
 	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/go-dynaport"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"time"
 
 	api "github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1"
+	SDWPApi "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
 )
 
 func TestMultipleNodes(t *testing.T) {
+	for scenario, segmented := range map[string]bool{
+		"boltdb log store":    false,
+		"segmented log store": true,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testMultipleNodes(t, segmented)
+		})
+	}
+}
+
+func testMultipleNodes(t *testing.T, segmented bool) {
 	nodeCount := 3
 	var logs []*DistributedLog
 	ports := dynaport.Get(nodeCount)
@@ -40,6 +61,9 @@ func TestMultipleNodes(t *testing.T) {
 		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
 		config.Raft.CommitTimeout = 5 * time.Millisecond
 		config.Raft.BindAddr = ln.Addr().String()
+		config.Raft.SegmentedLogStore = segmented
+		config.Segment.MaxStoreBytes = 32 // small segments, so retention has sealed segments to delete
+		config.Retention.Bytes = 1
 
 		if i == 0 {
 			config.Raft.Bootstrap = true
@@ -60,8 +84,8 @@ func TestMultipleNodes(t *testing.T) {
 		logs = append(logs, l)
 	}
 
-	records := []*api.Record{
-		{Value: []byte("first")},
+	records := []*SDWPApi.Record{
+		{Value: []byte("first"), Headers: []*SDWPApi.Header{{Key: "trace-id", Value: []byte("abc123")}}},
 		{Value: []byte("second")},
 	}
 
@@ -78,11 +102,27 @@ func TestMultipleNodes(t *testing.T) {
 				if r.Value == nil || string(r.Value) != string(record.Value) {
 					return false
 				}
+				// Headers and the leader's timestamp are replicated with the value
+				if r.Timestamp == 0 || len(r.Headers) != len(record.Headers) {
+					return false
+				}
 			}
 			return true
 		}, 500*time.Millisecond, 50*time.Millisecond)
 	}
 
+	// the leader decides what to delete and every replica deletes the same records
+	require.NoError(t, logs[0].EnforceRetention())
+	require.Eventually(t, func() bool {
+		for j := 0; j < nodeCount; j++ {
+			off, err := logs[j].log.LowestOffset()
+			if err != nil || off != uint64(len(records)) {
+				return false
+			}
+		}
+		return true
+	}, 500*time.Millisecond, 50*time.Millisecond)
+
 	servers, err := logs[0].GetServers()
 	require.NoError(t, err)
 	require.Equal(t, 3, len(servers))
@@ -90,6 +130,27 @@ func TestMultipleNodes(t *testing.T) {
 	require.False(t, servers[1].IsLeader)
 	require.False(t, servers[2].IsLeader)
 
+	// only the leader serves linearizable reads, followers serve ones that allow their lag
+	ctx := context.Background()
+	for _, c := range []apiv2.Consistency{apiv2.Consistency_CONSISTENCY_LEADER_LEASE, apiv2.Consistency_CONSISTENCY_LINEARIZABLE} {
+		require.NoError(t, logs[0].CheckConsistency(ctx, c, 0))
+		require.IsType(t, api.ErrConsistencyUnavailable{}, logs[1].CheckConsistency(ctx, c, 0))
+	}
+	require.NoError(t, logs[1].CheckConsistency(ctx, apiv2.Consistency_CONSISTENCY_LOCAL, 0))
+
+	// a leader doesn't serve lease reads in a term until it applied the entries of earlier terms
+	term := logs[0].leaseTerm.Load()
+	require.Equal(t, logs[0].raft.CurrentTerm(), term)
+	logs[0].leaseTerm.Store(term - 1)
+	require.IsType(t, api.ErrConsistencyUnavailable{}, logs[0].CheckConsistency(ctx, apiv2.Consistency_CONSISTENCY_LEADER_LEASE, 0))
+	logs[0].leaseTerm.Store(term)
+	require.NoError(t, logs[1].CheckConsistency(ctx, apiv2.Consistency_CONSISTENCY_MAX_LAG, time.Second))
+	require.IsType(t, api.ErrConsistencyUnavailable{}, logs[1].CheckConsistency(ctx, apiv2.Consistency_CONSISTENCY_MAX_LAG, time.Nanosecond))
+
+	// followers don't append, they name the leader to append to
+	_, err = logs[1].Append(&SDWPApi.Record{Value: []byte("to a follower")})
+	require.Equal(t, api.ErrNotLeader{Leader: logs[0].config.Raft.BindAddr}, err)
+
 	err = logs[0].Leave("1")
 	require.NoError(t, err)
 
@@ -101,7 +162,7 @@ func TestMultipleNodes(t *testing.T) {
 	require.True(t, servers[0].IsLeader)
 	require.False(t, servers[1].IsLeader)
 
-	off, err := logs[0].Append(&api.Record{Value: []byte("third")})
+	off, err := logs[0].Append(&SDWPApi.Record{Value: []byte("third")})
 	require.NoError(t, err)
 
 	time.Sleep(50 * time.Millisecond)
@@ -114,4 +175,444 @@ func TestMultipleNodes(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte("third"), record.Value)
 	require.Equal(t, off, record.Offset)
+
+	// a batch is one Raft entry, so it reaches the followers as a whole
+	batch := []*SDWPApi.Record{{Value: []byte("batch one")}, {Value: []byte("batch two")}}
+	off, err = logs[0].AppendBatch(batch, apiv2.Compression_COMPRESSION_GZIP)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		for i, want := range batch {
+			record, err := logs[2].Read(off + uint64(i))
+			if err != nil || string(record.Value) != string(want.Value) {
+				return false
+			}
+		}
+		return true
+	}, 500*time.Millisecond, 50*time.Millisecond)
+
+	// compaction keeps the newest record of a key on every replica
+	first, err := logs[0].Append(&SDWPApi.Record{Key: []byte("key"), Value: []byte("old")})
+	require.NoError(t, err)
+	latest, err := logs[0].Append(&SDWPApi.Record{Key: []byte("key"), Value: []byte("new")})
+	require.NoError(t, err)
+	_, err = logs[0].Append(&SDWPApi.Record{Value: []byte("fourth")})
+	require.NoError(t, err)
+
+	require.NoError(t, logs[0].Compact())
+	require.Eventually(t, func() bool {
+		for _, j := range []int{0, 2} {
+			record, err := logs[j].Read(first)
+			if err != nil || record.Offset != latest || string(record.Key) != "key" {
+				return false
+			}
+		}
+		return true
+	}, 500*time.Millisecond, 50*time.Millisecond)
+}
+
+func TestSnapshotInstall(t *testing.T) {
+	ports := dynaport.Get(2)
+	var logs []*DistributedLog
+	var dataDirs []string
+	for i := 0; i < 2; i++ {
+		dataDir, err := ioutil.TempDir("", fmt.Sprintf("snapshot-install-test-%d", i))
+		require.NoError(t, err)
+		defer os.RemoveAll(dataDir)
+		dataDirs = append(dataDirs, dataDir)
+
+		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", ports[i]))
+		require.NoError(t, err)
+
+		config := log.Config{}
+		config.Raft.StreamLayer = log.NewStreamLayer(ln, nil, nil)
+		config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+		config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		config.Raft.ElectionTimeout = 50 * time.Millisecond
+		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		config.Raft.CommitTimeout = 5 * time.Millisecond
+		config.Raft.BindAddr = ln.Addr().String()
+		config.Raft.TrailingLogs = 1
+		config.Raft.SnapshotInterval = time.Hour
+		config.Segment.MaxStoreBytes = 32
+		config.Raft.Bootstrap = i == 0
+
+		l, err := NewDistributedLog(dataDir, config)
+		require.NoError(t, err)
+		defer l.Close()
+		logs = append(logs, l)
+
+		if i > 0 {
+			require.NoError(t, logs[0].Join(fmt.Sprintf("%d", i), ln.Addr().String()))
+			continue
+		}
+		require.NoError(t, l.WaitForLeader(3*time.Second))
+
+		// the entries the second node needs are gone from the Raft log by the time it joins
+		for j := 0; j < 6; j++ {
+			_, err = l.Append(&SDWPApi.Record{Value: []byte(fmt.Sprintf("record %d", j))})
+			require.NoError(t, err)
+		}
+		require.NoError(t, l.raft.Snapshot().Error())
+		require.NotEqual(t, "0", l.raft.Stats()["last_snapshot_index"])
+	}
+
+	// the second node installs the leader's segments and goes on replicating from there
+	off, err := logs[0].Append(&SDWPApi.Record{Value: []byte("record 6")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), off)
+	require.Eventually(t, func() bool {
+		for j := uint64(0); j <= off; j++ {
+			record, err := logs[1].Read(j)
+			if err != nil || string(record.Value) != fmt.Sprintf("record %d", j) {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// the checkpoint's links are gone once the snapshot is persisted
+	entries, err := ioutil.ReadDir(filepath.Join(dataDirs[0], "checkpoints"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
+
+func TestEncryptedSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypted-snapshot-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keys := log.NewFileKeyProvider(filepath.Join(dir, "keys"))
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+	require.NoError(t, keys.Rotate("k1", key))
+
+	logDir := filepath.Join(dir, "log")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+	c := log.Config{}
+	c.Encryption.Keys = keys
+	l, err := log.NewLog(logDir, c)
+	require.NoError(t, err)
+	defer l.Close()
+
+	secret := []byte("card number 4242 4242 4242 4242")
+	for i := 0; i < 2; i++ {
+		_, err = l.Append(&SDWPApi.Record{Value: secret})
+		require.NoError(t, err)
+	}
+
+	checkpointDir := filepath.Join(dir, "checkpoints")
+	require.NoError(t, os.MkdirAll(checkpointDir, 0755))
+	f := &fsm{log: l, checkpointDir: checkpointDir}
+	snap, err := f.Snapshot()
+	require.NoError(t, err)
+	sink := &bufferSink{}
+	require.NoError(t, snap.Persist(sink))
+	snap.Release()
+	require.False(t, bytes.Contains(sink.Bytes(), secret))
+
+	// the snapshot installs the segment files of the log in another one
+	restoreDir := filepath.Join(dir, "restored")
+	require.NoError(t, os.MkdirAll(restoreDir, 0755))
+	restored, err := log.NewLog(restoreDir, c)
+	require.NoError(t, err)
+	defer restored.Close()
+	requireRestored := func(snapshot []byte) {
+		t.Helper()
+		require.NoError(t, (&fsm{log: restored}).Restore(ioutil.NopCloser(bytes.NewReader(snapshot))))
+		for off := uint64(0); off < 2; off++ {
+			record, err := restored.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, secret, record.Value)
+		}
+		_, err = restored.Read(2)
+		require.Error(t, err)
+	}
+	requireRestored(sink.Bytes())
+
+	// snapshots taken before checkpoints hold the records themselves, and still restore
+	var legacy bytes.Buffer
+	w, err := log.EncryptStream(&legacy, keys)
+	require.NoError(t, err)
+	_, err = io.Copy(w, l.Reader())
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	requireRestored(legacy.Bytes())
+}
+
+func TestApplyV1Entries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apply-v1-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer l.Close()
+	f := &fsm{log: l}
+
+	// entries written to the Raft log by the v1 API still replay, with every field
+	entry := func(reqType RequestType, req proto.Message) *raft.Log {
+		b, err := proto.Marshal(req)
+		require.NoError(t, err)
+		return &raft.Log{Type: raft.LogCommand, Data: append([]byte{byte(reqType)}, b...), AppendedAt: time.Now()}
+	}
+	headers := []*api.Header{{Key: "trace-id", Value: []byte("abc123")}}
+
+	res := f.Apply(entry(AppendRequestType, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("first"), Key: []byte("key"), Headers: headers},
+	}))
+	require.Equal(t, uint64(0), res.(*apiv2.ProduceResponse).Offset)
+
+	res = f.Apply(entry(BatchRequestType, &api.ProduceBatchRequest{
+		Records:     []*api.Record{{Value: []byte("second")}, {Value: []byte("third")}},
+		Compression: api.Compression_COMPRESSION_GZIP,
+	}))
+	require.Equal(t, uint64(1), res.(*apiv2.ProduceBatchResponse).Offset)
+
+	record, err := l.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("key"), record.Key)
+	require.Equal(t, "trace-id", record.Headers[0].Key)
+	require.NotZero(t, record.Timestamp)
+
+	record, err = l.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("third"), record.Value)
+}
+
+func TestApplyRollAndTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apply-roll-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer l.Close()
+	f := &fsm{log: l}
+
+	entry := func(reqType RequestType, req proto.Message) *raft.Log {
+		b, err := proto.Marshal(req)
+		require.NoError(t, err)
+		return &raft.Log{Type: raft.LogCommand, Data: append([]byte{byte(reqType)}, b...), AppendedAt: time.Now()}
+	}
+	for i := 0; i < 4; i++ {
+		res := f.Apply(entry(AppendV2RequestType, &apiv2.ProduceRequest{Record: &SDWPApi.Record{Value: []byte("record")}}))
+		require.Equal(t, uint64(i), res.(*apiv2.ProduceResponse).Offset)
+	}
+
+	// every node rolls at the record the leader committed the roll after, and only once
+	require.Nil(t, f.Apply(entry(RollRequestType, wrapperspb.UInt64(0))))
+	require.Nil(t, f.Apply(entry(RollRequestType, wrapperspb.UInt64(0))))
+	res := f.Apply(entry(AppendV2RequestType, &apiv2.ProduceRequest{Record: &SDWPApi.Record{Value: []byte("record")}}))
+	require.Equal(t, uint64(4), res.(*apiv2.ProduceResponse).Offset)
+	base, ok := l.RollOffset(time.Now().Add(time.Hour), time.Minute)
+	require.True(t, ok)
+	require.Equal(t, uint64(4), base)
+
+	// the cut is exact, even in the middle of a segment
+	require.Nil(t, f.Apply(entry(TruncateRequestType, wrapperspb.UInt64(1))))
+	off, err := l.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	record, err := l.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), record.Offset)
+}
+
+func TestLogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := log.Config{}
+	c.Segment.MaxStoreBytes = 64 // a few entries per segment, so cuts fall inside sealed segments too
+	// policies meant for the records' log don't reach the store
+	c.Segment.MaxAge = time.Millisecond
+	c.Segment.Compression = log.CompressionGzip
+	c.Retention.Bytes = 1
+	c.Compaction.CheckInterval = time.Millisecond
+	s, err := newLogStore(dir, c)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	require.Equal(t, uint64(64), s.Config.Segment.MaxStoreBytes)
+	require.Zero(t, s.Config.Segment.MaxAge)
+	require.Equal(t, log.CompressionNone, s.Config.Segment.Compression)
+	require.Zero(t, s.Config.Retention.Bytes)
+	require.Zero(t, s.Config.Compaction.CheckInterval)
+
+	requireIndexes := func(first, last uint64) {
+		t.Helper()
+		got, err := s.FirstIndex()
+		require.NoError(t, err)
+		require.Equal(t, first, got)
+		got, err = s.LastIndex()
+		require.NoError(t, err)
+		require.Equal(t, last, got)
+	}
+	entry := func(index, term uint64) *raft.Log {
+		return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: []byte(fmt.Sprintf("entry %d", index))}
+	}
+	requireEntry := func(index, term uint64) {
+		t.Helper()
+		var out raft.Log
+		require.NoError(t, s.GetLog(index, &out))
+		require.Equal(t, index, out.Index)
+		require.Equal(t, term, out.Term)
+		require.Equal(t, raft.LogCommand, out.Type)
+		require.Equal(t, []byte(fmt.Sprintf("entry %d", index)), out.Data)
+	}
+
+	// an empty store
+	requireIndexes(0, 0)
+	var out raft.Log
+	require.Equal(t, raft.ErrLogNotFound, s.GetLog(1, &out))
+
+	var entries []*raft.Log
+	for i := uint64(1); i <= 10; i++ {
+		entries = append(entries, entry(i, 1))
+	}
+	require.NoError(t, s.StoreLogs(entries))
+	requireIndexes(1, 10)
+	requireEntry(5, 1)
+	require.Equal(t, raft.ErrLogNotFound, s.GetLog(11, &out))
+
+	// entries must follow the last one
+	require.Error(t, s.StoreLog(entry(12, 1)))
+
+	// a conflicting suffix is replaced by the new leader's entries
+	require.NoError(t, s.DeleteRange(6, 10))
+	requireIndexes(1, 5)
+	require.Equal(t, raft.ErrLogNotFound, s.GetLog(6, &out))
+	require.NoError(t, s.StoreLogs([]*raft.Log{entry(6, 2), entry(7, 2)}))
+	requireIndexes(1, 7)
+	requireEntry(6, 2)
+
+	// a prefix covered by a snapshot is deleted
+	require.NoError(t, s.DeleteRange(1, 3))
+	requireIndexes(4, 7)
+	require.Equal(t, raft.ErrLogNotFound, s.GetLog(3, &out))
+	requireEntry(4, 1)
+
+	// deleting the middle isn't something Raft does
+	require.Error(t, s.DeleteRange(5, 6))
+
+	// the store survives a restart
+	require.NoError(t, s.Close())
+	s, err = newLogStore(dir, c)
+	require.NoError(t, err)
+	requireIndexes(4, 7)
+	requireEntry(7, 2)
+
+	// after deleting everything, for a snapshot past the log, the store starts where the next entry is
+	require.NoError(t, s.DeleteRange(4, 7))
+	requireIndexes(0, 0)
+	require.NoError(t, s.StoreLog(entry(20, 3)))
+	requireIndexes(20, 20)
+	requireEntry(20, 3)
+
+	// extensions are kept with the entry
+	withExtensions := entry(21, 3)
+	withExtensions.Extensions = []byte("ext")
+	require.NoError(t, s.StoreLog(withExtensions))
+	require.NoError(t, s.GetLog(21, &out))
+	require.Equal(t, []byte("ext"), out.Extensions)
+	require.NoError(t, s.GetLog(20, &out))
+	require.Nil(t, out.Extensions)
+}
+
+func TestLogStoreTornTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-store-torn-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := newLogStore(dir, log.Config{})
+	require.NoError(t, err)
+	for i := uint64(1); i <= 3; i++ {
+		require.NoError(t, s.StoreLog(&raft.Log{Index: i, Term: 1, Type: raft.LogCommand, Data: []byte("entry")}))
+	}
+	require.NoError(t, s.Close())
+
+	// a crash tore the last entry, the store comes back with the entries before it
+	fi, err := os.Stat(filepath.Join(dir, "1.store"))
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(filepath.Join(dir, "1.store"), fi.Size()-3))
+	require.NoError(t, os.Truncate(filepath.Join(dir, "1.index"), int64(s.Config.Segment.MaxIndexBytes)))
+
+	s, err = newLogStore(dir, log.Config{})
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	last, err := s.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), last)
+
+	// Raft stores the torn entry again
+	require.NoError(t, s.StoreLog(&raft.Log{Index: 3, Term: 1, Type: raft.LogCommand, Data: []byte("entry")}))
+	var out raft.Log
+	require.NoError(t, s.GetLog(3, &out))
+	require.Equal(t, []byte("entry"), out.Data)
+}
+
+func TestGroupCommit(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "group-commit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0]))
+	require.NoError(t, err)
+	config := log.Config{}
+	config.Raft.StreamLayer = log.NewStreamLayer(ln, nil, nil)
+	config.Raft.LocalID = "0"
+	config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+	config.Raft.ElectionTimeout = 50 * time.Millisecond
+	config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.Raft.CommitTimeout = 5 * time.Millisecond
+	config.Raft.BindAddr = ln.Addr().String()
+	config.Raft.Bootstrap = true
+	config.Raft.GroupCommitWindow = 20 * time.Millisecond
+	l, err := NewDistributedLog(dataDir, config)
+	require.NoError(t, err)
+	defer l.Close()
+	require.NoError(t, l.WaitForLeader(3*time.Second))
+
+	// concurrent appends share entries, and every caller gets the offset of its own record
+	const appends = 50
+	before := l.raft.LastIndex()
+	offsets := make([]uint64, appends)
+	var wg sync.WaitGroup
+	for i := 0; i < appends; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			off, err := l.Append(&SDWPApi.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+			require.NoError(t, err)
+			offsets[i] = off
+		}(i)
+	}
+	wg.Wait()
+	require.Less(t, l.raft.LastIndex()-before, uint64(appends))
+
+	seen := make(map[uint64]bool)
+	for i, off := range offsets {
+		require.False(t, seen[off])
+		seen[off] = true
+		record, err := l.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("record %d", i)), record.Value)
+		require.NotZero(t, record.Timestamp)
+	}
+
+	// appends one after the other keep their order
+	first, err := l.Append(&SDWPApi.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	second, err := l.Append(&SDWPApi.Record{Value: []byte("second")})
+	require.NoError(t, err)
+	require.Equal(t, first+1, second)
 }
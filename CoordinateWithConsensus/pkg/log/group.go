@@ -0,0 +1,148 @@
+package log
+
+import (
+	"fmt"
+	"time"
+
+	SDWPApi "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
+	"github.com/hashicorp/raft"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+	* An append is committed once a quorum stored its Raft entry, which takes a round-trip to the
+	  followers. With an entry per append, appends are bounded by round-trips however many
+	  clients produce at once.
+	* Append queues its record instead, and groupAppends coalesces the queued records into one
+	  GroupV2RequestType entry: every record waiting when the first is taken, and those arriving
+	  within Raft.GroupCommitWindow, up to Raft.GroupCommitMaxBytes of records.
+	* The entry is handed to Raft without waiting for it to commit, so the next group is formed
+	  while the previous one is replicated. Raft keeps the entries in the order they were taken,
+	  so appends one after the other keep their order.
+	* The FSM appends the records of a group one by one, as if each had an entry of its own, and
+	  answers with a response per record, which goes back to the caller that queued it.
+	* A group of one record is an AppendV2RequestType entry, as it was before groups.
+*/
+
+const defaultGroupCommitMaxBytes = 1 << 20
+
+// pendingAppend is an append waiting in a group for its entry to be applied.
+type pendingAppend struct {
+	record *SDWPApi.Record
+	done   chan appendResult
+}
+
+type appendResult struct {
+	offset uint64
+	err    error
+}
+
+// groupAppends coalesces the appends queued on l.appends into Raft entries until Close.
+func (l *DistributedLog) groupAppends() {
+	defer l.maintenance.Done()
+
+	maxBytes := l.config.Raft.GroupCommitMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultGroupCommitMaxBytes
+	}
+
+	for {
+		var group []*pendingAppend
+		select {
+		case <-l.maintenanceStop:
+			return
+		case p := <-l.appends:
+			group = append(group, p)
+		}
+		size := proto.Size(group[0].record)
+
+		var timer *time.Timer
+		var window <-chan time.Time
+		if l.config.Raft.GroupCommitWindow > 0 {
+			timer = time.NewTimer(l.config.Raft.GroupCommitWindow)
+			window = timer.C
+		}
+
+	collect:
+		for size < maxBytes {
+			if window == nil {
+				// Without a window the group is whatever was already queued
+				select {
+				case p := <-l.appends:
+					group = append(group, p)
+					size += proto.Size(p.record)
+				default:
+					break collect
+				}
+				continue
+			}
+
+			select {
+			case p := <-l.appends:
+				group = append(group, p)
+				size += proto.Size(p.record)
+			case <-window:
+				break collect
+			case <-l.maintenanceStop:
+				timer.Stop()
+				resolve(group, nil, raft.ErrRaftShutdown)
+				return
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		l.commitGroup(group)
+	}
+}
+
+// commitGroup hands the group to Raft as one entry and resolves its appends once it's applied.
+func (l *DistributedLog) commitGroup(group []*pendingAppend) {
+	var future raft.ApplyFuture
+	var err error
+	if len(group) == 1 {
+		future, err = l.applyAsync(AppendV2RequestType, &apiv2.ProduceRequest{Record: group[0].record})
+	} else {
+		records := make([]*SDWPApi.Record, len(group))
+		for i, p := range group {
+			records[i] = p.record
+		}
+		future, err = l.applyAsync(GroupV2RequestType, &apiv2.ProduceBatchRequest{Records: records})
+	}
+	if err != nil {
+		resolve(group, nil, err)
+		return
+	}
+
+	go func() {
+		res, err := l.response(future)
+		if err != nil {
+			resolve(group, nil, err)
+			return
+		}
+		if len(group) == 1 {
+			res = []interface{}{res}
+		}
+		resolve(group, res.([]interface{}), nil)
+	}()
+}
+
+// resolve hands every append of the group its response, or err if the entry failed as a whole.
+func resolve(group []*pendingAppend, responses []interface{}, err error) {
+	for i, p := range group {
+		if err != nil {
+			p.done <- appendResult{err: err}
+			continue
+		}
+		switch res := responses[i].(type) {
+		case error:
+			p.done <- appendResult{err: res}
+		case *apiv2.ProduceResponse:
+			p.done <- appendResult{offset: res.Offset}
+		default:
+			p.done <- appendResult{err: fmt.Errorf("unexpected append response %T", res)}
+		}
+	}
+}
@@ -60,12 +60,17 @@ func setupFlags(cmd *cobra.Command) error {
 	dataDir := path.Join(os.TempDir(), systemName)
 	cmd.Flags().String("node-name", hostname, "Unique server ID.")
 	cmd.Flags().String("data-dir", dataDir, "Directory to store log and Raft data.")
+	cmd.Flags().String("encryption-key-dir", "", "Directory holding the keys to encrypt the log with (empty = no encryption).")
+	cmd.Flags().Bool("segmented-raft-log", false, "Keep the Raft log in a segmented log instead of BoltDB (a data dir keeps the store it was created with).")
+	cmd.Flags().Uint64("snapshot-threshold", 0, "Raft entries applied since the last snapshot that trigger a new one (0 = Raft's default).")
+	cmd.Flags().Duration("snapshot-interval", 0, "How often Raft checks whether to snapshot (0 = Raft's default).")
 
 	// Cluster configuration
 	cmd.Flags().Bool("bootstrap", false, "Bootstrap the cluster.")
 	cmd.Flags().StringSlice("start-join-addrs", nil, "Serf addresses to join.")
 	cmd.Flags().String("bind-addr", "127.0.0.1:8401", "Address to bind Serf on.")
 	cmd.Flags().Int("rpc-port", 8400, "Port for RPC clients (and Raft) connections.")
+	cmd.Flags().Bool("produce-on-leader-only", false, "Fail produce requests sent to followers instead of forwarding them to the leader.")
 
 	// Access Control List (ACL) configuration
 	cmd.Flags().String("acl-model-file", "", "Path to ACL model.")
@@ -104,12 +109,17 @@ func (c *cli) setupConfig(cmd *cobra.Command, args []string) error {
 	// Node configuration
 	c.cfg.DataDir = viper.GetString("data-dir")
 	c.cfg.NodeName = viper.GetString("node-name")
+	c.cfg.EncryptionKeyDir = viper.GetString("encryption-key-dir")
+	c.cfg.SegmentedRaftLog = viper.GetBool("segmented-raft-log")
+	c.cfg.SnapshotThreshold = viper.GetUint64("snapshot-threshold")
+	c.cfg.SnapshotInterval = viper.GetDuration("snapshot-interval")
 
 	// Cluster configuration
 	c.cfg.BindAddr = viper.GetString("bind-addr")
 	c.cfg.RPCPort = viper.GetInt("rpc-port")
 	c.cfg.StartJoinAddrs = viper.GetStringSlice("start-join-addrs")
 	c.cfg.Bootstrap = viper.GetBool("bootstrap")
+	c.cfg.ProduceOnLeaderOnly = viper.GetBool("produce-on-leader-only")
 
 	// ACL configuration
 	c.cfg.ACLModelFile = viper.GetString("acl-mode-file")
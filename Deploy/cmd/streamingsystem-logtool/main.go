@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"text/tabwriter"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	logpkg "github.com/GergesHany/Event-Streaming-System/WriteALogPackage/log"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// streamingsystem-logtool inspects and repairs the log of a stopped node.
+// Running it against the data dir of a live node will corrupt the log.
+// It refuses a log written in an older on-disk format unless told to migrate it first.
+
+type cli struct {
+	dataDir string
+	keyDir  string
+	migrate bool
+}
+
+const (
+	toolName = "streamingsystem-logtool"
+)
+
+func main() {
+	cli := &cli{}
+
+	cmd := &cobra.Command{
+		Use:               toolName,
+		Short:             "Inspect and repair the log of a stopped node",
+		SilenceUsage:      true,
+		SilenceErrors:     true,
+		PersistentPreRunE: cli.checkFormat,
+	}
+
+	dataDir := path.Join(os.TempDir(), "StreamingSystem")
+	cmd.PersistentFlags().StringVar(&cli.dataDir, "data-dir", dataDir, "Data dir of the stopped node.")
+	cmd.PersistentFlags().StringVar(&cli.keyDir, "encryption-key-dir", "", "Directory holding the keys the log is encrypted with.")
+	cmd.PersistentFlags().BoolVar(&cli.migrate, "migrate", false, "Migrate a log written in an older on-disk format before running the command.")
+
+	dump := &cobra.Command{
+		Use:   "dump",
+		Short: "Print the records as JSON, one per line",
+		Args:  cobra.NoArgs,
+		RunE:  cli.dump,
+	}
+	dump.Flags().Uint64("from", 0, "First offset to print.")
+	dump.Flags().Int64("to", -1, "Last offset to print (-1 = to the end).")
+
+	rebuild := &cobra.Command{
+		Use:   "rebuild-index [base-offset]",
+		Short: "Rebuild a segment's index from its store, or of every segment that fails verify",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  cli.rebuildIndex,
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the segments with their offsets and sizes",
+			Args:  cobra.NoArgs,
+			RunE:  cli.list,
+		},
+		dump,
+		&cobra.Command{
+			Use:   "verify",
+			Short: "Check that every index matches its store",
+			Args:  cobra.NoArgs,
+			RunE:  cli.verify,
+		},
+		rebuild,
+		&cobra.Command{
+			Use:   "truncate <offset>",
+			Short: "Delete the segments that only hold records up to offset",
+			Args:  cobra.ExactArgs(1),
+			RunE:  cli.truncate,
+		},
+	)
+
+	if err := cmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// logDir is where the node keeps its log, next to the Raft data.
+func (c *cli) logDir() string {
+	return path.Join(c.dataDir, "log")
+}
+
+// checkFormat makes sure the log is written in the format the tool reads, migrating it with --migrate.
+func (c *cli) checkFormat(cmd *cobra.Command, args []string) error {
+	version, err := logpkg.DirFormatVersion(c.logDir())
+	if err != nil {
+		return err
+	}
+	switch {
+	case version > logpkg.FormatVersion:
+		return fmt.Errorf("%w: the log is at version %d, this tool reads up to %d", logpkg.ErrUnsupportedFormat, version, logpkg.FormatVersion)
+	case version == logpkg.FormatVersion:
+		return nil
+	case !c.migrate:
+		return fmt.Errorf("the log is at on-disk format version %d, this tool reads %d: rerun with --migrate to migrate it first", version, logpkg.FormatVersion)
+	}
+
+	// Opening the log migrates it, and recovers it like the node would on start
+	l, err := c.open()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "migrated the log from on-disk format version %d to %d\n", version, logpkg.FormatVersion)
+	return l.Close()
+}
+
+// open opens the log like the node does, trimming torn tails left by a crash.
+func (c *cli) open() (*logpkg.Log, error) {
+	config := logpkg.Config{}
+	config.Encryption.Keys = c.keys()
+	return logpkg.NewLog(c.logDir(), config)
+}
+
+// keys returns the provider of the keys the log is encrypted with, or nil if it isn't.
+func (c *cli) keys() logpkg.KeyProvider {
+	if c.keyDir == "" {
+		return nil
+	}
+	return logpkg.NewFileKeyProvider(c.keyDir)
+}
+
+func (c *cli) list(cmd *cobra.Command, args []string) error {
+	infos, err := logpkg.InspectSegments(c.logDir(), c.keys())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BASE\tNEXT\tSTORE BYTES\tINDEX ENTRIES\tRECORDS\tSTATUS")
+	for _, info := range infos {
+		status := "ok"
+		if info.Problem != "" {
+			status = "broken"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%s\n",
+			info.BaseOffset, info.NextOffset, info.StoreBytes, info.IndexEntries, info.Records, status)
+	}
+	return w.Flush()
+}
+
+func (c *cli) dump(cmd *cobra.Command, args []string) error {
+	from, err := cmd.Flags().GetUint64("from")
+	if err != nil {
+		return err
+	}
+	to, err := cmd.Flags().GetInt64("to")
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	return logpkg.DumpRecords(c.logDir(), c.keys(), func(record *api.Record) error {
+		if record.Offset < from || (to >= 0 && record.Offset > uint64(to)) {
+			return nil
+		}
+
+		b, err := protojson.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(b))
+		return err
+	})
+}
+
+func (c *cli) verify(cmd *cobra.Command, args []string) error {
+	infos, err := logpkg.InspectSegments(c.logDir(), c.keys())
+	if err != nil {
+		return err
+	}
+
+	var broken int
+	for _, info := range infos {
+		if info.Problem == "" {
+			continue
+		}
+		broken++
+		fmt.Fprintf(cmd.OutOrStdout(), "segment %d: %s\n", info.BaseOffset, info.Problem)
+	}
+
+	if broken > 0 {
+		return fmt.Errorf("%d of %d segments failed verification", broken, len(infos))
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%d segments ok\n", len(infos))
+	return nil
+}
+
+func (c *cli) rebuildIndex(cmd *cobra.Command, args []string) error {
+	var bases []uint64
+	if len(args) == 1 {
+		base, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		bases = append(bases, base)
+	} else {
+		infos, err := logpkg.InspectSegments(c.logDir(), c.keys())
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if info.Problem != "" {
+				bases = append(bases, info.BaseOffset)
+			}
+		}
+	}
+
+	for _, base := range bases {
+		entries, err := logpkg.RebuildIndex(c.logDir(), base, c.keys())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "segment %d: rebuilt index with %d entries\n", base, entries)
+	}
+	return nil
+}
+
+func (c *cli) truncate(cmd *cobra.Command, args []string) error {
+	offset, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	l, err := c.open()
+	if err != nil {
+		return err
+	}
+	if err = l.Truncate(offset); err != nil {
+		_ = l.Close()
+		return err
+	}
+
+	lowest, err := l.LowestOffset()
+	if err != nil {
+		_ = l.Close()
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "log now starts at offset %d\n", lowest)
+	return l.Close()
+}
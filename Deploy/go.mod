@@ -4,16 +4,18 @@ go 1.24.0
 
 require (
 	github.com/GergesHany/Event-Streaming-System/SecurityAndObservability v0.0.0
+	github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC v0.0.0-00010101000000-000000000000
 	github.com/GergesHany/Event-Streaming-System/ServerSideServiceDiscovery v0.0.0
+	github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf v0.0.0
+	github.com/GergesHany/Event-Streaming-System/WriteALogPackage v0.0.0
 	github.com/spf13/cobra v1.8.1
 	github.com/spf13/viper v1.19.0
+	google.golang.org/grpc v1.75.1
+	google.golang.org/protobuf v1.36.10
 )
 
 require (
 	github.com/GergesHany/Event-Streaming-System/CoordinateWithConsensus v0.0.0-00010101000000-000000000000 // indirect
-	github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC v0.0.0-00010101000000-000000000000 // indirect
-	github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf v0.0.0 // indirect
-	github.com/GergesHany/Event-Streaming-System/WriteALogPackage v0.0.0 // indirect
 	github.com/Knetic/govaluate v3.0.1-0.20171022003610-9aa49832a739+incompatible // indirect
 	github.com/armon/go-metrics v0.4.1 // indirect
 	github.com/boltdb/bolt v1.3.1 // indirect
@@ -67,8 +69,6 @@ require (
 	golang.org/x/text v0.29.0 // indirect
 	golang.org/x/tools v0.36.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251029180050-ab9386a59fda // indirect
-	google.golang.org/grpc v1.75.1 // indirect
-	google.golang.org/protobuf v1.36.10 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
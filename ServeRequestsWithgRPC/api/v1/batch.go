@@ -0,0 +1,31 @@
+package log_v1
+
+import (
+	logapiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
+)
+
+// A v1 batch is encoded like a v2 one, so both are built and decoded by the v2 API's codecs.
+
+// NewRecordBatch compresses the records into a batch with the given compression.
+func NewRecordBatch(records []*Record, c Compression) (*RecordBatch, error) {
+	batch, err := logapiv2.NewRecordBatch(CanonicalRecords(records), c.Canonical())
+	if err != nil {
+		return nil, err
+	}
+	return &RecordBatch{Compression: c, Data: batch.Data}, nil
+}
+
+// Decode decompresses the batch and returns its records.
+func (b *RecordBatch) Decode() ([]*Record, error) {
+	batch := &logapiv2.RecordBatch{Compression: b.Compression.Canonical(), Data: b.Data}
+	canonical, err := batch.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*Record, len(canonical))
+	for i, r := range canonical {
+		records[i] = NewRecord(r)
+	}
+	return records, nil
+}
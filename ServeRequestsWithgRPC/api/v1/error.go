@@ -3,6 +3,7 @@ package log_v1
 import (
 	"fmt"
 
+	logapiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -33,3 +34,70 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+// ErrConsistencyUnavailable is returned for a read this server can't serve at the consistency
+// the client asked for, such as a linearizable read from a follower. Another server may serve it.
+type ErrConsistencyUnavailable struct {
+	Consistency logapiv2.Consistency
+	Reason      string
+}
+
+func (e ErrConsistencyUnavailable) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("consistency unavailable: %s: %s", e.Consistency, e.Reason))
+	msg := fmt.Sprintf("This server can't serve reads at %s consistency: %s", e.Consistency, e.Reason)
+
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+
+	std, err := st.WithDetails(d)
+
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrConsistencyUnavailable) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrNotLeader is returned for a write sent to a follower that doesn't forward it. Leader is the
+// RPC address of the leader the client should send it to instead, empty while there is none.
+type ErrNotLeader struct {
+	Leader string
+}
+
+func (e ErrNotLeader) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("not the leader, the leader is %q", e.Leader))
+	msg := fmt.Sprintf("This server can't append records, send them to the leader at %q", e.Leader)
+	if e.Leader == "" {
+		st = status.New(codes.Unavailable, "not the leader, there is no leader")
+		msg = "This server can't append records and the cluster has no leader, retry later"
+	}
+
+	d := &errdetails.LocalizedMessage{
+		Locale:  "en-US",
+		Message: msg,
+	}
+	// Clients redirect with the leader's address from the machine readable detail
+	info := &errdetails.ErrorInfo{
+		Reason:   "NOT_LEADER",
+		Domain:   "grpc.log.v1",
+		Metadata: map[string]string{"leader": e.Leader},
+	}
+
+	std, err := st.WithDetails(d, info)
+
+	if err != nil {
+		return st
+	}
+
+	return std
+}
+
+func (e ErrNotLeader) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
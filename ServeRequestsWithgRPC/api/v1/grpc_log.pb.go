@@ -21,6 +21,119 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Compression int32
+
+const (
+	Compression_COMPRESSION_UNSPECIFIED Compression = 0
+	Compression_COMPRESSION_NONE        Compression = 1
+	Compression_COMPRESSION_GZIP        Compression = 2
+	Compression_COMPRESSION_ZLIB        Compression = 3
+	Compression_COMPRESSION_FLATE       Compression = 4
+)
+
+// Enum value maps for Compression.
+var (
+	Compression_name = map[int32]string{
+		0: "COMPRESSION_UNSPECIFIED",
+		1: "COMPRESSION_NONE",
+		2: "COMPRESSION_GZIP",
+		3: "COMPRESSION_ZLIB",
+		4: "COMPRESSION_FLATE",
+	}
+	Compression_value = map[string]int32{
+		"COMPRESSION_UNSPECIFIED": 0,
+		"COMPRESSION_NONE":        1,
+		"COMPRESSION_GZIP":        2,
+		"COMPRESSION_ZLIB":        3,
+		"COMPRESSION_FLATE":       4,
+	}
+)
+
+func (x Compression) Enum() *Compression {
+	p := new(Compression)
+	*p = x
+	return p
+}
+
+func (x Compression) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Compression) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_v1_grpc_log_proto_enumTypes[0].Descriptor()
+}
+
+func (Compression) Type() protoreflect.EnumType {
+	return &file_api_v1_grpc_log_proto_enumTypes[0]
+}
+
+func (x Compression) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Compression.Descriptor instead.
+func (Compression) EnumDescriptor() ([]byte, []int) {
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{0}
+}
+
+// Consistency is how up to date the server reading records must be. A follower only has the
+// records replicated to it so far, and a server that lost touch with the cluster may not know
+// it's behind. A server that can't meet the consistency fails the read, and the leader can meet all of them.
+type Consistency int32
+
+const (
+	Consistency_CONSISTENCY_UNSPECIFIED  Consistency = 0 // the same as CONSISTENCY_LOCAL
+	Consistency_CONSISTENCY_LOCAL        Consistency = 1 // whatever the server has, which may be stale on a follower
+	Consistency_CONSISTENCY_LEADER_LEASE Consistency = 2 // the leader, which steps down once it can't reach a quorum
+	Consistency_CONSISTENCY_LINEARIZABLE Consistency = 3 // the leader, after a quorum confirms it still is and it applied everything before the read
+	Consistency_CONSISTENCY_MAX_LAG      Consistency = 4 // any server that heard from the leader within max_lag
+)
+
+// Enum value maps for Consistency.
+var (
+	Consistency_name = map[int32]string{
+		0: "CONSISTENCY_UNSPECIFIED",
+		1: "CONSISTENCY_LOCAL",
+		2: "CONSISTENCY_LEADER_LEASE",
+		3: "CONSISTENCY_LINEARIZABLE",
+		4: "CONSISTENCY_MAX_LAG",
+	}
+	Consistency_value = map[string]int32{
+		"CONSISTENCY_UNSPECIFIED":  0,
+		"CONSISTENCY_LOCAL":        1,
+		"CONSISTENCY_LEADER_LEASE": 2,
+		"CONSISTENCY_LINEARIZABLE": 3,
+		"CONSISTENCY_MAX_LAG":      4,
+	}
+)
+
+func (x Consistency) Enum() *Consistency {
+	p := new(Consistency)
+	*p = x
+	return p
+}
+
+func (x Consistency) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Consistency) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_v1_grpc_log_proto_enumTypes[1].Descriptor()
+}
+
+func (Consistency) Type() protoreflect.EnumType {
+	return &file_api_v1_grpc_log_proto_enumTypes[1]
+}
+
+func (x Consistency) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Consistency.Descriptor instead.
+func (Consistency) EnumDescriptor() ([]byte, []int) {
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{1}
+}
+
 type ProduceRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Record        *Record                `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
@@ -109,16 +222,115 @@ func (x *ProduceResponse) GetOffset() uint64 {
 	return 0
 }
 
-type ConsumeRequest struct {
+type ProduceBatchRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	Records       []*Record              `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	Compression   Compression            `protobuf:"varint,2,opt,name=compression,proto3,enum=grpc.log.v1.Compression" json:"compression,omitempty"` // how the batch is compressed on disk; unspecified uses the log's setting
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProduceBatchRequest) Reset() {
+	*x = ProduceBatchRequest{}
+	mi := &file_api_v1_grpc_log_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProduceBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProduceBatchRequest) ProtoMessage() {}
+
+func (x *ProduceBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_grpc_log_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProduceBatchRequest.ProtoReflect.Descriptor instead.
+func (*ProduceBatchRequest) Descriptor() ([]byte, []int) {
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ProduceBatchRequest) GetRecords() []*Record {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *ProduceBatchRequest) GetCompression() Compression {
+	if x != nil {
+		return x.Compression
+	}
+	return Compression_COMPRESSION_UNSPECIFIED
+}
+
+type ProduceBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"` // offset of the first record; the rest follow contiguously
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
+func (x *ProduceBatchResponse) Reset() {
+	*x = ProduceBatchResponse{}
+	mi := &file_api_v1_grpc_log_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProduceBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProduceBatchResponse) ProtoMessage() {}
+
+func (x *ProduceBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_grpc_log_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProduceBatchResponse.ProtoReflect.Descriptor instead.
+func (*ProduceBatchResponse) Descriptor() ([]byte, []int) {
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ProduceBatchResponse) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type ConsumeRequest struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Offset            uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	AcceptCompression []Compression          `protobuf:"varint,2,rep,packed,name=accept_compression,json=acceptCompression,proto3,enum=grpc.log.v1.Compression" json:"accept_compression,omitempty"` // codecs the client can decode; ConsumeStream then sends batches
+	Consistency       Consistency            `protobuf:"varint,3,opt,name=consistency,proto3,enum=grpc.log.v1.Consistency" json:"consistency,omitempty"`                                             // how up to date the server must be; unspecified reads what it has
+	MaxLag            int64                  `protobuf:"varint,4,opt,name=max_lag,json=maxLag,proto3" json:"max_lag,omitempty"`                                                                      // nanoseconds a follower may be behind the leader with CONSISTENCY_MAX_LAG
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
 func (x *ConsumeRequest) Reset() {
 	*x = ConsumeRequest{}
-	mi := &file_api_v1_grpc_log_proto_msgTypes[2]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -130,7 +342,7 @@ func (x *ConsumeRequest) String() string {
 func (*ConsumeRequest) ProtoMessage() {}
 
 func (x *ConsumeRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_api_v1_grpc_log_proto_msgTypes[2]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -143,7 +355,7 @@ func (x *ConsumeRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ConsumeRequest.ProtoReflect.Descriptor instead.
 func (*ConsumeRequest) Descriptor() ([]byte, []int) {
-	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{2}
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *ConsumeRequest) GetOffset() uint64 {
@@ -153,16 +365,38 @@ func (x *ConsumeRequest) GetOffset() uint64 {
 	return 0
 }
 
+func (x *ConsumeRequest) GetAcceptCompression() []Compression {
+	if x != nil {
+		return x.AcceptCompression
+	}
+	return nil
+}
+
+func (x *ConsumeRequest) GetConsistency() Consistency {
+	if x != nil {
+		return x.Consistency
+	}
+	return Consistency_CONSISTENCY_UNSPECIFIED
+}
+
+func (x *ConsumeRequest) GetMaxLag() int64 {
+	if x != nil {
+		return x.MaxLag
+	}
+	return 0
+}
+
 type ConsumeResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Record        *Record                `protobuf:"bytes,2,opt,name=record,proto3" json:"record,omitempty"`
+	Batch         *RecordBatch           `protobuf:"bytes,3,opt,name=batch,proto3" json:"batch,omitempty"` // set instead of record when the client accepts a compression
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ConsumeResponse) Reset() {
 	*x = ConsumeResponse{}
-	mi := &file_api_v1_grpc_log_proto_msgTypes[3]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -174,7 +408,7 @@ func (x *ConsumeResponse) String() string {
 func (*ConsumeResponse) ProtoMessage() {}
 
 func (x *ConsumeResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_api_v1_grpc_log_proto_msgTypes[3]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -187,7 +421,7 @@ func (x *ConsumeResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ConsumeResponse.ProtoReflect.Descriptor instead.
 func (*ConsumeResponse) Descriptor() ([]byte, []int) {
-	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{3}
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *ConsumeResponse) GetRecord() *Record {
@@ -197,19 +431,83 @@ func (x *ConsumeResponse) GetRecord() *Record {
 	return nil
 }
 
+func (x *ConsumeResponse) GetBatch() *RecordBatch {
+	if x != nil {
+		return x.Batch
+	}
+	return nil
+}
+
+// RecordBatch holds consecutive records compressed together. Decompressed, data is a sequence
+// of records, each prefixed with its length as a uvarint; RecordBatch.Decode unpacks it.
+type RecordBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Compression   Compression            `protobuf:"varint,1,opt,name=compression,proto3,enum=grpc.log.v1.Compression" json:"compression,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordBatch) Reset() {
+	*x = RecordBatch{}
+	mi := &file_api_v1_grpc_log_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordBatch) ProtoMessage() {}
+
+func (x *RecordBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_grpc_log_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordBatch.ProtoReflect.Descriptor instead.
+func (*RecordBatch) Descriptor() ([]byte, []int) {
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RecordBatch) GetCompression() Compression {
+	if x != nil {
+		return x.Compression
+	}
+	return Compression_COMPRESSION_UNSPECIFIED
+}
+
+func (x *RecordBatch) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 type Record struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Value         []byte                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
 	Offset        uint64                 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
 	Term          uint64                 `protobuf:"varint,3,opt,name=term,proto3" json:"term,omitempty"`
 	Type          uint32                 `protobuf:"varint,4,opt,name=type,proto3" json:"type,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // append time in unix nanoseconds, assigned by the server; ignored on produce
+	Key           []byte                 `protobuf:"bytes,6,opt,name=key,proto3" json:"key,omitempty"`              // optional; compaction keeps only the newest record per key, and an empty value marks a tombstone
+	Headers       []*Header              `protobuf:"bytes,7,rep,name=headers,proto3" json:"headers,omitempty"`      // optional metadata like trace IDs or content types, kept as is
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Record) Reset() {
 	*x = Record{}
-	mi := &file_api_v1_grpc_log_proto_msgTypes[4]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -221,7 +519,7 @@ func (x *Record) String() string {
 func (*Record) ProtoMessage() {}
 
 func (x *Record) ProtoReflect() protoreflect.Message {
-	mi := &file_api_v1_grpc_log_proto_msgTypes[4]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -234,7 +532,7 @@ func (x *Record) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Record.ProtoReflect.Descriptor instead.
 func (*Record) Descriptor() ([]byte, []int) {
-	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{4}
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Record) GetValue() []byte {
@@ -265,6 +563,167 @@ func (x *Record) GetType() uint32 {
 	return 0
 }
 
+func (x *Record) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Record) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *Record) GetHeaders() []*Header {
+	if x != nil {
+		return x.Headers
+	}
+	return nil
+}
+
+type Header struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Header) Reset() {
+	*x = Header{}
+	mi := &file_api_v1_grpc_log_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Header) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Header) ProtoMessage() {}
+
+func (x *Header) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_grpc_log_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Header.ProtoReflect.Descriptor instead.
+func (*Header) Descriptor() ([]byte, []int) {
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Header) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Header) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+type OffsetForTimeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp     int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // unix nanoseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OffsetForTimeRequest) Reset() {
+	*x = OffsetForTimeRequest{}
+	mi := &file_api_v1_grpc_log_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OffsetForTimeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OffsetForTimeRequest) ProtoMessage() {}
+
+func (x *OffsetForTimeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_grpc_log_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OffsetForTimeRequest.ProtoReflect.Descriptor instead.
+func (*OffsetForTimeRequest) Descriptor() ([]byte, []int) {
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *OffsetForTimeRequest) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+type OffsetForTimeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OffsetForTimeResponse) Reset() {
+	*x = OffsetForTimeResponse{}
+	mi := &file_api_v1_grpc_log_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OffsetForTimeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OffsetForTimeResponse) ProtoMessage() {}
+
+func (x *OffsetForTimeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v1_grpc_log_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OffsetForTimeResponse.ProtoReflect.Descriptor instead.
+func (*OffsetForTimeResponse) Descriptor() ([]byte, []int) {
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *OffsetForTimeResponse) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
 type GetServersRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -273,7 +732,7 @@ type GetServersRequest struct {
 
 func (x *GetServersRequest) Reset() {
 	*x = GetServersRequest{}
-	mi := &file_api_v1_grpc_log_proto_msgTypes[5]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -285,7 +744,7 @@ func (x *GetServersRequest) String() string {
 func (*GetServersRequest) ProtoMessage() {}
 
 func (x *GetServersRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_api_v1_grpc_log_proto_msgTypes[5]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -298,7 +757,7 @@ func (x *GetServersRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetServersRequest.ProtoReflect.Descriptor instead.
 func (*GetServersRequest) Descriptor() ([]byte, []int) {
-	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{5}
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{11}
 }
 
 type GetServersResponse struct {
@@ -310,7 +769,7 @@ type GetServersResponse struct {
 
 func (x *GetServersResponse) Reset() {
 	*x = GetServersResponse{}
-	mi := &file_api_v1_grpc_log_proto_msgTypes[6]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -322,7 +781,7 @@ func (x *GetServersResponse) String() string {
 func (*GetServersResponse) ProtoMessage() {}
 
 func (x *GetServersResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_api_v1_grpc_log_proto_msgTypes[6]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -335,7 +794,7 @@ func (x *GetServersResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetServersResponse.ProtoReflect.Descriptor instead.
 func (*GetServersResponse) Descriptor() ([]byte, []int) {
-	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{6}
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *GetServersResponse) GetServers() []*Server {
@@ -356,7 +815,7 @@ type Server struct {
 
 func (x *Server) Reset() {
 	*x = Server{}
-	mi := &file_api_v1_grpc_log_proto_msgTypes[7]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -368,7 +827,7 @@ func (x *Server) String() string {
 func (*Server) ProtoMessage() {}
 
 func (x *Server) ProtoReflect() protoreflect.Message {
-	mi := &file_api_v1_grpc_log_proto_msgTypes[7]
+	mi := &file_api_v1_grpc_log_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -381,7 +840,7 @@ func (x *Server) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Server.ProtoReflect.Descriptor instead.
 func (*Server) Descriptor() ([]byte, []int) {
-	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{7}
+	return file_api_v1_grpc_log_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *Server) GetId() string {
@@ -413,30 +872,66 @@ const file_api_v1_grpc_log_proto_rawDesc = "" +
 	"\x0eProduceRequest\x12+\n" +
 	"\x06record\x18\x01 \x01(\v2\x13.grpc.log.v1.RecordR\x06record\")\n" +
 	"\x0fProduceResponse\x12\x16\n" +
-	"\x06offset\x18\x01 \x01(\x04R\x06offset\"(\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\"\x80\x01\n" +
+	"\x13ProduceBatchRequest\x12-\n" +
+	"\arecords\x18\x01 \x03(\v2\x13.grpc.log.v1.RecordR\arecords\x12:\n" +
+	"\vcompression\x18\x02 \x01(\x0e2\x18.grpc.log.v1.CompressionR\vcompression\".\n" +
+	"\x14ProduceBatchResponse\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\"\xc6\x01\n" +
 	"\x0eConsumeRequest\x12\x16\n" +
-	"\x06offset\x18\x01 \x01(\x04R\x06offset\">\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\x12G\n" +
+	"\x12accept_compression\x18\x02 \x03(\x0e2\x18.grpc.log.v1.CompressionR\x11acceptCompression\x12:\n" +
+	"\vconsistency\x18\x03 \x01(\x0e2\x18.grpc.log.v1.ConsistencyR\vconsistency\x12\x17\n" +
+	"\amax_lag\x18\x04 \x01(\x03R\x06maxLag\"n\n" +
 	"\x0fConsumeResponse\x12+\n" +
-	"\x06record\x18\x02 \x01(\v2\x13.grpc.log.v1.RecordR\x06record\"^\n" +
+	"\x06record\x18\x02 \x01(\v2\x13.grpc.log.v1.RecordR\x06record\x12.\n" +
+	"\x05batch\x18\x03 \x01(\v2\x18.grpc.log.v1.RecordBatchR\x05batch\"]\n" +
+	"\vRecordBatch\x12:\n" +
+	"\vcompression\x18\x01 \x01(\x0e2\x18.grpc.log.v1.CompressionR\vcompression\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"\xbd\x01\n" +
 	"\x06Record\x12\x14\n" +
 	"\x05value\x18\x01 \x01(\fR\x05value\x12\x16\n" +
 	"\x06offset\x18\x02 \x01(\x04R\x06offset\x12\x12\n" +
 	"\x04term\x18\x03 \x01(\x04R\x04term\x12\x12\n" +
-	"\x04type\x18\x04 \x01(\rR\x04type\"\x13\n" +
+	"\x04type\x18\x04 \x01(\rR\x04type\x12\x1c\n" +
+	"\ttimestamp\x18\x05 \x01(\x03R\ttimestamp\x12\x10\n" +
+	"\x03key\x18\x06 \x01(\fR\x03key\x12-\n" +
+	"\aheaders\x18\a \x03(\v2\x13.grpc.log.v1.HeaderR\aheaders\"0\n" +
+	"\x06Header\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\"4\n" +
+	"\x14OffsetForTimeRequest\x12\x1c\n" +
+	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp\"/\n" +
+	"\x15OffsetForTimeResponse\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\"\x13\n" +
 	"\x11GetServersRequest\"C\n" +
 	"\x12GetServersResponse\x12-\n" +
 	"\aservers\x18\x01 \x03(\v2\x13.grpc.log.v1.ServerR\aservers\"P\n" +
 	"\x06Server\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x19\n" +
 	"\brpc_addr\x18\x02 \x01(\tR\arpcAddr\x12\x1b\n" +
-	"\tis_leader\x18\x03 \x01(\bR\bisLeader2\x88\x03\n" +
+	"\tis_leader\x18\x03 \x01(\bR\bisLeader*\x83\x01\n" +
+	"\vCompression\x12\x1b\n" +
+	"\x17COMPRESSION_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10COMPRESSION_NONE\x10\x01\x12\x14\n" +
+	"\x10COMPRESSION_GZIP\x10\x02\x12\x14\n" +
+	"\x10COMPRESSION_ZLIB\x10\x03\x12\x15\n" +
+	"\x11COMPRESSION_FLATE\x10\x04*\x96\x01\n" +
+	"\vConsistency\x12\x1b\n" +
+	"\x17CONSISTENCY_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11CONSISTENCY_LOCAL\x10\x01\x12\x1c\n" +
+	"\x18CONSISTENCY_LEADER_LEASE\x10\x02\x12\x1c\n" +
+	"\x18CONSISTENCY_LINEARIZABLE\x10\x03\x12\x17\n" +
+	"\x13CONSISTENCY_MAX_LAG\x10\x042\xb9\x04\n" +
 	"\x03Log\x12F\n" +
-	"\aProduce\x12\x1b.grpc.log.v1.ProduceRequest\x1a\x1c.grpc.log.v1.ProduceResponse\"\x00\x12F\n" +
+	"\aProduce\x12\x1b.grpc.log.v1.ProduceRequest\x1a\x1c.grpc.log.v1.ProduceResponse\"\x00\x12U\n" +
+	"\fProduceBatch\x12 .grpc.log.v1.ProduceBatchRequest\x1a!.grpc.log.v1.ProduceBatchResponse\"\x00\x12F\n" +
 	"\aConsume\x12\x1b.grpc.log.v1.ConsumeRequest\x1a\x1c.grpc.log.v1.ConsumeResponse\"\x00\x12N\n" +
 	"\rConsumeStream\x12\x1b.grpc.log.v1.ConsumeRequest\x1a\x1c.grpc.log.v1.ConsumeResponse\"\x000\x01\x12P\n" +
 	"\rProduceStream\x12\x1b.grpc.log.v1.ProduceRequest\x1a\x1c.grpc.log.v1.ProduceResponse\"\x00(\x010\x01\x12O\n" +
 	"\n" +
-	"GetServers\x12\x1e.grpc.log.v1.GetServersRequest\x1a\x1f.grpc.log.v1.GetServersResponse\"\x00BRZPgithub.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1;log_v1b\x06proto3"
+	"GetServers\x12\x1e.grpc.log.v1.GetServersRequest\x1a\x1f.grpc.log.v1.GetServersResponse\"\x00\x12X\n" +
+	"\rOffsetForTime\x12!.grpc.log.v1.OffsetForTimeRequest\x1a\".grpc.log.v1.OffsetForTimeResponse\"\x00BRZPgithub.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1;log_v1b\x06proto3"
 
 var (
 	file_api_v1_grpc_log_proto_rawDescOnce sync.Once
@@ -450,36 +945,56 @@ func file_api_v1_grpc_log_proto_rawDescGZIP() []byte {
 	return file_api_v1_grpc_log_proto_rawDescData
 }
 
-var file_api_v1_grpc_log_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_api_v1_grpc_log_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_api_v1_grpc_log_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
 var file_api_v1_grpc_log_proto_goTypes = []any{
-	(*ProduceRequest)(nil),     // 0: grpc.log.v1.ProduceRequest
-	(*ProduceResponse)(nil),    // 1: grpc.log.v1.ProduceResponse
-	(*ConsumeRequest)(nil),     // 2: grpc.log.v1.ConsumeRequest
-	(*ConsumeResponse)(nil),    // 3: grpc.log.v1.ConsumeResponse
-	(*Record)(nil),             // 4: grpc.log.v1.Record
-	(*GetServersRequest)(nil),  // 5: grpc.log.v1.GetServersRequest
-	(*GetServersResponse)(nil), // 6: grpc.log.v1.GetServersResponse
-	(*Server)(nil),             // 7: grpc.log.v1.Server
+	(Compression)(0),              // 0: grpc.log.v1.Compression
+	(Consistency)(0),              // 1: grpc.log.v1.Consistency
+	(*ProduceRequest)(nil),        // 2: grpc.log.v1.ProduceRequest
+	(*ProduceResponse)(nil),       // 3: grpc.log.v1.ProduceResponse
+	(*ProduceBatchRequest)(nil),   // 4: grpc.log.v1.ProduceBatchRequest
+	(*ProduceBatchResponse)(nil),  // 5: grpc.log.v1.ProduceBatchResponse
+	(*ConsumeRequest)(nil),        // 6: grpc.log.v1.ConsumeRequest
+	(*ConsumeResponse)(nil),       // 7: grpc.log.v1.ConsumeResponse
+	(*RecordBatch)(nil),           // 8: grpc.log.v1.RecordBatch
+	(*Record)(nil),                // 9: grpc.log.v1.Record
+	(*Header)(nil),                // 10: grpc.log.v1.Header
+	(*OffsetForTimeRequest)(nil),  // 11: grpc.log.v1.OffsetForTimeRequest
+	(*OffsetForTimeResponse)(nil), // 12: grpc.log.v1.OffsetForTimeResponse
+	(*GetServersRequest)(nil),     // 13: grpc.log.v1.GetServersRequest
+	(*GetServersResponse)(nil),    // 14: grpc.log.v1.GetServersResponse
+	(*Server)(nil),                // 15: grpc.log.v1.Server
 }
 var file_api_v1_grpc_log_proto_depIdxs = []int32{
-	4, // 0: grpc.log.v1.ProduceRequest.record:type_name -> grpc.log.v1.Record
-	4, // 1: grpc.log.v1.ConsumeResponse.record:type_name -> grpc.log.v1.Record
-	7, // 2: grpc.log.v1.GetServersResponse.servers:type_name -> grpc.log.v1.Server
-	0, // 3: grpc.log.v1.Log.Produce:input_type -> grpc.log.v1.ProduceRequest
-	2, // 4: grpc.log.v1.Log.Consume:input_type -> grpc.log.v1.ConsumeRequest
-	2, // 5: grpc.log.v1.Log.ConsumeStream:input_type -> grpc.log.v1.ConsumeRequest
-	0, // 6: grpc.log.v1.Log.ProduceStream:input_type -> grpc.log.v1.ProduceRequest
-	5, // 7: grpc.log.v1.Log.GetServers:input_type -> grpc.log.v1.GetServersRequest
-	1, // 8: grpc.log.v1.Log.Produce:output_type -> grpc.log.v1.ProduceResponse
-	3, // 9: grpc.log.v1.Log.Consume:output_type -> grpc.log.v1.ConsumeResponse
-	3, // 10: grpc.log.v1.Log.ConsumeStream:output_type -> grpc.log.v1.ConsumeResponse
-	1, // 11: grpc.log.v1.Log.ProduceStream:output_type -> grpc.log.v1.ProduceResponse
-	6, // 12: grpc.log.v1.Log.GetServers:output_type -> grpc.log.v1.GetServersResponse
-	8, // [8:13] is the sub-list for method output_type
-	3, // [3:8] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	9,  // 0: grpc.log.v1.ProduceRequest.record:type_name -> grpc.log.v1.Record
+	9,  // 1: grpc.log.v1.ProduceBatchRequest.records:type_name -> grpc.log.v1.Record
+	0,  // 2: grpc.log.v1.ProduceBatchRequest.compression:type_name -> grpc.log.v1.Compression
+	0,  // 3: grpc.log.v1.ConsumeRequest.accept_compression:type_name -> grpc.log.v1.Compression
+	1,  // 4: grpc.log.v1.ConsumeRequest.consistency:type_name -> grpc.log.v1.Consistency
+	9,  // 5: grpc.log.v1.ConsumeResponse.record:type_name -> grpc.log.v1.Record
+	8,  // 6: grpc.log.v1.ConsumeResponse.batch:type_name -> grpc.log.v1.RecordBatch
+	0,  // 7: grpc.log.v1.RecordBatch.compression:type_name -> grpc.log.v1.Compression
+	10, // 8: grpc.log.v1.Record.headers:type_name -> grpc.log.v1.Header
+	15, // 9: grpc.log.v1.GetServersResponse.servers:type_name -> grpc.log.v1.Server
+	2,  // 10: grpc.log.v1.Log.Produce:input_type -> grpc.log.v1.ProduceRequest
+	4,  // 11: grpc.log.v1.Log.ProduceBatch:input_type -> grpc.log.v1.ProduceBatchRequest
+	6,  // 12: grpc.log.v1.Log.Consume:input_type -> grpc.log.v1.ConsumeRequest
+	6,  // 13: grpc.log.v1.Log.ConsumeStream:input_type -> grpc.log.v1.ConsumeRequest
+	2,  // 14: grpc.log.v1.Log.ProduceStream:input_type -> grpc.log.v1.ProduceRequest
+	13, // 15: grpc.log.v1.Log.GetServers:input_type -> grpc.log.v1.GetServersRequest
+	11, // 16: grpc.log.v1.Log.OffsetForTime:input_type -> grpc.log.v1.OffsetForTimeRequest
+	3,  // 17: grpc.log.v1.Log.Produce:output_type -> grpc.log.v1.ProduceResponse
+	5,  // 18: grpc.log.v1.Log.ProduceBatch:output_type -> grpc.log.v1.ProduceBatchResponse
+	7,  // 19: grpc.log.v1.Log.Consume:output_type -> grpc.log.v1.ConsumeResponse
+	7,  // 20: grpc.log.v1.Log.ConsumeStream:output_type -> grpc.log.v1.ConsumeResponse
+	3,  // 21: grpc.log.v1.Log.ProduceStream:output_type -> grpc.log.v1.ProduceResponse
+	14, // 22: grpc.log.v1.Log.GetServers:output_type -> grpc.log.v1.GetServersResponse
+	12, // 23: grpc.log.v1.Log.OffsetForTime:output_type -> grpc.log.v1.OffsetForTimeResponse
+	17, // [17:24] is the sub-list for method output_type
+	10, // [10:17] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_api_v1_grpc_log_proto_init() }
@@ -492,13 +1007,14 @@ func file_api_v1_grpc_log_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_v1_grpc_log_proto_rawDesc), len(file_api_v1_grpc_log_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   8,
+			NumEnums:      2,
+			NumMessages:   14,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_api_v1_grpc_log_proto_goTypes,
 		DependencyIndexes: file_api_v1_grpc_log_proto_depIdxs,
+		EnumInfos:         file_api_v1_grpc_log_proto_enumTypes,
 		MessageInfos:      file_api_v1_grpc_log_proto_msgTypes,
 	}.Build()
 	File_api_v1_grpc_log_proto = out.File
@@ -20,10 +20,12 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	Log_Produce_FullMethodName       = "/grpc.log.v1.Log/Produce"
+	Log_ProduceBatch_FullMethodName  = "/grpc.log.v1.Log/ProduceBatch"
 	Log_Consume_FullMethodName       = "/grpc.log.v1.Log/Consume"
 	Log_ConsumeStream_FullMethodName = "/grpc.log.v1.Log/ConsumeStream"
 	Log_ProduceStream_FullMethodName = "/grpc.log.v1.Log/ProduceStream"
 	Log_GetServers_FullMethodName    = "/grpc.log.v1.Log/GetServers"
+	Log_OffsetForTime_FullMethodName = "/grpc.log.v1.Log/OffsetForTime"
 )
 
 // LogClient is the client API for Log service.
@@ -31,10 +33,12 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LogClient interface {
 	Produce(ctx context.Context, in *ProduceRequest, opts ...grpc.CallOption) (*ProduceResponse, error)
+	ProduceBatch(ctx context.Context, in *ProduceBatchRequest, opts ...grpc.CallOption) (*ProduceBatchResponse, error)
 	Consume(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (*ConsumeResponse, error)
 	ConsumeStream(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ConsumeResponse], error)
 	ProduceStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ProduceRequest, ProduceResponse], error)
 	GetServers(ctx context.Context, in *GetServersRequest, opts ...grpc.CallOption) (*GetServersResponse, error)
+	OffsetForTime(ctx context.Context, in *OffsetForTimeRequest, opts ...grpc.CallOption) (*OffsetForTimeResponse, error)
 }
 
 type logClient struct {
@@ -55,6 +59,16 @@ func (c *logClient) Produce(ctx context.Context, in *ProduceRequest, opts ...grp
 	return out, nil
 }
 
+func (c *logClient) ProduceBatch(ctx context.Context, in *ProduceBatchRequest, opts ...grpc.CallOption) (*ProduceBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ProduceBatchResponse)
+	err := c.cc.Invoke(ctx, Log_ProduceBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *logClient) Consume(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (*ConsumeResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(ConsumeResponse)
@@ -107,15 +121,27 @@ func (c *logClient) GetServers(ctx context.Context, in *GetServersRequest, opts
 	return out, nil
 }
 
+func (c *logClient) OffsetForTime(ctx context.Context, in *OffsetForTimeRequest, opts ...grpc.CallOption) (*OffsetForTimeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(OffsetForTimeResponse)
+	err := c.cc.Invoke(ctx, Log_OffsetForTime_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LogServer is the server API for Log service.
 // All implementations must embed UnimplementedLogServer
 // for forward compatibility.
 type LogServer interface {
 	Produce(context.Context, *ProduceRequest) (*ProduceResponse, error)
+	ProduceBatch(context.Context, *ProduceBatchRequest) (*ProduceBatchResponse, error)
 	Consume(context.Context, *ConsumeRequest) (*ConsumeResponse, error)
 	ConsumeStream(*ConsumeRequest, grpc.ServerStreamingServer[ConsumeResponse]) error
 	ProduceStream(grpc.BidiStreamingServer[ProduceRequest, ProduceResponse]) error
 	GetServers(context.Context, *GetServersRequest) (*GetServersResponse, error)
+	OffsetForTime(context.Context, *OffsetForTimeRequest) (*OffsetForTimeResponse, error)
 	mustEmbedUnimplementedLogServer()
 }
 
@@ -129,6 +155,9 @@ type UnimplementedLogServer struct{}
 func (UnimplementedLogServer) Produce(context.Context, *ProduceRequest) (*ProduceResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Produce not implemented")
 }
+func (UnimplementedLogServer) ProduceBatch(context.Context, *ProduceBatchRequest) (*ProduceBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ProduceBatch not implemented")
+}
 func (UnimplementedLogServer) Consume(context.Context, *ConsumeRequest) (*ConsumeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Consume not implemented")
 }
@@ -141,6 +170,9 @@ func (UnimplementedLogServer) ProduceStream(grpc.BidiStreamingServer[ProduceRequ
 func (UnimplementedLogServer) GetServers(context.Context, *GetServersRequest) (*GetServersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetServers not implemented")
 }
+func (UnimplementedLogServer) OffsetForTime(context.Context, *OffsetForTimeRequest) (*OffsetForTimeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method OffsetForTime not implemented")
+}
 func (UnimplementedLogServer) mustEmbedUnimplementedLogServer() {}
 func (UnimplementedLogServer) testEmbeddedByValue()             {}
 
@@ -180,6 +212,24 @@ func _Log_Produce_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Log_ProduceBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProduceBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).ProduceBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_ProduceBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).ProduceBatch(ctx, req.(*ProduceBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Log_Consume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ConsumeRequest)
 	if err := dec(in); err != nil {
@@ -234,6 +284,24 @@ func _Log_GetServers_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Log_OffsetForTime_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OffsetForTimeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).OffsetForTime(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_OffsetForTime_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).OffsetForTime(ctx, req.(*OffsetForTimeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Log_ServiceDesc is the grpc.ServiceDesc for Log service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -245,6 +313,10 @@ var Log_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Produce",
 			Handler:    _Log_Produce_Handler,
 		},
+		{
+			MethodName: "ProduceBatch",
+			Handler:    _Log_ProduceBatch_Handler,
+		},
 		{
 			MethodName: "Consume",
 			Handler:    _Log_Consume_Handler,
@@ -253,6 +325,10 @@ var Log_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetServers",
 			Handler:    _Log_GetServers_Handler,
 		},
+		{
+			MethodName: "OffsetForTime",
+			Handler:    _Log_OffsetForTime_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -0,0 +1,69 @@
+package log_v1
+
+import (
+	logapi "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	logapiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
+)
+
+// This package is the v1 API, kept for producers and consumers deployed against it. Its Record
+// is encoded like the canonical StructureDataWithProtobuf record, field for field, but is a Go
+// type of its own that is converted here, and only here, at the API boundary.
+
+// Canonical returns the record as the canonical type the log, the Raft FSM and the v2 API share.
+func (r *Record) Canonical() *logapi.Record {
+	if r == nil {
+		return nil
+	}
+
+	record := &logapi.Record{
+		Value:     r.Value,
+		Offset:    r.Offset,
+		Term:      r.Term,
+		Type:      r.Type,
+		Timestamp: r.Timestamp,
+		Key:       r.Key,
+	}
+	for _, h := range r.Headers {
+		record.Headers = append(record.Headers, &logapi.Header{Key: h.Key, Value: h.Value})
+	}
+	return record
+}
+
+// NewRecord returns the v1 form of a canonical record.
+func NewRecord(r *logapi.Record) *Record {
+	if r == nil {
+		return nil
+	}
+
+	record := &Record{
+		Value:     r.Value,
+		Offset:    r.Offset,
+		Term:      r.Term,
+		Type:      r.Type,
+		Timestamp: r.Timestamp,
+		Key:       r.Key,
+	}
+	for _, h := range r.Headers {
+		record.Headers = append(record.Headers, &Header{Key: h.Key, Value: h.Value})
+	}
+	return record
+}
+
+// CanonicalRecords converts the records with Canonical.
+func CanonicalRecords(records []*Record) []*logapi.Record {
+	converted := make([]*logapi.Record, len(records))
+	for i, r := range records {
+		converted[i] = r.Canonical()
+	}
+	return converted
+}
+
+// Canonical returns the compression as the v2 API's, which numbers them the same.
+func (c Compression) Canonical() logapiv2.Compression {
+	return logapiv2.Compression(c)
+}
+
+// Canonical returns the consistency as the v2 API's, which numbers them the same.
+func (c Consistency) Canonical() logapiv2.Consistency {
+	return logapiv2.Consistency(c)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+
+	api "github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+	* Only the Raft leader appends records. A follower's CommitLog fails appends with
+	  api.ErrNotLeader, which carries the leader's RPC address.
+	* With Config.ForwardProduce set, the follower sends the request on to the leader over
+	  Config.PeerTLSConfig and returns the leader's response, so clients can produce to any
+	  server. Without it the client gets the api.ErrNotLeader and redirects itself.
+	* The leader sees the follower's certificate, not the client's. The follower names the
+	  client's subject in the forwardedSubjectKey metadata, and the leader authorizes the request
+	  as that subject if the follower's own subject may forward. A forwarded request is never
+	  forwarded again, so servers that disagree on the leader can't pass it around in a loop.
+*/
+
+const (
+	forwardAction = "forward"
+
+	// forwardedSubjectKey is the metadata naming the subject of the client a request was forwarded for
+	forwardedSubjectKey = "x-forwarded-subject"
+)
+
+type forwardedContextKey struct{}
+
+// forwarder dials the leaders that produce requests are forwarded to.
+type forwarder struct {
+	creds credentials.TransportCredentials
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn // by leader address
+}
+
+func newForwarder(tlsConfig *tls.Config) *forwarder {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return &forwarder{creds: creds, conns: make(map[string]*grpc.ClientConn)}
+}
+
+// client returns a client of the leader at addr. It keeps a connection per server that led, rather
+// than closing the last leader's when another one leads, since requests forwarded to the last
+// leader may still be using it. A cluster only has so many servers.
+func (f *forwarder) client(addr string) (apiv2.LogClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	conn, ok := f.conns[addr]
+	if !ok {
+		var err error
+		if conn, err = grpc.NewClient(addr, grpc.WithTransportCredentials(f.creds)); err != nil {
+			return nil, err
+		}
+		f.conns[addr] = conn
+	}
+	return apiv2.NewLogClient(conn), nil
+}
+
+// leader returns the address to forward a request that failed with err to, if it should be.
+func (s *grpcServerV2) leader(ctx context.Context, err error) (string, bool) {
+	var notLeader api.ErrNotLeader
+	if s.forwarder == nil || !errors.As(err, &notLeader) || notLeader.Leader == "" {
+		return "", false
+	}
+	if forwarded, _ := ctx.Value(forwardedContextKey{}).(bool); forwarded {
+		return "", false
+	}
+	return notLeader.Leader, true
+}
+
+// forwardContext returns the context to forward a request with, naming the subject it's for.
+func forwardContext(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, forwardedSubjectKey, subject(ctx))
+}
+
+// authenticateForwarded sets the subject of a request a follower forwarded to the subject of the
+// client it forwarded it for, if the follower may forward requests.
+func authenticateForwarded(authorizer Authorizer) func(context.Context) (context.Context, error) {
+	return func(ctx context.Context) (context.Context, error) {
+		ctx, err := authenticate(ctx)
+		if err != nil {
+			return ctx, err
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		forwarded := md.Get(forwardedSubjectKey)
+		if len(forwarded) == 0 {
+			return ctx, nil
+		}
+		if err := authorizer.Authorize(subject(ctx), objectWildcard, forwardAction); err != nil {
+			return ctx, err
+		}
+
+		ctx = context.WithValue(ctx, subjectContextKey{}, forwarded[0])
+		return context.WithValue(ctx, forwardedContextKey{}, true), nil
+	}
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"time"
 
 	api "github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1"
+	logapi "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
 	"google.golang.org/grpc"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"      // for chaining multiple interceptors
@@ -27,12 +30,24 @@ import (
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-// ensure grpcServer satisfies the api.LogServer interface
-var _ api.LogServer = (*grpcServer)(nil)
+// ensure grpcServer and grpcServerV2 satisfy the LogServer interfaces of both API versions
+var (
+	_ api.LogServer   = (*grpcServer)(nil)
+	_ apiv2.LogServer = (*grpcServerV2)(nil)
+)
 
+// CommitLog stores the canonical records every API version is converted to.
 type CommitLog interface {
-	Append(*api.Record) (uint64, error)
-	Read(uint64) (*api.Record, error)
+	Append(*logapi.Record) (uint64, error)
+	AppendBatch([]*logapi.Record, apiv2.Compression) (uint64, error)
+	Read(uint64) (*logapi.Record, error)
+	OffsetForTime(time.Time) (uint64, error)
+	// Wait blocks until the log holds the given offset or ctx is done, so streams don't poll Read.
+	Wait(context.Context, uint64) error
+	// CheckConsistency returns once reads from the log meet the consistency, or an
+	// api.ErrConsistencyUnavailable if this server can't meet it. maxLag bounds
+	// how far behind a CONSISTENCY_MAX_LAG read may be.
+	CheckConsistency(ctx context.Context, c apiv2.Consistency, maxLag time.Duration) error
 }
 
 type Authorizer interface {
@@ -40,13 +55,25 @@ type Authorizer interface {
 }
 
 type GetServerer interface {
-	GetServers() ([]*api.Server, error)
+	GetServers() ([]*apiv2.Server, error)
 }
 
 type Config struct {
 	CommitLog  CommitLog
 	Authorizer Authorizer
 	GetServers GetServerer
+
+	// ConsumeBatchSize caps how many records ConsumeStream packs into a compressed batch.
+	// It's maxBatchRecords when 0.
+	ConsumeBatchSize int
+	// ConsumeMaxWait is how long ConsumeStream holds a compressed batch that isn't full
+	// for more records. It sends the records it has right away when 0.
+	ConsumeMaxWait time.Duration
+
+	// ForwardProduce has a follower forward the records produced to it to the leader, rather
+	// than fail with api.ErrNotLeader. It dials the leader with PeerTLSConfig, or in plaintext when nil.
+	ForwardProduce bool
+	PeerTLSConfig  *tls.Config
 }
 
 type subjectContextKey struct{}
@@ -55,18 +82,25 @@ const (
 	objectWildcard = "*"
 	produceAction  = "produce"
 	consumeAction  = "consume"
+
+	// maxBatchRecords is the default for Config.ConsumeBatchSize
+	maxBatchRecords = 100
 )
 
+// grpcServer serves the v1 API by converting its requests for grpcServerV2, which does the work.
 type grpcServer struct {
 	*Config
 	// used to ensure forward compatibility when adding methods to the service.
 	*api.UnimplementedLogServer
+
+	v2 *grpcServerV2
 }
 
 func newgrpcServer(config *Config) (srv *grpcServer, err error) {
 	srv = &grpcServer{
 		Config:                 config,
 		UnimplementedLogServer: &api.UnimplementedLogServer{},
+		v2:                     newgrpcServerV2(config),
 	}
 	return srv, err
 }
@@ -93,7 +127,7 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		grpc_middleware.ChainStreamServer(
 			grpc_ctxtags.StreamServerInterceptor(),
 			grpc_zap.StreamServerInterceptor(logger, zapOpts...),
-			grpc_auth.StreamServerInterceptor(authenticate),
+			grpc_auth.StreamServerInterceptor(authenticateForwarded(config.Authorizer)),
 		),
 	))
 
@@ -102,7 +136,7 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		grpc_middleware.ChainUnaryServer(
 			grpc_ctxtags.UnaryServerInterceptor(),
 			grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
-			grpc_auth.UnaryServerInterceptor(authenticate),
+			grpc_auth.UnaryServerInterceptor(authenticateForwarded(config.Authorizer)),
 		),
 	))
 
@@ -121,33 +155,42 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		return nil, err
 	}
 
+	// Both API versions are served side by side
 	api.RegisterLogServer(gsrv, srv)
+	apiv2.RegisterLogServer(gsrv, srv.v2)
 
 	return gsrv, nil
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
-	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, produceAction); err != nil {
+	res, err := s.v2.Produce(ctx, &apiv2.ProduceRequest{Record: req.Record.Canonical()})
+	if err != nil {
 		return nil, err
 	}
+	return &api.ProduceResponse{Offset: res.Offset}, nil
+}
 
-	offset, err := s.CommitLog.Append(req.Record)
+func (s *grpcServer) ProduceBatch(ctx context.Context, req *api.ProduceBatchRequest) (*api.ProduceBatchResponse, error) {
+	res, err := s.v2.ProduceBatch(ctx, &apiv2.ProduceBatchRequest{
+		Records:     api.CanonicalRecords(req.Records),
+		Compression: req.Compression.Canonical(),
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &api.ProduceResponse{Offset: offset}, nil
+	return &api.ProduceBatchResponse{Offset: res.Offset}, nil
 }
 
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
-	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, consumeAction); err != nil {
-		return nil, err
-	}
-
-	record, err := s.CommitLog.Read(req.Offset)
+	res, err := s.v2.Consume(ctx, &apiv2.ConsumeRequest{
+		Offset:      req.Offset,
+		Consistency: req.Consistency.Canonical(),
+		MaxLag:      req.MaxLag,
+	})
 	if err != nil {
-		return nil, api.ErrOffsetOutOfRange{Offset: req.Offset}
+		return nil, err
 	}
-	return &api.ConsumeResponse{Record: record}, nil
+	return &api.ConsumeResponse{Record: api.NewRecord(res.Record)}, nil
 }
 
 func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
@@ -170,32 +213,52 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 }
 
 func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
-	for {
-		select {
-		case <-stream.Context().Done():
-			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
-			case nil:
-			case api.ErrOffsetOutOfRange:
-				continue
-			default:
-				return err
-			}
-			if err = stream.Send(res); err != nil {
-				return err
-			}
-			req.Offset++
+	accept := make([]apiv2.Compression, len(req.AcceptCompression))
+	for i, c := range req.AcceptCompression {
+		accept[i] = c.Canonical()
+	}
+	compression := api.Compression(negotiateCompression(accept))
+
+	consume := &apiv2.ConsumeRequest{
+		Offset:      req.Offset,
+		Consistency: req.Consistency.Canonical(),
+		MaxLag:      req.MaxLag,
+	}
+	return s.v2.consumeStream(stream.Context(), consume, compression.Canonical(), func(records []*logapi.Record) error {
+		if compression == api.Compression_COMPRESSION_UNSPECIFIED {
+			return stream.Send(&api.ConsumeResponse{Record: api.NewRecord(records[0])})
+		}
+
+		converted := make([]*api.Record, len(records))
+		for i, record := range records {
+			converted[i] = api.NewRecord(record)
+		}
+		batch, err := api.NewRecordBatch(converted, compression)
+		if err != nil {
+			return err
 		}
+		return stream.Send(&api.ConsumeResponse{Batch: batch})
+	})
+}
+
+func (s *grpcServer) OffsetForTime(ctx context.Context, req *api.OffsetForTimeRequest) (*api.OffsetForTimeResponse, error) {
+	res, err := s.v2.OffsetForTime(ctx, &apiv2.OffsetForTimeRequest{Timestamp: req.Timestamp})
+	if err != nil {
+		return nil, err
 	}
+	return &api.OffsetForTimeResponse{Offset: res.Offset}, nil
 }
 
 func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
-	servers, err := s.Config.GetServers.GetServers()
+	res, err := s.v2.GetServers(ctx, &apiv2.GetServersRequest{})
 	if err != nil {
 		return nil, err
 	}
+
+	servers := make([]*api.Server, len(res.Servers))
+	for i, server := range res.Servers {
+		servers[i] = &api.Server{Id: server.Id, RpcAddr: server.RpcAddr, IsLeader: server.IsLeader}
+	}
 	return &api.GetServersResponse{Servers: servers}, nil
 }
 
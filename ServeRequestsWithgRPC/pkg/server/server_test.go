@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"net"
 	"os"
@@ -9,13 +10,18 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	"google.golang.org/grpc/credentials"
 
 	api "github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1"
+	logapi "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
 	"github.com/GergesHany/Event-Streaming-System/WriteALogPackage/log"
 
 	auth "github.com/GergesHany/Event-Streaming-System/SecurityAndObservability/pkg/auth"
@@ -45,16 +51,20 @@ func TestServer(t *testing.T) {
 		"produce/consume stream succeeds":                    testProduceConsumeStream,
 		"consume past log boundary fails":                    testConsumePastBoundary,
 		"unauthorized fails":                                 testUnauthorized,
+		"offset for time":                                    testOffsetForTime,
+		"produce batch":                                      testProduceBatch,
+		"consume stream compressed":                          testConsumeStreamCompressed,
+		"consume stream waits for records":                   testConsumeStreamWaits,
 	} {
 		t.Run(scenario, func(t *testing.T) {
-			rootClient, nobodyClient, cfg, teardown := setupTest(t, nil)
+			rootClient, nobodyClient, _, cfg, teardown := setupTest(t, nil)
 			defer teardown()
 			fn(t, rootClient, nobodyClient, cfg)
 		})
 	}
 }
 
-func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobodyClient api.LogClient, cfg *Config, teardown func()) {
+func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobodyClient api.LogClient, rootClientV2 apiv2.LogClient, cfg *Config, teardown func()) {
 	t.Helper()
 
 	l, err := net.Listen("tcp", "127.0.0.1:0")
@@ -80,6 +90,7 @@ func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobody
 	// Create clients for "root" and "nobody" users
 	var rootConn *grpc.ClientConn
 	rootConn, rootClient, _ = newClient(SecureConfig.RootClientCertFile, SecureConfig.RootClientKeyFile)
+	rootClientV2 = apiv2.NewLogClient(rootConn)
 
 	var nobodyConn *grpc.ClientConn
 	nobodyConn, nobodyClient, _ = newClient(SecureConfig.NobodyClientCertFile, SecureConfig.NobodyClientKeyFile)
@@ -142,7 +153,7 @@ func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobody
 		server.Serve(l)
 	}()
 
-	return rootClient, nobodyClient, cfg, func() {
+	return rootClient, nobodyClient, rootClientV2, cfg, func() {
 		server.Stop()
 		rootConn.Close()
 		nobodyConn.Close()
@@ -163,7 +174,11 @@ func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobody
 func testProduceConsume(t *testing.T, client, _ api.LogClient, config *Config) {
 	ctx := context.Background()
 
-	want := &api.Record{Value: []byte("hello world")}
+	want := &api.Record{
+		Value:   []byte("hello world"),
+		Key:     []byte("greeting"),
+		Headers: []*api.Header{{Key: "trace-id", Value: []byte("abc123")}, {Key: "content-type", Value: []byte("text/plain")}},
+	}
 
 	produce, err := client.Produce(
 		ctx,
@@ -178,7 +193,14 @@ func testProduceConsume(t *testing.T, client, _ api.LogClient, config *Config) {
 
 	require.NoError(t, err)
 	require.Equal(t, want.Value, consume.Record.Value)
+	require.Equal(t, want.Key, consume.Record.Key)
 	require.Equal(t, want.Offset, consume.Record.Offset)
+	require.NotZero(t, consume.Record.Timestamp)
+	require.Len(t, consume.Record.Headers, len(want.Headers))
+	for i, h := range want.Headers {
+		require.Equal(t, h.Key, consume.Record.Headers[i].Key)
+		require.Equal(t, h.Value, consume.Record.Headers[i].Value)
+	}
 }
 
 func testConsumePastBoundary(t *testing.T, client, _ api.LogClient, config *Config) {
@@ -286,3 +308,421 @@ func testUnauthorized(t *testing.T, _, client api.LogClient, config *Config) {
 		t.Fatalf("got code: %d, want: %d", gotCode, wantCode)
 	}
 }
+
+func testOffsetForTime(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("before")},
+	})
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond)
+	since := time.Now()
+
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("after")},
+	})
+	require.NoError(t, err)
+
+	res, err := client.OffsetForTime(ctx, &api.OffsetForTimeRequest{
+		Timestamp: since.UnixNano(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, produce.Offset, res.Offset)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: res.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("after"), consume.Record.Value)
+}
+
+func testProduceBatch(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	records := []*api.Record{
+		{Value: []byte("first message")},
+		{Value: []byte("second message")},
+	}
+	produce, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{
+		Records:     records,
+		Compression: api.Compression_COMPRESSION_GZIP,
+	})
+	require.NoError(t, err)
+
+	for i, want := range records {
+		consume, err := client.Consume(ctx, &api.ConsumeRequest{
+			Offset: produce.Offset + uint64(i),
+		})
+		require.NoError(t, err)
+		require.Equal(t, want.Value, consume.Record.Value)
+		require.Equal(t, produce.Offset+uint64(i), consume.Record.Offset)
+	}
+
+	_, err = client.ProduceBatch(ctx, &api.ProduceBatchRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testConsumeStreamCompressed(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	records := []*api.Record{
+		{Value: []byte("first message")},
+		{Value: []byte("second message"), Headers: []*api.Header{{Key: "trace-id", Value: []byte("abc123")}}},
+		{Value: []byte("third message")},
+	}
+	_, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: records})
+	require.NoError(t, err)
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset: 0,
+		AcceptCompression: []api.Compression{
+			api.Compression_COMPRESSION_ZLIB,
+			api.Compression_COMPRESSION_GZIP,
+		},
+	})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Nil(t, res.Record)
+	require.Equal(t, api.Compression_COMPRESSION_ZLIB, res.Batch.Compression)
+
+	got, err := res.Batch.Decode()
+	require.NoError(t, err)
+	require.Len(t, got, len(records))
+	for i, record := range got {
+		require.Equal(t, records[i].Value, record.Value)
+		require.Equal(t, uint64(i), record.Offset)
+		require.Equal(t, len(records[i].Headers), len(record.Headers))
+	}
+	require.Equal(t, "trace-id", got[1].Headers[0].Key)
+}
+
+func testConsumeStreamWaits(t *testing.T, client, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the stream starts at the end of the empty log and waits for the record to be produced
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("late message")}})
+		require.NoError(t, err)
+	}()
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.Record.Offset)
+	require.Equal(t, []byte("late message"), res.Record.Value)
+}
+
+func TestConsumeStreamMaxWait(t *testing.T) {
+	client, _, _, _, teardown := setupTest(t, func(c *Config) {
+		c.ConsumeBatchSize = 3
+		c.ConsumeMaxWait = time.Second
+	})
+	defer teardown()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("first message")}})
+	require.NoError(t, err)
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:            0,
+		AcceptCompression: []api.Compression{api.Compression_COMPRESSION_GZIP},
+	})
+	require.NoError(t, err)
+
+	// the batch holding the first record waits for the records produced after it
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, err := client.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: []*api.Record{
+			{Value: []byte("second message")},
+			{Value: []byte("third message")},
+			{Value: []byte("fourth message")},
+		}})
+		require.NoError(t, err)
+	}()
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	got, err := res.Batch.Decode()
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	require.Equal(t, []byte("third message"), got[2].Value)
+
+	// the rest goes out in the next batch
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	got, err = res.Batch.Decode()
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, uint64(3), got[0].Offset)
+}
+
+func TestServerV2(t *testing.T) {
+	client, _, clientV2, _, teardown := setupTest(t, nil)
+	defer teardown()
+	ctx := context.Background()
+
+	// a record produced through v2 keeps every field when consumed through v1, and the other way round
+	want := &logapi.Record{
+		Value:   []byte("hello world"),
+		Key:     []byte("greeting"),
+		Headers: []*logapi.Header{{Key: "trace-id", Value: []byte("abc123")}},
+	}
+	produce, err := clientV2.Produce(ctx, &apiv2.ProduceRequest{Record: want})
+	require.NoError(t, err)
+
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, want.Value, consume.Record.Value)
+	require.Equal(t, want.Key, consume.Record.Key)
+	require.Equal(t, "trace-id", consume.Record.Headers[0].Key)
+
+	produceV1, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("from v1"), Key: []byte("k")}})
+	require.NoError(t, err)
+
+	consumeV2, err := clientV2.Consume(ctx, &apiv2.ConsumeRequest{Offset: produceV1.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("from v1"), consumeV2.Record.Value)
+	require.Equal(t, []byte("k"), consumeV2.Record.Key)
+	require.LessOrEqual(t, consume.Record.Timestamp, consumeV2.Record.Timestamp)
+
+	// compressed batches decode to canonical records
+	stream, err := clientV2.ConsumeStream(ctx, &apiv2.ConsumeRequest{
+		AcceptCompression: []apiv2.Compression{apiv2.Compression_COMPRESSION_GZIP},
+	})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	records, err := res.Batch.Decode()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, want.Headers[0].Value, records[0].Headers[0].Value)
+	require.Equal(t, uint64(1), records[1].Offset)
+
+	_, err = clientV2.Produce(ctx, &apiv2.ProduceRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// followerLog serves reads like a follower that can only meet local and max lag consistency.
+type followerLog struct {
+	CommitLog
+	lag time.Duration
+}
+
+func (l followerLog) CheckConsistency(ctx context.Context, c apiv2.Consistency, maxLag time.Duration) error {
+	switch c {
+	case apiv2.Consistency_CONSISTENCY_UNSPECIFIED, apiv2.Consistency_CONSISTENCY_LOCAL:
+		return nil
+	case apiv2.Consistency_CONSISTENCY_MAX_LAG:
+		if l.lag <= maxLag {
+			return nil
+		}
+	}
+	return api.ErrConsistencyUnavailable{Consistency: c, Reason: "not the leader"}
+}
+
+func TestConsumeConsistency(t *testing.T) {
+	client, _, clientV2, _, teardown := setupTest(t, func(c *Config) {
+		c.CommitLog = followerLog{CommitLog: c.CommitLog, lag: time.Second}
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+	require.NoError(t, err)
+
+	for _, c := range []api.Consistency{api.Consistency_CONSISTENCY_UNSPECIFIED, api.Consistency_CONSISTENCY_LOCAL} {
+		_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0, Consistency: c})
+		require.NoError(t, err)
+	}
+	_, err = client.Consume(ctx, &api.ConsumeRequest{
+		Offset:      0,
+		Consistency: api.Consistency_CONSISTENCY_MAX_LAG,
+		MaxLag:      int64(2 * time.Second),
+	})
+	require.NoError(t, err)
+
+	// the follower is further behind than the client allows
+	_, err = clientV2.Consume(ctx, &apiv2.ConsumeRequest{
+		Offset:      0,
+		Consistency: apiv2.Consistency_CONSISTENCY_MAX_LAG,
+		MaxLag:      int64(time.Millisecond),
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = clientV2.Consume(ctx, &apiv2.ConsumeRequest{Consistency: apiv2.Consistency_CONSISTENCY_MAX_LAG})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: 0, Consistency: api.Consistency_CONSISTENCY_LINEARIZABLE})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Contains(t, err.Error(), "CONSISTENCY_LINEARIZABLE")
+
+	// a stream fails before sending anything
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0, Consistency: api.Consistency_CONSISTENCY_LEADER_LEASE})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+// notLeaderLog appends like a follower, which leaves that to the leader.
+type notLeaderLog struct {
+	CommitLog
+	leader string
+}
+
+func (l notLeaderLog) Append(*logapi.Record) (uint64, error) {
+	return 0, api.ErrNotLeader{Leader: l.leader}
+}
+
+func (l notLeaderLog) AppendBatch([]*logapi.Record, apiv2.Compression) (uint64, error) {
+	return 0, api.ErrNotLeader{Leader: l.leader}
+}
+
+func TestForwardProduce(t *testing.T) {
+	tlsConfig := func(crtPath, keyPath string) *tls.Config {
+		c, err := SecureConfig.SetupTLSConfig(SecureConfig.TLSConfig{
+			CertFile: crtPath,
+			KeyFile:  keyPath,
+			CAFile:   SecureConfig.CAFile,
+		})
+		require.NoError(t, err)
+		return c
+	}
+	rootTLS := tlsConfig(SecureConfig.RootClientCertFile, SecureConfig.RootClientKeyFile)
+	nobodyTLS := tlsConfig(SecureConfig.NobodyClientCertFile, SecureConfig.NobodyClientKeyFile)
+
+	// serve starts a server over TLS with cfg and a client of it
+	serve := func(cfg *Config, client *tls.Config) (string, api.LogClient) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		serverTLS, err := SecureConfig.SetupTLSConfig(SecureConfig.TLSConfig{
+			CertFile:      SecureConfig.ServerCertFile,
+			KeyFile:       SecureConfig.ServerKeyFile,
+			CAFile:        SecureConfig.CAFile,
+			ServerAddress: l.Addr().String(),
+			Server:        true,
+		})
+		require.NoError(t, err)
+		server, err := NewGRPCServer(cfg, grpc.Creds(credentials.NewTLS(serverTLS)))
+		require.NoError(t, err)
+		go func() {
+			_ = server.Serve(l)
+		}()
+		t.Cleanup(server.Stop)
+
+		conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(client)))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return l.Addr().String(), api.NewLogClient(conn)
+	}
+	newLog := func() CommitLog {
+		dir, err := os.MkdirTemp("", "forward-test")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		clog, err := log.NewLog(dir, log.Config{})
+		require.NoError(t, err)
+		return NewLogAdapter(clog)
+	}
+
+	// the leader authorizes with the ACL, the followers let every client through
+	acl := auth.New(SecureConfig.ACLModelFile, SecureConfig.ACLPolicyFile)
+	leaderAddr, leader := serve(&Config{CommitLog: newLog(), Authorizer: acl}, rootTLS)
+	follower := func(forward bool, peerTLS, client *tls.Config) api.LogClient {
+		_, c := serve(&Config{
+			CommitLog:      notLeaderLog{CommitLog: newLog(), leader: leaderAddr},
+			Authorizer:     auth.New("", ""),
+			ForwardProduce: forward,
+			PeerTLSConfig:  peerTLS,
+		}, client)
+		return c
+	}
+	ctx := context.Background()
+
+	// a record produced to a follower is appended by the leader
+	rootFollower := follower(true, rootTLS, rootTLS)
+	produce, err := rootFollower.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("forwarded")}})
+	require.NoError(t, err)
+	batch, err := rootFollower.ProduceBatch(ctx, &api.ProduceBatchRequest{Records: []*api.Record{{Value: []byte("forwarded batch")}}})
+	require.NoError(t, err)
+	require.Equal(t, produce.Offset+1, batch.Offset)
+
+	consume, err := leader.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	require.Equal(t, []byte("forwarded"), consume.Record.Value)
+
+	stream, err := rootFollower.ProduceStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&api.ProduceRequest{Record: &api.Record{Value: []byte("forwarded stream")}}))
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, batch.Offset+1, res.Offset)
+
+	// the leader authorizes the client the follower forwarded for, not the follower
+	_, err = follower(true, rootTLS, nobodyTLS).Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("denied")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Contains(t, err.Error(), "nobody not permitted to produce")
+
+	// and only trusts followers that may forward
+	_, err = follower(true, nobodyTLS, rootTLS).Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("denied")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Contains(t, err.Error(), "nobody not permitted to forward")
+
+	// without forwarding the client is told where the leader is
+	_, err = follower(false, nil, rootTLS).Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("redirected")}})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	var info *errdetails.ErrorInfo
+	for _, d := range status.Convert(err).Details() {
+		if i, ok := d.(*errdetails.ErrorInfo); ok {
+			info = i
+		}
+	}
+	require.NotNil(t, info)
+	require.Equal(t, "NOT_LEADER", info.Reason)
+	require.Equal(t, leaderAddr, info.Metadata["leader"])
+}
+
+func TestForwarderConnections(t *testing.T) {
+	f := newForwarder(nil)
+	_, err := f.client("127.0.0.1:1")
+	require.NoError(t, err)
+	first := f.conns["127.0.0.1:1"]
+
+	// another leader gets a connection of its own, the last leader's stays open for requests in flight
+	_, err = f.client("127.0.0.1:2")
+	require.NoError(t, err)
+	require.Len(t, f.conns, 2)
+	require.NotEqual(t, connectivity.Shutdown, first.GetState())
+
+	// and is used again if it leads again
+	_, err = f.client("127.0.0.1:1")
+	require.NoError(t, err)
+	require.Same(t, first, f.conns["127.0.0.1:1"])
+}
+
+func TestRecordWireFormat(t *testing.T) {
+	// a v1 record and the canonical one decode each other's bytes field for field
+	v1 := &api.Record{
+		Value:     []byte("hello"),
+		Offset:    7,
+		Timestamp: 42,
+		Key:       []byte("key"),
+		Headers:   []*api.Header{{Key: "trace-id", Value: []byte("abc")}},
+	}
+	b, err := proto.Marshal(v1)
+	require.NoError(t, err)
+	canonical := &logapi.Record{}
+	require.NoError(t, proto.Unmarshal(b, canonical))
+	require.True(t, proto.Equal(v1.Canonical(), canonical))
+
+	b, err = proto.Marshal(canonical)
+	require.NoError(t, err)
+	decoded := &api.Record{}
+	require.NoError(t, proto.Unmarshal(b, decoded))
+	require.True(t, proto.Equal(v1, decoded))
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1"
+	logapi "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcServerV2 serves the v2 API. Its records are the canonical ones the CommitLog stores,
+// so they're passed through as they are.
+type grpcServerV2 struct {
+	*Config
+	// used to ensure forward compatibility when adding methods to the service.
+	*apiv2.UnimplementedLogServer
+
+	forwarder *forwarder // nil unless ForwardProduce is set
+}
+
+func newgrpcServerV2(config *Config) *grpcServerV2 {
+	s := &grpcServerV2{
+		Config:                 config,
+		UnimplementedLogServer: &apiv2.UnimplementedLogServer{},
+	}
+	if config.ForwardProduce {
+		s.forwarder = newForwarder(config.PeerTLSConfig)
+	}
+	return s
+}
+
+func (s *grpcServerV2) Produce(ctx context.Context, req *apiv2.ProduceRequest) (*apiv2.ProduceResponse, error) {
+	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, produceAction); err != nil {
+		return nil, err
+	}
+
+	if req.Record == nil {
+		return nil, status.Error(codes.InvalidArgument, "no record to produce")
+	}
+
+	offset, err := s.CommitLog.Append(produced(req.Record))
+	if leader, ok := s.leader(ctx, err); ok {
+		client, err := s.forwarder.client(leader)
+		if err != nil {
+			return nil, err
+		}
+		return client.Produce(forwardContext(ctx), req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &apiv2.ProduceResponse{Offset: offset}, nil
+}
+
+func (s *grpcServerV2) ProduceBatch(ctx context.Context, req *apiv2.ProduceBatchRequest) (*apiv2.ProduceBatchResponse, error) {
+	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, produceAction); err != nil {
+		return nil, err
+	}
+
+	if len(req.Records) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "batch has no records")
+	}
+
+	records := make([]*logapi.Record, len(req.Records))
+	for i, record := range req.Records {
+		if record == nil {
+			return nil, status.Error(codes.InvalidArgument, "batch has an empty record")
+		}
+		records[i] = produced(record)
+	}
+
+	offset, err := s.CommitLog.AppendBatch(records, req.Compression)
+	if leader, ok := s.leader(ctx, err); ok {
+		client, err := s.forwarder.client(leader)
+		if err != nil {
+			return nil, err
+		}
+		return client.ProduceBatch(forwardContext(ctx), req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &apiv2.ProduceBatchResponse{Offset: offset}, nil
+}
+
+// produced returns a copy of a record a client produced without the fields the server assigns.
+func produced(record *logapi.Record) *logapi.Record {
+	record = proto.Clone(record).(*logapi.Record)
+	record.Offset = 0
+	record.Term = 0
+	record.Type = 0
+	record.Timestamp = 0
+	return record
+}
+
+func (s *grpcServerV2) Consume(ctx context.Context, req *apiv2.ConsumeRequest) (*apiv2.ConsumeResponse, error) {
+	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, consumeAction); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkConsistency(ctx, req); err != nil {
+		return nil, err
+	}
+
+	record, err := s.CommitLog.Read(req.Offset)
+	if err != nil {
+		return nil, api.ErrOffsetOutOfRange{Offset: req.Offset}
+	}
+	return &apiv2.ConsumeResponse{Record: record}, nil
+}
+
+// checkConsistency returns once the log meets the consistency the request asks for.
+func (s *grpcServerV2) checkConsistency(ctx context.Context, req *apiv2.ConsumeRequest) error {
+	if req.Consistency == apiv2.Consistency_CONSISTENCY_MAX_LAG && req.MaxLag <= 0 {
+		return status.Error(codes.InvalidArgument, "max lag consistency needs a positive max_lag")
+	}
+	return s.CommitLog.CheckConsistency(ctx, req.Consistency, time.Duration(req.MaxLag))
+}
+
+func (s *grpcServerV2) ProduceStream(stream apiv2.Log_ProduceStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		res, err := s.Produce(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *grpcServerV2) ConsumeStream(req *apiv2.ConsumeRequest, stream apiv2.Log_ConsumeStreamServer) error {
+	compression := negotiateCompression(req.AcceptCompression)
+	return s.consumeStream(stream.Context(), req, compression, func(records []*logapi.Record) error {
+		if compression == apiv2.Compression_COMPRESSION_UNSPECIFIED {
+			return stream.Send(&apiv2.ConsumeResponse{Record: records[0]})
+		}
+
+		batch, err := apiv2.NewRecordBatch(records, compression)
+		if err != nil {
+			return err
+		}
+		return stream.Send(&apiv2.ConsumeResponse{Batch: batch})
+	})
+}
+
+// consumeStream calls send with the records from the request's offset on until ctx is done.
+// Without a compression it sends them one by one, with one it sends up to ConsumeBatchSize at
+// a time. Both API versions stream through it and only differ in how they send the records.
+func (s *grpcServerV2) consumeStream(ctx context.Context, req *apiv2.ConsumeRequest, c apiv2.Compression, send func([]*logapi.Record) error) error {
+	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, consumeAction); err != nil {
+		return err
+	}
+	// The consistency holds from the first record on, the records after it are appended later
+	if err := s.checkConsistency(ctx, req); err != nil {
+		return err
+	}
+
+	offset := req.Offset
+	for {
+		// Block until the offset is appended rather than polling Read for it
+		if err := s.CommitLog.Wait(ctx, offset); err != nil {
+			return nil
+		}
+
+		var records []*logapi.Record
+		var err error
+		if c == apiv2.Compression_COMPRESSION_UNSPECIFIED {
+			var res *apiv2.ConsumeResponse
+			if res, err = s.Consume(ctx, &apiv2.ConsumeRequest{Offset: offset}); err == nil {
+				records = []*logapi.Record{res.Record}
+			}
+		} else {
+			records, err = s.consumeBatch(ctx, offset)
+		}
+		// The log holds the offset, so a failed read won't succeed by retrying it
+		if err != nil {
+			return err
+		}
+
+		if err = send(records); err != nil {
+			return err
+		}
+		// Compacted logs have gaps, so continue after the record that was actually read
+		offset = records[len(records)-1].Offset + 1
+	}
+}
+
+// consumeBatch reads the records available from offset on, up to ConsumeBatchSize. If there
+// are fewer, it waits up to ConsumeMaxWait for the rest to be appended.
+func (s *grpcServerV2) consumeBatch(ctx context.Context, offset uint64) ([]*logapi.Record, error) {
+	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, consumeAction); err != nil {
+		return nil, err
+	}
+
+	size := s.ConsumeBatchSize
+	if size <= 0 {
+		size = maxBatchRecords
+	}
+
+	var records []*logapi.Record
+	var wait context.Context
+	waited := false
+	for len(records) < size {
+		record, err := s.CommitLog.Read(offset)
+		if err == nil {
+			records = append(records, record)
+			offset = record.Offset + 1
+			waited = false
+			continue
+		}
+		// Send what there is unless more records may still come within ConsumeMaxWait
+		if len(records) == 0 || s.ConsumeMaxWait <= 0 || waited {
+			break
+		}
+		if wait == nil {
+			var cancel context.CancelFunc
+			wait, cancel = context.WithTimeout(ctx, s.ConsumeMaxWait)
+			defer cancel()
+		}
+		if s.CommitLog.Wait(wait, offset) != nil {
+			break
+		}
+		waited = true
+	}
+
+	if len(records) == 0 {
+		return nil, api.ErrOffsetOutOfRange{Offset: offset}
+	}
+	return records, nil
+}
+
+// negotiateCompression picks the first compression the client accepts that the server supports.
+func negotiateCompression(accept []apiv2.Compression) apiv2.Compression {
+	for _, c := range accept {
+		switch c {
+		case apiv2.Compression_COMPRESSION_NONE, apiv2.Compression_COMPRESSION_GZIP,
+			apiv2.Compression_COMPRESSION_ZLIB, apiv2.Compression_COMPRESSION_FLATE:
+			return c
+		}
+	}
+	return apiv2.Compression_COMPRESSION_UNSPECIFIED
+}
+
+func (s *grpcServerV2) OffsetForTime(ctx context.Context, req *apiv2.OffsetForTimeRequest) (*apiv2.OffsetForTimeResponse, error) {
+	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, consumeAction); err != nil {
+		return nil, err
+	}
+
+	offset, err := s.CommitLog.OffsetForTime(time.Unix(0, req.Timestamp))
+	if err != nil {
+		return nil, err
+	}
+	return &apiv2.OffsetForTimeResponse{Offset: offset}, nil
+}
+
+func (s *grpcServerV2) GetServers(ctx context.Context, req *apiv2.GetServersRequest) (*apiv2.GetServersResponse, error) {
+	servers, err := s.Config.GetServers.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	return &apiv2.GetServersResponse{Servers: servers}, nil
+}
@@ -5,12 +5,13 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	DisLog "github.com/GergesHany/Event-Streaming-System/CoordinateWithConsensus/pkg/log"
 	"github.com/GergesHany/Event-Streaming-System/SecurityAndObservability/pkg/auth"
-	api "github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/api/v1"
 	"github.com/GergesHany/Event-Streaming-System/ServeRequestsWithgRPC/pkg/server"
 	"github.com/GergesHany/Event-Streaming-System/ServerSideServiceDiscovery/pkg/discovery"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
 	"github.com/GergesHany/Event-Streaming-System/WriteALogPackage/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -54,6 +55,23 @@ type Config struct {
 	ACLPolicyFile string
 
 	Bootstrap bool
+
+	// EncryptionKeyDir holds the keys the log is encrypted with, see log.FileKeyProvider.
+	// The log is written in plaintext when it's empty.
+	EncryptionKeyDir string
+
+	// SegmentedRaftLog keeps Raft's log in a segmented log rather than BoltDB, see
+	// log.Config.Raft.SegmentedLogStore. A data dir keeps the store it was created with.
+	SegmentedRaftLog bool
+
+	// Raft snapshots the log once SnapshotThreshold entries were applied since the last snapshot,
+	// checking every SnapshotInterval. Zero keeps Raft's defaults.
+	SnapshotThreshold uint64
+	SnapshotInterval  time.Duration
+
+	// ProduceOnLeaderOnly has followers fail produce requests with api.ErrNotLeader, which names
+	// the leader, rather than forward them to it over PeerTLSConfig.
+	ProduceOnLeaderOnly bool
 }
 
 func (c Config) RPCAddr() (string, error) {
@@ -110,6 +128,9 @@ func (a *Agent) setupLog() error {
 	logConfig := log.Config{}
 	logConfig.Segment.MaxStoreBytes = 1024 * 1024 * 1024 // 1GB
 	logConfig.Segment.MaxIndexBytes = 1024 * 1024        // 1MB
+	if a.Config.EncryptionKeyDir != "" {
+		logConfig.Encryption.Keys = log.NewFileKeyProvider(a.Config.EncryptionKeyDir)
+	}
 	logConfig.Raft.StreamLayer = log.NewStreamLayer(
 		raftLn,
 		a.Config.ServerTLSConfig,
@@ -124,6 +145,9 @@ func (a *Agent) setupLog() error {
 	logConfig.Raft.BindAddr = rpcAddr
 	logConfig.Raft.LocalID = raft.ServerID(a.Config.NodeName)
 	logConfig.Raft.Bootstrap = a.Config.Bootstrap
+	logConfig.Raft.SegmentedLogStore = a.Config.SegmentedRaftLog
+	logConfig.Raft.SnapshotThreshold = a.Config.SnapshotThreshold
+	logConfig.Raft.SnapshotInterval = a.Config.SnapshotInterval
 
 	a.log, err = DisLog.NewDistributedLog(
 		a.Config.DataDir,
@@ -145,6 +169,9 @@ func (a *Agent) setupServer() error {
 		CommitLog:  a.log,
 		Authorizer: authorizer,
 		GetServers: a, // Agent implements GetServers interface
+
+		ForwardProduce: !a.Config.ProduceOnLeaderOnly,
+		PeerTLSConfig:  a.Config.PeerTLSConfig,
 	}
 
 	var opts []grpc.ServerOption
@@ -234,15 +261,15 @@ func (a *Agent) setupMux() error {
 
 // GetServers implements the server.GetServerer interface by adapting
 // the distributed log's GetServers to the gRPC API's Server type
-func (a *Agent) GetServers() ([]*api.Server, error) {
+func (a *Agent) GetServers() ([]*apiv2.Server, error) {
 	servers, err := a.log.GetServers()
 	if err != nil {
 		return nil, err
 	}
 	// Convert from distributed log Server type to API Server type
-	apiServers := make([]*api.Server, len(servers))
+	apiServers := make([]*apiv2.Server, len(servers))
 	for i, srv := range servers {
-		apiServers[i] = &api.Server{
+		apiServers[i] = &apiv2.Server{
 			Id:       srv.ID,
 			RpcAddr:  srv.Address,
 			IsLeader: srv.IsLeader,
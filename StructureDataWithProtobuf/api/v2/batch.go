@@ -0,0 +1,117 @@
+package log_v2
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	log_v1 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// This is the one implementation of the compression codecs: the v1 API's batches and the log's
+// compressed frames delegate to it.
+
+// NewRecordBatch compresses the records into a batch with the given compression.
+func NewRecordBatch(records []*log_v1.Record, c Compression) (*RecordBatch, error) {
+	var data []byte
+	for _, record := range records {
+		p, err := proto.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		data = binary.AppendUvarint(data, uint64(len(p)))
+		data = append(data, p...)
+	}
+
+	data, err := c.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordBatch{Compression: c, Data: data}, nil
+}
+
+// Decode decompresses the batch and returns its records.
+func (b *RecordBatch) Decode() ([]*log_v1.Record, error) {
+	data, err := b.Compression.Decompress(b.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*log_v1.Record
+	for len(data) > 0 {
+		n, w := binary.Uvarint(data)
+		if w <= 0 || uint64(len(data)-w) < n {
+			return nil, fmt.Errorf("malformed record batch")
+		}
+
+		record := &log_v1.Record{}
+		if err = proto.Unmarshal(data[w:w+int(n)], record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		data = data[w+int(n):]
+	}
+	return records, nil
+}
+
+// Compress returns p compressed with c. Without compression, it returns p as is.
+func (c Compression) Compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch c {
+	case Compression_COMPRESSION_NONE:
+		return p, nil
+	case Compression_COMPRESSION_GZIP:
+		w = gzip.NewWriter(&buf)
+	case Compression_COMPRESSION_ZLIB:
+		w = zlib.NewWriter(&buf)
+	case Compression_COMPRESSION_FLATE:
+		if w, err = flate.NewWriter(&buf, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression: %v", c)
+	}
+
+	if _, err = w.Write(p); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns p decompressed with c. Without compression, it returns p as is.
+func (c Compression) Decompress(p []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+
+	switch c {
+	case Compression_COMPRESSION_NONE:
+		return p, nil
+	case Compression_COMPRESSION_GZIP:
+		if r, err = gzip.NewReader(bytes.NewReader(p)); err != nil {
+			return nil, err
+		}
+	case Compression_COMPRESSION_ZLIB:
+		if r, err = zlib.NewReader(bytes.NewReader(p)); err != nil {
+			return nil, err
+		}
+	case Compression_COMPRESSION_FLATE:
+		r = flate.NewReader(bytes.NewReader(p))
+	default:
+		return nil, fmt.Errorf("unsupported compression: %v", c)
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,867 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v3.21.12
+// source: api/v2/log.proto
+
+package log_v2
+
+import (
+	v1 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Compression int32
+
+const (
+	Compression_COMPRESSION_UNSPECIFIED Compression = 0
+	Compression_COMPRESSION_NONE        Compression = 1
+	Compression_COMPRESSION_GZIP        Compression = 2
+	Compression_COMPRESSION_ZLIB        Compression = 3
+	Compression_COMPRESSION_FLATE       Compression = 4
+)
+
+// Enum value maps for Compression.
+var (
+	Compression_name = map[int32]string{
+		0: "COMPRESSION_UNSPECIFIED",
+		1: "COMPRESSION_NONE",
+		2: "COMPRESSION_GZIP",
+		3: "COMPRESSION_ZLIB",
+		4: "COMPRESSION_FLATE",
+	}
+	Compression_value = map[string]int32{
+		"COMPRESSION_UNSPECIFIED": 0,
+		"COMPRESSION_NONE":        1,
+		"COMPRESSION_GZIP":        2,
+		"COMPRESSION_ZLIB":        3,
+		"COMPRESSION_FLATE":       4,
+	}
+)
+
+func (x Compression) Enum() *Compression {
+	p := new(Compression)
+	*p = x
+	return p
+}
+
+func (x Compression) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Compression) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_v2_log_proto_enumTypes[0].Descriptor()
+}
+
+func (Compression) Type() protoreflect.EnumType {
+	return &file_api_v2_log_proto_enumTypes[0]
+}
+
+func (x Compression) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Compression.Descriptor instead.
+func (Compression) EnumDescriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{0}
+}
+
+// Consistency is how up to date the server reading records must be. A follower only has the
+// records replicated to it so far, and a server that lost touch with the cluster may not know
+// it's behind. A server that can't meet the consistency fails the read, and the leader can meet all of them.
+type Consistency int32
+
+const (
+	Consistency_CONSISTENCY_UNSPECIFIED  Consistency = 0 // the same as CONSISTENCY_LOCAL
+	Consistency_CONSISTENCY_LOCAL        Consistency = 1 // whatever the server has, which may be stale on a follower
+	Consistency_CONSISTENCY_LEADER_LEASE Consistency = 2 // the leader, which steps down once it can't reach a quorum
+	Consistency_CONSISTENCY_LINEARIZABLE Consistency = 3 // the leader, after a quorum confirms it still is and it applied everything before the read
+	Consistency_CONSISTENCY_MAX_LAG      Consistency = 4 // any server that heard from the leader within max_lag
+)
+
+// Enum value maps for Consistency.
+var (
+	Consistency_name = map[int32]string{
+		0: "CONSISTENCY_UNSPECIFIED",
+		1: "CONSISTENCY_LOCAL",
+		2: "CONSISTENCY_LEADER_LEASE",
+		3: "CONSISTENCY_LINEARIZABLE",
+		4: "CONSISTENCY_MAX_LAG",
+	}
+	Consistency_value = map[string]int32{
+		"CONSISTENCY_UNSPECIFIED":  0,
+		"CONSISTENCY_LOCAL":        1,
+		"CONSISTENCY_LEADER_LEASE": 2,
+		"CONSISTENCY_LINEARIZABLE": 3,
+		"CONSISTENCY_MAX_LAG":      4,
+	}
+)
+
+func (x Consistency) Enum() *Consistency {
+	p := new(Consistency)
+	*p = x
+	return p
+}
+
+func (x Consistency) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Consistency) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_v2_log_proto_enumTypes[1].Descriptor()
+}
+
+func (Consistency) Type() protoreflect.EnumType {
+	return &file_api_v2_log_proto_enumTypes[1]
+}
+
+func (x Consistency) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Consistency.Descriptor instead.
+func (Consistency) EnumDescriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{1}
+}
+
+type ProduceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Record        *v1.Record             `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"` // offset, term, type and timestamp are assigned by the server
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProduceRequest) Reset() {
+	*x = ProduceRequest{}
+	mi := &file_api_v2_log_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProduceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProduceRequest) ProtoMessage() {}
+
+func (x *ProduceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProduceRequest.ProtoReflect.Descriptor instead.
+func (*ProduceRequest) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ProduceRequest) GetRecord() *v1.Record {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+type ProduceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProduceResponse) Reset() {
+	*x = ProduceResponse{}
+	mi := &file_api_v2_log_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProduceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProduceResponse) ProtoMessage() {}
+
+func (x *ProduceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProduceResponse.ProtoReflect.Descriptor instead.
+func (*ProduceResponse) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ProduceResponse) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type ProduceBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*v1.Record           `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	Compression   Compression            `protobuf:"varint,2,opt,name=compression,proto3,enum=log.v2.Compression" json:"compression,omitempty"` // how the batch is compressed on disk; unspecified uses the log's setting
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProduceBatchRequest) Reset() {
+	*x = ProduceBatchRequest{}
+	mi := &file_api_v2_log_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProduceBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProduceBatchRequest) ProtoMessage() {}
+
+func (x *ProduceBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProduceBatchRequest.ProtoReflect.Descriptor instead.
+func (*ProduceBatchRequest) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ProduceBatchRequest) GetRecords() []*v1.Record {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *ProduceBatchRequest) GetCompression() Compression {
+	if x != nil {
+		return x.Compression
+	}
+	return Compression_COMPRESSION_UNSPECIFIED
+}
+
+type ProduceBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"` // offset of the first record; the rest follow contiguously
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProduceBatchResponse) Reset() {
+	*x = ProduceBatchResponse{}
+	mi := &file_api_v2_log_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProduceBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProduceBatchResponse) ProtoMessage() {}
+
+func (x *ProduceBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProduceBatchResponse.ProtoReflect.Descriptor instead.
+func (*ProduceBatchResponse) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ProduceBatchResponse) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type ConsumeRequest struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Offset            uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	AcceptCompression []Compression          `protobuf:"varint,2,rep,packed,name=accept_compression,json=acceptCompression,proto3,enum=log.v2.Compression" json:"accept_compression,omitempty"` // codecs the client can decode; ConsumeStream then sends batches
+	Consistency       Consistency            `protobuf:"varint,3,opt,name=consistency,proto3,enum=log.v2.Consistency" json:"consistency,omitempty"`                                             // how up to date the server must be; unspecified reads what it has
+	MaxLag            int64                  `protobuf:"varint,4,opt,name=max_lag,json=maxLag,proto3" json:"max_lag,omitempty"`                                                                 // nanoseconds a follower may be behind the leader with CONSISTENCY_MAX_LAG
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ConsumeRequest) Reset() {
+	*x = ConsumeRequest{}
+	mi := &file_api_v2_log_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumeRequest) ProtoMessage() {}
+
+func (x *ConsumeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumeRequest.ProtoReflect.Descriptor instead.
+func (*ConsumeRequest) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ConsumeRequest) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ConsumeRequest) GetAcceptCompression() []Compression {
+	if x != nil {
+		return x.AcceptCompression
+	}
+	return nil
+}
+
+func (x *ConsumeRequest) GetConsistency() Consistency {
+	if x != nil {
+		return x.Consistency
+	}
+	return Consistency_CONSISTENCY_UNSPECIFIED
+}
+
+func (x *ConsumeRequest) GetMaxLag() int64 {
+	if x != nil {
+		return x.MaxLag
+	}
+	return 0
+}
+
+type ConsumeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Record        *v1.Record             `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	Batch         *RecordBatch           `protobuf:"bytes,2,opt,name=batch,proto3" json:"batch,omitempty"` // set instead of record when the client accepts a compression
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsumeResponse) Reset() {
+	*x = ConsumeResponse{}
+	mi := &file_api_v2_log_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumeResponse) ProtoMessage() {}
+
+func (x *ConsumeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumeResponse.ProtoReflect.Descriptor instead.
+func (*ConsumeResponse) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ConsumeResponse) GetRecord() *v1.Record {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+func (x *ConsumeResponse) GetBatch() *RecordBatch {
+	if x != nil {
+		return x.Batch
+	}
+	return nil
+}
+
+// RecordBatch holds consecutive records compressed together. Decompressed, data is a sequence
+// of records, each prefixed with its length as a uvarint; RecordBatch.Decode unpacks it.
+type RecordBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Compression   Compression            `protobuf:"varint,1,opt,name=compression,proto3,enum=log.v2.Compression" json:"compression,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordBatch) Reset() {
+	*x = RecordBatch{}
+	mi := &file_api_v2_log_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordBatch) ProtoMessage() {}
+
+func (x *RecordBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordBatch.ProtoReflect.Descriptor instead.
+func (*RecordBatch) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RecordBatch) GetCompression() Compression {
+	if x != nil {
+		return x.Compression
+	}
+	return Compression_COMPRESSION_UNSPECIFIED
+}
+
+func (x *RecordBatch) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type OffsetForTimeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp     int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // unix nanoseconds
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OffsetForTimeRequest) Reset() {
+	*x = OffsetForTimeRequest{}
+	mi := &file_api_v2_log_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OffsetForTimeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OffsetForTimeRequest) ProtoMessage() {}
+
+func (x *OffsetForTimeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OffsetForTimeRequest.ProtoReflect.Descriptor instead.
+func (*OffsetForTimeRequest) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *OffsetForTimeRequest) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+type OffsetForTimeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OffsetForTimeResponse) Reset() {
+	*x = OffsetForTimeResponse{}
+	mi := &file_api_v2_log_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OffsetForTimeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OffsetForTimeResponse) ProtoMessage() {}
+
+func (x *OffsetForTimeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OffsetForTimeResponse.ProtoReflect.Descriptor instead.
+func (*OffsetForTimeResponse) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *OffsetForTimeResponse) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type GetServersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetServersRequest) Reset() {
+	*x = GetServersRequest{}
+	mi := &file_api_v2_log_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetServersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetServersRequest) ProtoMessage() {}
+
+func (x *GetServersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetServersRequest.ProtoReflect.Descriptor instead.
+func (*GetServersRequest) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{9}
+}
+
+type GetServersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Servers       []*Server              `protobuf:"bytes,1,rep,name=servers,proto3" json:"servers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetServersResponse) Reset() {
+	*x = GetServersResponse{}
+	mi := &file_api_v2_log_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetServersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetServersResponse) ProtoMessage() {}
+
+func (x *GetServersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetServersResponse.ProtoReflect.Descriptor instead.
+func (*GetServersResponse) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetServersResponse) GetServers() []*Server {
+	if x != nil {
+		return x.Servers
+	}
+	return nil
+}
+
+type Server struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	RpcAddr       string                 `protobuf:"bytes,2,opt,name=rpc_addr,json=rpcAddr,proto3" json:"rpc_addr,omitempty"`
+	IsLeader      bool                   `protobuf:"varint,3,opt,name=is_leader,json=isLeader,proto3" json:"is_leader,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Server) Reset() {
+	*x = Server{}
+	mi := &file_api_v2_log_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Server) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Server) ProtoMessage() {}
+
+func (x *Server) ProtoReflect() protoreflect.Message {
+	mi := &file_api_v2_log_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Server.ProtoReflect.Descriptor instead.
+func (*Server) Descriptor() ([]byte, []int) {
+	return file_api_v2_log_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Server) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Server) GetRpcAddr() string {
+	if x != nil {
+		return x.RpcAddr
+	}
+	return ""
+}
+
+func (x *Server) GetIsLeader() bool {
+	if x != nil {
+		return x.IsLeader
+	}
+	return false
+}
+
+var File_api_v2_log_proto protoreflect.FileDescriptor
+
+const file_api_v2_log_proto_rawDesc = "" +
+	"\n" +
+	"\x10api/v2/log.proto\x12\x06log.v2\x1a\x10api/v1/log.proto\"8\n" +
+	"\x0eProduceRequest\x12&\n" +
+	"\x06record\x18\x01 \x01(\v2\x0e.log_v1.RecordR\x06record\")\n" +
+	"\x0fProduceResponse\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\"v\n" +
+	"\x13ProduceBatchRequest\x12(\n" +
+	"\arecords\x18\x01 \x03(\v2\x0e.log_v1.RecordR\arecords\x125\n" +
+	"\vcompression\x18\x02 \x01(\x0e2\x13.log.v2.CompressionR\vcompression\".\n" +
+	"\x14ProduceBatchResponse\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\"\xbc\x01\n" +
+	"\x0eConsumeRequest\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\x12B\n" +
+	"\x12accept_compression\x18\x02 \x03(\x0e2\x13.log.v2.CompressionR\x11acceptCompression\x125\n" +
+	"\vconsistency\x18\x03 \x01(\x0e2\x13.log.v2.ConsistencyR\vconsistency\x12\x17\n" +
+	"\amax_lag\x18\x04 \x01(\x03R\x06maxLag\"d\n" +
+	"\x0fConsumeResponse\x12&\n" +
+	"\x06record\x18\x01 \x01(\v2\x0e.log_v1.RecordR\x06record\x12)\n" +
+	"\x05batch\x18\x02 \x01(\v2\x13.log.v2.RecordBatchR\x05batch\"X\n" +
+	"\vRecordBatch\x125\n" +
+	"\vcompression\x18\x01 \x01(\x0e2\x13.log.v2.CompressionR\vcompression\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"4\n" +
+	"\x14OffsetForTimeRequest\x12\x1c\n" +
+	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp\"/\n" +
+	"\x15OffsetForTimeResponse\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\"\x13\n" +
+	"\x11GetServersRequest\">\n" +
+	"\x12GetServersResponse\x12(\n" +
+	"\aservers\x18\x01 \x03(\v2\x0e.log.v2.ServerR\aservers\"P\n" +
+	"\x06Server\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x19\n" +
+	"\brpc_addr\x18\x02 \x01(\tR\arpcAddr\x12\x1b\n" +
+	"\tis_leader\x18\x03 \x01(\bR\bisLeader*\x83\x01\n" +
+	"\vCompression\x12\x1b\n" +
+	"\x17COMPRESSION_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10COMPRESSION_NONE\x10\x01\x12\x14\n" +
+	"\x10COMPRESSION_GZIP\x10\x02\x12\x14\n" +
+	"\x10COMPRESSION_ZLIB\x10\x03\x12\x15\n" +
+	"\x11COMPRESSION_FLATE\x10\x04*\x96\x01\n" +
+	"\vConsistency\x12\x1b\n" +
+	"\x17CONSISTENCY_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11CONSISTENCY_LOCAL\x10\x01\x12\x1c\n" +
+	"\x18CONSISTENCY_LEADER_LEASE\x10\x02\x12\x1c\n" +
+	"\x18CONSISTENCY_LINEARIZABLE\x10\x03\x12\x17\n" +
+	"\x13CONSISTENCY_MAX_LAG\x10\x042\xf3\x03\n" +
+	"\x03Log\x12<\n" +
+	"\aProduce\x12\x16.log.v2.ProduceRequest\x1a\x17.log.v2.ProduceResponse\"\x00\x12K\n" +
+	"\fProduceBatch\x12\x1b.log.v2.ProduceBatchRequest\x1a\x1c.log.v2.ProduceBatchResponse\"\x00\x12<\n" +
+	"\aConsume\x12\x16.log.v2.ConsumeRequest\x1a\x17.log.v2.ConsumeResponse\"\x00\x12D\n" +
+	"\rConsumeStream\x12\x16.log.v2.ConsumeRequest\x1a\x17.log.v2.ConsumeResponse\"\x000\x01\x12F\n" +
+	"\rProduceStream\x12\x16.log.v2.ProduceRequest\x1a\x17.log.v2.ProduceResponse\"\x00(\x010\x01\x12E\n" +
+	"\n" +
+	"GetServers\x12\x19.log.v2.GetServersRequest\x1a\x1a.log.v2.GetServersResponse\"\x00\x12N\n" +
+	"\rOffsetForTime\x12\x1c.log.v2.OffsetForTimeRequest\x1a\x1d.log.v2.OffsetForTimeResponse\"\x00BVZTgithub.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2;log_v2b\x06proto3"
+
+var (
+	file_api_v2_log_proto_rawDescOnce sync.Once
+	file_api_v2_log_proto_rawDescData []byte
+)
+
+func file_api_v2_log_proto_rawDescGZIP() []byte {
+	file_api_v2_log_proto_rawDescOnce.Do(func() {
+		file_api_v2_log_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_api_v2_log_proto_rawDesc), len(file_api_v2_log_proto_rawDesc)))
+	})
+	return file_api_v2_log_proto_rawDescData
+}
+
+var file_api_v2_log_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_api_v2_log_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_api_v2_log_proto_goTypes = []any{
+	(Compression)(0),              // 0: log.v2.Compression
+	(Consistency)(0),              // 1: log.v2.Consistency
+	(*ProduceRequest)(nil),        // 2: log.v2.ProduceRequest
+	(*ProduceResponse)(nil),       // 3: log.v2.ProduceResponse
+	(*ProduceBatchRequest)(nil),   // 4: log.v2.ProduceBatchRequest
+	(*ProduceBatchResponse)(nil),  // 5: log.v2.ProduceBatchResponse
+	(*ConsumeRequest)(nil),        // 6: log.v2.ConsumeRequest
+	(*ConsumeResponse)(nil),       // 7: log.v2.ConsumeResponse
+	(*RecordBatch)(nil),           // 8: log.v2.RecordBatch
+	(*OffsetForTimeRequest)(nil),  // 9: log.v2.OffsetForTimeRequest
+	(*OffsetForTimeResponse)(nil), // 10: log.v2.OffsetForTimeResponse
+	(*GetServersRequest)(nil),     // 11: log.v2.GetServersRequest
+	(*GetServersResponse)(nil),    // 12: log.v2.GetServersResponse
+	(*Server)(nil),                // 13: log.v2.Server
+	(*v1.Record)(nil),             // 14: log_v1.Record
+}
+var file_api_v2_log_proto_depIdxs = []int32{
+	14, // 0: log.v2.ProduceRequest.record:type_name -> log_v1.Record
+	14, // 1: log.v2.ProduceBatchRequest.records:type_name -> log_v1.Record
+	0,  // 2: log.v2.ProduceBatchRequest.compression:type_name -> log.v2.Compression
+	0,  // 3: log.v2.ConsumeRequest.accept_compression:type_name -> log.v2.Compression
+	1,  // 4: log.v2.ConsumeRequest.consistency:type_name -> log.v2.Consistency
+	14, // 5: log.v2.ConsumeResponse.record:type_name -> log_v1.Record
+	8,  // 6: log.v2.ConsumeResponse.batch:type_name -> log.v2.RecordBatch
+	0,  // 7: log.v2.RecordBatch.compression:type_name -> log.v2.Compression
+	13, // 8: log.v2.GetServersResponse.servers:type_name -> log.v2.Server
+	2,  // 9: log.v2.Log.Produce:input_type -> log.v2.ProduceRequest
+	4,  // 10: log.v2.Log.ProduceBatch:input_type -> log.v2.ProduceBatchRequest
+	6,  // 11: log.v2.Log.Consume:input_type -> log.v2.ConsumeRequest
+	6,  // 12: log.v2.Log.ConsumeStream:input_type -> log.v2.ConsumeRequest
+	2,  // 13: log.v2.Log.ProduceStream:input_type -> log.v2.ProduceRequest
+	11, // 14: log.v2.Log.GetServers:input_type -> log.v2.GetServersRequest
+	9,  // 15: log.v2.Log.OffsetForTime:input_type -> log.v2.OffsetForTimeRequest
+	3,  // 16: log.v2.Log.Produce:output_type -> log.v2.ProduceResponse
+	5,  // 17: log.v2.Log.ProduceBatch:output_type -> log.v2.ProduceBatchResponse
+	7,  // 18: log.v2.Log.Consume:output_type -> log.v2.ConsumeResponse
+	7,  // 19: log.v2.Log.ConsumeStream:output_type -> log.v2.ConsumeResponse
+	3,  // 20: log.v2.Log.ProduceStream:output_type -> log.v2.ProduceResponse
+	12, // 21: log.v2.Log.GetServers:output_type -> log.v2.GetServersResponse
+	10, // 22: log.v2.Log.OffsetForTime:output_type -> log.v2.OffsetForTimeResponse
+	16, // [16:23] is the sub-list for method output_type
+	9,  // [9:16] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_api_v2_log_proto_init() }
+func file_api_v2_log_proto_init() {
+	if File_api_v2_log_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_v2_log_proto_rawDesc), len(file_api_v2_log_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_v2_log_proto_goTypes,
+		DependencyIndexes: file_api_v2_log_proto_depIdxs,
+		EnumInfos:         file_api_v2_log_proto_enumTypes,
+		MessageInfos:      file_api_v2_log_proto_msgTypes,
+	}.Build()
+	File_api_v2_log_proto = out.File
+	file_api_v2_log_proto_goTypes = nil
+	file_api_v2_log_proto_depIdxs = nil
+}
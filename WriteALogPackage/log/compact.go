@@ -0,0 +1,247 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+)
+
+/*
+	* Compaction turns the log into a changelog: for every key only the newest record survives.
+	* Records keep their offsets, so a compacted segment has gaps in its relative offsets and
+	  reads of a removed offset return the next surviving record.
+	* A keyed record with an empty value is a tombstone: it deletes the key, and is itself
+	  removed once it's older than Compaction.TombstoneGrace, so consumers have time to see it.
+	* Only sealed segments are rewritten; the active segment is still being appended to.
+
+	* Rewritten segments are built in <dir>/.compact and then the directory is renamed to
+	  <dir>/.swap, which is the commit point. The files are then moved over the originals.
+	  If the process crashes, setup drops an unfinished .compact and finishes moving a .swap.
+	* Segments are scanned and rewritten without the log's lock, so appends and reads go on
+	  meanwhile. It's only taken to swap the rewritten segments in, and the pass is dropped if
+	  the log was truncated at its end in between. Truncation rewrites segments in .cleaner.
+*/
+
+const (
+	cleanerDir = ".cleaner"
+	compactDir = ".compact"
+	swapDir    = ".swap"
+)
+
+// Compact rewrites the sealed segments so only the newest record for each key survives,
+// and drops tombstones appended before now minus Compaction.TombstoneGrace.
+func (l *Log) Compact(now time.Time) error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.RLock()
+	segments := append([]*segment(nil), l.segments...)
+	l.mu.RUnlock()
+
+	// Find the newest offset of every key, including the ones in the active segment
+	latest := make(map[string]uint64)
+	for _, s := range segments {
+		if err := s.scan(func(record *api.Record) error {
+			if len(record.Key) > 0 {
+				latest[string(record.Key)] = record.Offset
+			}
+			return nil
+		}); err == os.ErrClosed {
+			// Removed since, along with its records
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+
+	expired := now.Add(-l.Config.Compaction.TombstoneGrace).UnixNano()
+	keep := func(record *api.Record) bool {
+		if len(record.Key) == 0 {
+			return true
+		}
+		if latest[string(record.Key)] != record.Offset {
+			return false
+		}
+		return len(record.Value) > 0 || record.Timestamp >= expired
+	}
+
+	compacting := path.Join(l.Dir, compactDir)
+	if err := os.RemoveAll(compacting); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(compacting, 0755); err != nil {
+		return err
+	}
+
+	// The segment that was active is left out, it's still appended to or was sealed only since
+	rewritten := make(map[*segment]bool)
+	for _, s := range segments[:len(segments)-1] {
+		dirty, err := s.compactInto(compacting, keep)
+		if err == os.ErrClosed {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if dirty {
+			rewritten[s] = true
+		}
+	}
+
+	if len(rewritten) == 0 {
+		return os.RemoveAll(compacting)
+	}
+	return l.swapCompacted(segments, rewritten)
+}
+
+// swapCompacted replaces the segments rewritten into the compaction dir, once it has checked that
+// the log only lost segments from its front since segments were scanned. A suffix truncated
+// meanwhile may have removed the newest record of a key, so then the pass is dropped.
+func (l *Log) swapCompacted(segments []*segment, rewritten map[*segment]bool) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	compacting := path.Join(l.Dir, compactDir)
+	current := make(map[*segment]bool)
+	for _, s := range l.segments {
+		current[s] = true
+	}
+	kept := 0
+	for kept < len(segments) && !current[segments[kept]] {
+		kept++
+	}
+	for _, s := range segments[kept:] {
+		if !current[s] {
+			return os.RemoveAll(compacting)
+		}
+	}
+
+	// Rewrites of segments removed meanwhile aren't moved in
+	for s := range rewritten {
+		if current[s] {
+			continue
+		}
+		delete(rewritten, s)
+		if err := removeSegmentFiles(compacting, s.baseOffset); err != nil {
+			return err
+		}
+	}
+	if len(rewritten) == 0 {
+		return os.RemoveAll(compacting)
+	}
+
+	// Renaming the directory commits the compaction: from here on a crash is finished by setup
+	if err := os.Rename(compacting, path.Join(l.Dir, swapDir)); err != nil {
+		return err
+	}
+	if err := syncDir(l.Dir); err != nil {
+		return err
+	}
+
+	for s := range rewritten {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := completeSwap(l.Dir); err != nil {
+		return err
+	}
+
+	var swapped, removed []*segment
+	for _, s := range l.segments {
+		if !rewritten[s] {
+			swapped = append(swapped, s)
+			continue
+		}
+
+		n, err := newSegment(l.Dir, s.baseOffset, l.Config)
+		if err != nil {
+			return err
+		}
+		if err = l.seal(n); err != nil {
+			return err
+		}
+
+		// Every record in the segment was removed, so the segment isn't needed anymore.
+		// The first segment is kept even when empty because it marks where the log starts.
+		if n.nextOffset == n.baseOffset && len(swapped) > 0 {
+			removed = append(removed, n)
+			continue
+		}
+		swapped = append(swapped, n)
+	}
+
+	l.segments = swapped
+	l.publish()
+	return l.removeSegments(removed)
+}
+
+// compactInto writes the records of the segment that keep accepts into a new segment
+// with the same base offset in dir. It doesn't write anything if every record is kept,
+// and reports whether the segment had records to drop.
+func (s *segment) compactInto(dir string, keep func(*api.Record) bool) (bool, error) {
+	var dirty bool
+	if err := s.scan(func(record *api.Record) error {
+		dirty = dirty || !keep(record)
+		return nil
+	}); err != nil || !dirty {
+		return false, err
+	}
+	return true, s.rewriteInto(dir, s.baseOffset, keep)
+}
+
+// rewriteInto writes the records of the segment that keep accepts into a new segment with
+// the given base offset in dir, and syncs and closes it. The survivors of a batch
+// are written together again.
+func (s *segment) rewriteInto(dir string, base uint64, keep func(*api.Record) bool) error {
+	c, err := newSegment(dir, base, s.config)
+	if err != nil {
+		return err
+	}
+
+	if err = s.scanFrames(func(records []*api.Record, compression Compression) error {
+		var kept []*api.Record
+		for _, record := range records {
+			if keep(record) {
+				kept = append(kept, record)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return c.writeBatch(kept, compression)
+	}); err != nil {
+		return err
+	}
+
+	// The rewrite must be on disk before it's committed
+	if err = c.Sync(); err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// completeSwap moves compacted segments waiting in the swap directory of dir over the originals.
+func completeSwap(dir string) error {
+	swap := path.Join(dir, swapDir)
+	files, err := ioutil.ReadDir(swap)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err = os.Rename(path.Join(swap, file.Name()), path.Join(dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(swap)
+}
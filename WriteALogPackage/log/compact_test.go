@@ -0,0 +1,169 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// every record fills a segment, so all of them end up in sealed segments
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Compaction.TombstoneGrace = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	records := []*api.Record{
+		{Key: []byte("a"), Value: []byte("a1")}, // 0: replaced by 3
+		{Key: []byte("b"), Value: []byte("b1")}, // 1: deleted by 4
+		{Value: []byte("no key")},               // 2: never compacted
+		{Key: []byte("a"), Value: []byte("a2")}, // 3: latest a
+		{Key: []byte("b"), Timestamp: old},      // 4: tombstone past its grace period
+		{Key: []byte("c"), Value: []byte("c1")}, // 5: replaced by 6
+		{Key: []byte("c"), Value: []byte("c2")}, // 6: latest c
+		{Key: []byte("d")},                      // 7: fresh tombstone, kept for now
+	}
+	for _, record := range records {
+		_, err := log.Append(record)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, log.Compact(time.Now()))
+
+	check := func(log *Log, highest uint64) {
+		reads := []struct {
+			Off, Got uint64
+		}{
+			{Off: 0, Got: 2},
+			{Off: 2, Got: 2},
+			{Off: 3, Got: 3},
+			{Off: 4, Got: 6},
+			{Off: 5, Got: 6},
+			{Off: 7, Got: 7},
+		}
+		for _, want := range reads {
+			read, err := log.Read(want.Off)
+			require.NoError(t, err)
+			require.Equal(t, want.Got, read.Offset)
+			require.Equal(t, records[want.Got].Value, read.Value)
+		}
+
+		off, err := log.LowestOffset()
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), off)
+		off, err = log.HighestOffset()
+		require.NoError(t, err)
+		require.Equal(t, highest, off)
+	}
+	check(log, 7)
+
+	// offsets keep going where they left off
+	off, err := log.Append(&api.Record{Key: []byte("a"), Value: []byte("a3")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), off)
+	require.NoError(t, log.Close())
+
+	// a compaction interrupted before its commit point is thrown away
+	require.NoError(t, os.MkdirAll(path.Join(dir, compactDir), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, compactDir, "3.store"), []byte("junk"), 0644))
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	check(log, 8)
+	_, err = os.Stat(path.Join(dir, compactDir))
+	require.True(t, os.IsNotExist(err))
+
+	// a3 makes a2 obsolete once the segment holding a3 is sealed
+	require.NoError(t, log.Compact(time.Now()))
+	read, err := log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), read.Offset)
+	require.NoError(t, log.Close())
+}
+
+func TestCompactConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 64
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// appends and reads go on while the log is compacted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_, err := log.Append(&api.Record{Key: []byte{byte('a' + i%4)}, Value: []byte{byte(i)}})
+			require.NoError(t, err)
+		}
+	}()
+	for compacting := true; compacting; {
+		select {
+		case <-done:
+			compacting = false
+		default:
+			require.NoError(t, log.Compact(time.Now()))
+		}
+	}
+	require.NoError(t, log.Compact(time.Now()))
+
+	// only the newest record of every key is left
+	for off := uint64(0); off < 200; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, read.Offset, uint64(196))
+		require.Equal(t, byte(read.Offset), read.Value[0])
+		off = read.Offset
+	}
+}
+
+func TestCompactAfterSuffixTruncation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for _, value := range []string{"old", "new", "other"} {
+		_, err = log.Append(&api.Record{Key: []byte("key"), Value: []byte(value)})
+		require.NoError(t, err)
+	}
+
+	// a pass scans the segments and rewrites the first without the newest record of the key...
+	segments := append([]*segment(nil), log.segments...)
+	compacting := path.Join(dir, compactDir)
+	require.NoError(t, os.MkdirAll(compacting, 0755))
+	dirty, err := segments[0].compactInto(compacting, func(record *api.Record) bool { return record.Offset == 2 })
+	require.NoError(t, err)
+	require.True(t, dirty)
+
+	// ...which the log loses before the pass swaps it in, so the pass is dropped
+	require.NoError(t, log.TruncateAfter(0))
+	require.NoError(t, log.swapCompacted(segments, map[*segment]bool{segments[0]: true}))
+	_, err = os.Stat(compacting)
+	require.True(t, os.IsNotExist(err))
+
+	read, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), read.Offset)
+	require.Equal(t, []byte("old"), read.Value)
+}
@@ -0,0 +1,169 @@
+package log
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	apiv2 "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+	* A record batch is written to the store as a single frame, so a crash can't leave part of it
+	  behind. The frame's attributes hold the compression, and every record of the batch has an
+	  index entry pointing at the frame.
+	* Before compression the batch is a sequence of records, each prefixed with its uvarint length:
+		[uvarint length][record][uvarint length][record]...
+	* An uncompressed batch of more than one record has the attrBatch attribute instead.
+	* A frame without attributes holds a single uncompressed record, as it always did.
+*/
+
+// Compression is the codec record batches are compressed with on disk.
+type Compression uint8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZlib
+	CompressionFlate
+)
+
+// attrBatch marks a frame holding an uncompressed batch in its attributes. It's above the
+// compression codecs and below attrEncrypted.
+const attrBatch byte = 0x40
+
+// ErrUnknownCompression is returned for a compression the log doesn't support.
+var ErrUnknownCompression = errors.New("unknown compression")
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZlib:
+		return "zlib"
+	case CompressionFlate:
+		return "flate"
+	}
+	return fmt.Sprintf("compression(%d)", uint8(c))
+}
+
+// codec returns the compression as the v2 API's, which holds the codecs. The API numbers them
+// one up to make room for unspecified.
+func (c Compression) codec() (apiv2.Compression, error) {
+	if c == CompressionNone || c > CompressionFlate {
+		return 0, ErrUnknownCompression
+	}
+	return apiv2.Compression(c) + 1, nil
+}
+
+// compress returns p compressed with c.
+func (c Compression) compress(p []byte) ([]byte, error) {
+	codec, err := c.codec()
+	if err != nil {
+		return nil, err
+	}
+	return codec.Compress(p)
+}
+
+// decompress returns p decompressed with c.
+func (c Compression) decompress(p []byte) ([]byte, error) {
+	codec, err := c.codec()
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decompress(p)
+}
+
+// encodeBatch returns the attributes and the data of the single frame the records are written
+// to the store as. A single record without compression is written as it is.
+func encodeBatch(records []*api.Record, c Compression) (byte, []byte, error) {
+	if c == CompressionNone && len(records) == 1 {
+		p, err := proto.Marshal(records[0])
+		return 0, p, err
+	}
+
+	var batch []byte
+	for _, record := range records {
+		p, err := proto.Marshal(record)
+		if err != nil {
+			return 0, nil, err
+		}
+		batch = binary.AppendUvarint(batch, uint64(len(p)))
+		batch = append(batch, p...)
+	}
+
+	if c == CompressionNone {
+		return attrBatch, batch, nil
+	}
+	p, err := c.compress(batch)
+	if err != nil {
+		return 0, nil, err
+	}
+	return byte(c), p, nil
+}
+
+// frameCompression returns the compression of a store frame with the given attributes.
+func frameCompression(attrs byte) Compression {
+	return Compression(attrs &^ attrBatch)
+}
+
+// decodeFrame returns the records held by a store frame with the given attributes.
+func decodeFrame(attrs byte, p []byte) ([]*api.Record, error) {
+	if attrs == 0 {
+		record := &api.Record{}
+		if err := proto.Unmarshal(p, record); err != nil {
+			return nil, err
+		}
+		return []*api.Record{record}, nil
+	}
+
+	batch := p
+	if c := frameCompression(attrs); c != CompressionNone {
+		var err error
+		if batch, err = c.decompress(p); err != nil {
+			return nil, err
+		}
+	}
+
+	var records []*api.Record
+	for len(batch) > 0 {
+		n, w := binary.Uvarint(batch)
+		if w <= 0 || uint64(len(batch)-w) < n {
+			return nil, ErrCorruptRecord
+		}
+
+		record := &api.Record{}
+		if err := proto.Unmarshal(batch[w:w+int(n)], record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		batch = batch[w+int(n):]
+	}
+	return records, nil
+}
+
+// ReadFrame reads the next frame from r, which holds raw store files like the reader
+// Log.Reader returns, and returns the records in it. It returns io.EOF at the end of r.
+func ReadFrame(r io.Reader) ([]*api.Record, error) {
+	header := make([]byte, HeaderWidth)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	attrs, size := parseFrameLen(enc.Uint64(header[:LenWidth]))
+	p := make([]byte, size)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(p, crcTable) != enc.Uint32(header[LenWidth:]) {
+		return nil, ErrCorruptRecord
+	}
+	return decodeFrame(attrs, p)
+}
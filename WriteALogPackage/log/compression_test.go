@@ -0,0 +1,177 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"event":"page_view","path":"/"}`), 32)
+
+	for _, c := range []Compression{CompressionGzip, CompressionZlib, CompressionFlate} {
+		t.Run(c.String(), func(t *testing.T) {
+			p, err := c.compress(payload)
+			require.NoError(t, err)
+			require.Less(t, len(p), len(payload))
+
+			got, err := c.decompress(p)
+			require.NoError(t, err)
+			require.Equal(t, payload, got)
+		})
+	}
+
+	_, err := Compression(42).compress(payload)
+	require.Equal(t, ErrUnknownCompression, err)
+}
+
+func TestCompressedBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compressed-batch-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.Compression = CompressionGzip
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte("verbose json "), 16)
+	batch := []*api.Record{
+		{Key: []byte("a"), Value: value},
+		{Key: []byte("b"), Value: value},
+		{Key: []byte("a"), Value: value},
+	}
+	off, err := log.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	// the batch is a single compressed frame, smaller than its records
+	require.Less(t, log.activeSegment.store.size, uint64(len(batch)*len(value)))
+
+	// the compression can be chosen per batch
+	off, err = log.AppendCompressedBatch([]*api.Record{{Value: []byte("plain")}}, CompressionNone)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+
+	check := func(log *Log) {
+		for i, want := range batch {
+			read, err := log.Read(uint64(i))
+			require.NoError(t, err)
+			require.Equal(t, uint64(i), read.Offset)
+			require.Equal(t, want.Key, read.Key)
+			require.Equal(t, value, read.Value)
+		}
+		read, err := log.Read(3)
+		require.NoError(t, err)
+		require.Equal(t, []byte("plain"), read.Value)
+	}
+	check(log)
+
+	// the reader hands out the raw frames, compressed batches included
+	r := log.Reader()
+	records, err := ReadFrame(r)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	records, err = ReadFrame(r)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	_, err = ReadFrame(r)
+	require.Equal(t, io.EOF, err)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	check(log)
+
+	// compaction keeps the survivors of a batch compressed together,
+	// and with compression on a single append is a batch of one
+	_, err = log.Append(&api.Record{Value: []byte("single")})
+	require.NoError(t, err)
+	require.NoError(t, log.newSegment(5))
+	require.NoError(t, log.Compact(time.Now()))
+
+	read, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), read.Offset)
+	read, err = log.Read(2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), read.Key)
+	require.NoError(t, log.segments[0].scanFrames(func(records []*api.Record, c Compression) error {
+		if len(records) > 1 {
+			require.Equal(t, CompressionGzip, c)
+			require.Len(t, records, 2)
+		}
+		return nil
+	}))
+	require.NoError(t, log.Close())
+}
+
+func TestRecoverTornBatch(t *testing.T) {
+	// an uncompressed batch is a single frame too, so it's recovered the same way
+	for _, c := range []Compression{CompressionNone, CompressionFlate} {
+		t.Run(c.String(), func(t *testing.T) {
+			testRecoverTornBatch(t, c)
+		})
+	}
+}
+
+func testRecoverTornBatch(t *testing.T, compression Compression) {
+	dir, err := ioutil.TempDir("", "torn-batch-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	_, err = log.Append(&api.Record{Value: []byte("before")})
+	require.NoError(t, err)
+	_, err = log.AppendCompressedBatch([]*api.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second")},
+		{Value: []byte("third")},
+	}, compression)
+	require.NoError(t, err)
+	positions := map[uint64]bool{}
+	for n := int64(1); n < 4; n++ {
+		_, pos, err := log.activeSegment.index.Read(n)
+		require.NoError(t, err)
+		positions[pos] = true
+	}
+	require.Len(t, positions, 1)
+
+	// a crash wrote the whole frame but only part of the batch's index entries
+	require.NoError(t, log.activeSegment.index.Truncate(3))
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	// the frame is intact, so the index is rebuilt from it and the whole batch is kept
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+	read, err := log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, []byte("third"), read.Value)
+
+	// recover alone drops a batch whose index entries were cut short
+	require.NoError(t, log.activeSegment.index.Truncate(3))
+	_, err = log.activeSegment.recover()
+	require.NoError(t, err)
+
+	// the next append takes the offsets of the dropped batch
+	off, err = log.Append(&api.Record{Value: []byte("after")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	read, err = log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("after"), read.Value)
+}
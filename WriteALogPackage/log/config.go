@@ -78,17 +78,74 @@ func (s *StreamLayer) Addr() net.Addr {
 	return s.ln.Addr()
 }
 
+// SyncPolicy decides when the log fsyncs appended records.
+type SyncPolicy uint8
+
+const (
+	SyncOSManaged SyncPolicy = iota // never fsync on append, the OS writes dirty pages back when it wants
+	SyncAlways                      // fsync before every Append returns
+	SyncEveryN                      // fsync once Segment.SyncEvery records were appended since the last fsync
+	SyncInterval                    // fsync in the background every Segment.SyncInterval
+)
+
 type Config struct {
 	Raft struct {
 		raft.Config
 		BindAddr    string
 		StreamLayer *StreamLayer
 		Bootstrap   bool // that means this node is the first node in the cluster.
+
+		ApplyTimeout time.Duration // how long an append waits for the leader to take its entry (0 = 10s)
+
+		// SegmentedLogStore keeps Raft's log in a segmented Log rather than BoltDB, which then only holds
+		// Raft's stable store. A data dir keeps the store it was created with.
+		SegmentedLogStore bool
+
+		// Group commit: concurrent appends are coalesced into one Raft entry, committed with a single round-trip
+		GroupCommitWindow   time.Duration // how long the first append of an entry waits for others to join it (0 = only appends already waiting join)
+		GroupCommitMaxBytes int           // record bytes that close an entry before the window is over (0 = 1MB)
 	}
 
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
+		MaxAge        time.Duration // roll the active segment once its first record is older than this, checked every MaxAge/10 (0 = no limit)
+
+		// Durability: when appended records are fsynced to disk, see durability.go for the guarantees
+		Sync         SyncPolicy
+		SyncEvery    uint64        // records between fsyncs with SyncEveryN
+		SyncInterval time.Duration // time between fsyncs with SyncInterval; also bounds the wait of SyncEveryN
+		WaitForSync  bool          // Append returns only once the fsync covering its records is done (group commit)
+
+		Compression Compression // codec batches (and single appends) are compressed with on disk, see compression.go
+
+		MaxOpenSegments int // sealed segments kept open at once, the least recently read are closed until read again (0 = no limit), see lru.go
+	}
+
+	// Retention deletes whole sealed segments from the front of the log; the active segment is never deleted.
+	Retention struct {
+		Bytes         uint64        // delete the oldest segments while the log's stores are bigger than this (0 = no limit)
+		Duration      time.Duration // delete segments whose newest record is older than this (0 = no limit)
+		CheckInterval time.Duration // how often the log enforces the policy in the background (0 = never)
+	}
+
+	// Compaction rewrites sealed segments so only the newest record for each key survives; records without a key are always kept.
+	Compaction struct {
+		TombstoneGrace time.Duration // how long a tombstone (a keyed record with an empty value) is kept before its key is forgotten
+		CheckInterval  time.Duration // how often the log compacts in the background (0 = never)
+	}
+
+	// Encryption encrypts the stores of new segments with AES-GCM, see encryption.go.
+	Encryption struct {
+		Keys KeyProvider // supplies the key of new segments and the keys of existing ones (nil = plaintext)
+	}
+
+	// Tiering offloads the oldest sealed segments to an object store and evicts them from local disk, see tiered.go.
+	Tiering struct {
+		Store         ObjectStore   // where segments are offloaded to (nil = keep everything local)
+		LocalBytes    uint64        // sealed segment bytes kept on local disk; older segments are offloaded
+		CacheSegments int           // offloaded segments kept on local disk once a read fetched them (0 = 1)
+		CheckInterval time.Duration // how often the log offloads in the background (0 = never)
 	}
 }
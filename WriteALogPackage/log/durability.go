@@ -0,0 +1,139 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+/*
+	* Config.Segment.Sync decides when appended records are fsynced. What survives a crash:
+
+		SyncOSManaged (default): nothing is fsynced on append. Records still in the store's write
+		  buffer are lost if the process crashes, and records in the page cache are lost on power loss.
+		SyncAlways: Append returns only after the records and their index entries are on stable
+		  storage, so every acknowledged record survives power loss.
+		SyncEveryN: the log is fsynced once SyncEvery records were appended since the last fsync.
+		  Up to SyncEvery-1 acknowledged records can be lost on power loss.
+		SyncInterval: the log is fsynced in the background every SyncInterval.
+		  Records acknowledged during the last interval can be lost on power loss.
+
+	* WaitForSync makes Append under SyncEveryN and SyncInterval wait for the fsync that covers its
+	  records, so acknowledged records survive power loss like with SyncAlways, while many concurrent
+	  appends share one fsync (group commit). SyncAlways groups concurrent appends the same way.
+
+	* Segments are fsynced store first, then the indexes, and the log directory is fsynced when a
+	  segment is created. Whatever a power loss tears at the tail is trimmed by recover on startup.
+
+	* If an fsync fails, the OS may already have dropped the dirty pages, so a later fsync succeeding
+	  proves nothing. The error sticks: every later append that needs an fsync fails with it.
+*/
+
+// validateSync checks that the durability settings can be honored.
+func validateSync(c Config) error {
+	switch c.Segment.Sync {
+	case SyncOSManaged, SyncAlways:
+	case SyncEveryN:
+		if c.Segment.SyncEvery == 0 {
+			return errors.New("SyncEveryN needs Segment.SyncEvery")
+		}
+		// Without an interval, a waiting Append could wait forever for more records
+		if c.Segment.WaitForSync && c.Segment.SyncInterval <= 0 {
+			return errors.New("WaitForSync with SyncEveryN needs Segment.SyncInterval")
+		}
+	case SyncInterval:
+		if c.Segment.SyncInterval <= 0 {
+			return errors.New("SyncInterval needs Segment.SyncInterval")
+		}
+	default:
+		return errors.New("unknown sync policy")
+	}
+	return nil
+}
+
+// startSync starts fsyncing the log in the background if the policy has an interval.
+func (l *Log) startSync() {
+	c := l.Config.Segment
+	if c.SyncInterval > 0 && (c.Sync == SyncInterval || c.Sync == SyncEveryN) {
+		l.startBackground(c.SyncInterval, func(time.Time) error {
+			return l.Sync()
+		})
+	}
+}
+
+// commit applies the durability policy to the records appended up to last.
+// It must be called after the log's lock is released, so appends can continue during the fsync.
+func (l *Log) commit(last uint64) error {
+	c := l.Config.Segment
+	if c.Sync == SyncOSManaged {
+		return nil
+	}
+
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+
+	switch {
+	case l.synced > last:
+		// An fsync started for another append already covered these records
+		return nil
+	case c.Sync == SyncAlways, c.Sync == SyncEveryN && last+1-l.synced >= c.SyncEvery:
+		return l.sync()
+	case c.WaitForSync:
+		for l.synced <= last && l.syncErr == nil {
+			l.syncCond.Wait()
+		}
+		return l.syncErr
+	}
+	return nil
+}
+
+// Sync commits every record appended so far to stable storage.
+func (l *Log) Sync() error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	return l.sync()
+}
+
+// sync fsyncs the segments holding records that aren't synced yet and wakes the appends waiting
+// for them. The caller must hold syncMu, which also keeps the segments from being closed meanwhile.
+func (l *Log) sync() error {
+	if l.syncErr != nil {
+		return l.syncErr
+	}
+
+	l.mu.RLock()
+	var segments []*segment
+	for _, s := range l.segments {
+		if s.nextOffset > l.synced {
+			segments = append(segments, s)
+		}
+	}
+	next := l.activeSegment.nextOffset
+	l.mu.RUnlock()
+
+	defer l.syncCond.Broadcast()
+	for _, s := range segments {
+		if err := s.Sync(); err != nil {
+			l.syncErr = err
+			return err
+		}
+	}
+
+	if next > l.synced {
+		l.synced = next
+	}
+	return nil
+}
+
+// syncDir commits the directory entries of the log's files, so new segments survive power loss.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,135 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurability(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, c Config){
+		"sync always":                  testSyncAlways,
+		"sync every n records":         testSyncEveryN,
+		"sync on an interval and wait": testSyncIntervalWait,
+		"group commit":                 testGroupCommit,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			c := Config{}
+			c.Segment.MaxStoreBytes = 1024
+			fn(t, c)
+		})
+	}
+}
+
+func TestDurabilityInvalidConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durability-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.Sync = SyncEveryN
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+
+	c.Segment.SyncEvery = 10
+	c.Segment.WaitForSync = true
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+
+	c = Config{}
+	c.Segment.Sync = SyncInterval
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+}
+
+func newDurabilityLog(t *testing.T, c Config) *Log {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "durability-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	return log
+}
+
+func testSyncAlways(t *testing.T, c Config) {
+	c.Segment.Sync = SyncAlways
+	log := newDurabilityLog(t, c)
+
+	for i := uint64(0); i < 3; i++ {
+		off, err := log.Append(&api.Record{Value: write})
+		require.NoError(t, err)
+		require.Equal(t, off+1, log.synced)
+	}
+
+	off, err := log.AppendBatch([]*api.Record{{Value: write}, {Value: write}})
+	require.NoError(t, err)
+	require.Equal(t, off+2, log.synced)
+	require.NoError(t, log.Close())
+}
+
+func testSyncEveryN(t *testing.T, c Config) {
+	c.Segment.Sync = SyncEveryN
+	c.Segment.SyncEvery = 3
+	log := newDurabilityLog(t, c)
+
+	for i := 0; i < 2; i++ {
+		_, err := log.Append(&api.Record{Value: write})
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), log.synced)
+	}
+
+	off, err := log.Append(&api.Record{Value: write})
+	require.NoError(t, err)
+	require.Equal(t, off+1, log.synced)
+
+	// closing commits whatever was appended since the last fsync
+	_, err = log.Append(&api.Record{Value: write})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+	require.Equal(t, uint64(4), log.synced)
+}
+
+func testSyncIntervalWait(t *testing.T, c Config) {
+	c.Segment.Sync = SyncInterval
+	c.Segment.SyncInterval = time.Millisecond
+	c.Segment.WaitForSync = true
+	log := newDurabilityLog(t, c)
+	defer log.Close()
+
+	off, err := log.Append(&api.Record{Value: write})
+	require.NoError(t, err)
+
+	log.syncMu.Lock()
+	defer log.syncMu.Unlock()
+	require.Greater(t, log.synced, off)
+}
+
+func testGroupCommit(t *testing.T, c Config) {
+	c.Segment.Sync = SyncAlways
+	log := newDurabilityLog(t, c)
+	defer log.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			off, err := log.Append(&api.Record{Value: write})
+			require.NoError(t, err)
+
+			// every append returns only after an fsync covered it
+			log.syncMu.Lock()
+			defer log.syncMu.Unlock()
+			require.Greater(t, log.synced, off)
+		}()
+	}
+	wg.Wait()
+}
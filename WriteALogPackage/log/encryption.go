@@ -0,0 +1,505 @@
+package log
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+	* With Config.Encryption.Keys set, every frame of a new store is encrypted with AES-GCM.
+	  A batch is a single frame, so it's encrypted as a whole after compression.
+	* An encrypted store starts with a header naming the key it's encrypted with:
+		[4-byte magic "ENCS"][1-byte version][2-byte key ID length][key ID]
+	  Frames follow the header, and index positions count it like any other byte.
+	* An encrypted frame has the attrEncrypted bit set in its attributes, and its data is
+		[12-byte nonce][AES-GCM ciphertext and tag]
+	  with the frame's other attributes (the compression) as additional data. The CRC covers the
+	  encrypted data, so torn writes are found without the key.
+	* Rotating the provider's current key only changes the key of stores created afterwards, so
+	  old segments stay readable with their own key until retention or compaction rewrites them.
+	  Stores written before encryption was turned on stay plaintext.
+	* The index files hold offsets, positions and timestamps but no record data, and are rebuilt
+	  from the store, so they aren't encrypted.
+	* EncryptStream and DecryptStream encrypt streams like Raft snapshots the same way, in chunks.
+*/
+
+// KeyProvider supplies the keys stores are encrypted with.
+type KeyProvider interface {
+	// CurrentKey returns the ID of the key new stores are encrypted with, and the key.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given ID.
+	Key(id string) ([]byte, error)
+}
+
+var (
+	// ErrNoKeyProvider is returned when opening an encrypted store without a key provider.
+	ErrNoKeyProvider = errors.New("store is encrypted but the log has no key provider")
+	// ErrUnknownKey is returned by a key provider that doesn't have the requested key.
+	ErrUnknownKey = errors.New("unknown encryption key")
+)
+
+const (
+	encryptionMagic   = "ENCS"
+	encryptionVersion = 1
+
+	// attrEncrypted marks an encrypted frame in its attributes, the lower bits hold the compression
+	attrEncrypted byte = 0x80
+)
+
+// FileKeyProvider is a KeyProvider reading keys from a directory. Every key is a file named
+// <id>.key holding the hex-encoded AES key (16, 24 or 32 bytes), and the CURRENT file holds
+// the ID of the key new stores are encrypted with. Keys are read on every call, so a rotation
+// doesn't need a restart.
+type FileKeyProvider struct {
+	Dir string
+}
+
+// NewFileKeyProvider returns a KeyProvider reading keys from dir.
+func NewFileKeyProvider(dir string) *FileKeyProvider {
+	return &FileKeyProvider{Dir: dir}
+}
+
+func (p *FileKeyProvider) CurrentKey() (string, []byte, error) {
+	b, err := ioutil.ReadFile(path.Join(p.Dir, "CURRENT"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	id := strings.TrimSpace(string(b))
+	key, err := p.Key(id)
+	return id, key, err
+}
+
+func (p *FileKeyProvider) Key(id string) ([]byte, error) {
+	if err := validateKeyID(id); err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path.Join(p.Dir, id+".key"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(b)))
+}
+
+// Rotate adds the key with the given ID and makes it the current key.
+func (p *FileKeyProvider) Rotate(id string, key []byte) error {
+	if err := validateKeyID(id); err != nil {
+		return err
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(p.Dir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(p.Dir, id+".key"), []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return err
+	}
+
+	// Renaming switches the current key atomically
+	tmp := path.Join(p.Dir, "CURRENT.tmp")
+	if err := ioutil.WriteFile(tmp, []byte(id+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(p.Dir, "CURRENT"))
+}
+
+func validateKeyID(id string) error {
+	if id == "" || len(id) > 1<<16-1 || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("invalid encryption key ID: %q", id)
+	}
+	return nil
+}
+
+// encryptionHeader returns the header of an encrypted store or stream.
+func encryptionHeader(id string) []byte {
+	b := append([]byte(encryptionMagic), encryptionVersion)
+	b = enc.AppendUint16(b, uint16(len(id)))
+	return append(b, id...)
+}
+
+// readEncryptionHeader reads the header of an encrypted store or stream from r and returns the
+// key ID. It returns io.ErrUnexpectedEOF if the header is cut short.
+func readEncryptionHeader(r io.Reader) (string, error) {
+	b := make([]byte, len(encryptionMagic)+3)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	if string(b[:len(encryptionMagic)]) != encryptionMagic {
+		return "", fmt.Errorf("not an encrypted store")
+	}
+	if v := b[len(encryptionMagic)]; v != encryptionVersion {
+		return "", fmt.Errorf("%w: encryption version %d", ErrUnsupportedFormat, v)
+	}
+
+	id := make([]byte, enc.Uint16(b[len(encryptionMagic)+1:]))
+	if _, err := io.ReadFull(r, id); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(id), nil
+}
+
+// newAEAD returns the AES-GCM cipher for the key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readStore opens the store in f for reading, with the key its header names if it's encrypted.
+// If a crash cut the header short, it returns the store along with io.ErrUnexpectedEOF.
+func readStore(f *os.File, keys KeyProvider) (*store, error) {
+	s, err := newStore(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// A plaintext frame starts with its attributes, which never look like the magic
+	head := make([]byte, len(encryptionMagic))
+	n, _ := f.ReadAt(head, 0)
+	if n == 0 || !bytes.HasPrefix([]byte(encryptionMagic), head[:n]) {
+		return s, nil
+	}
+	if keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	id, err := readEncryptionHeader(io.NewSectionReader(f, 0, int64(s.size)))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+	s.start = uint64(len(encryptionHeader(id)))
+	return s, nil
+}
+
+// openStore opens the store in f for appending. A new store is encrypted with the current key
+// of keys, while a store that already has records keeps the encryption, or lack of it, it was
+// written with.
+func openStore(f *os.File, keys KeyProvider) (*store, error) {
+	s, err := readStore(f, keys)
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		// Nothing but the header was ever written, start over
+	case err != nil:
+		return nil, err
+	case s.aead != nil || s.size > 0 || keys == nil:
+		return s, nil
+	}
+
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+
+	header := encryptionHeader(id)
+	if err = s.File.Truncate(0); err != nil {
+		return nil, err
+	}
+	if _, err = s.File.Write(header); err != nil {
+		return nil, err
+	}
+	s.size = uint64(len(header))
+	s.start = s.size
+	s.flushed.Store(s.size)
+	return s, nil
+}
+
+// seal encrypts the data of a frame with the given attributes, if the store is encrypted.
+func (s *store) seal(p []byte, attrs byte) ([]byte, byte, error) {
+	if s.aead == nil {
+		return p, attrs, nil
+	}
+
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(p)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, 0, err
+	}
+	return s.aead.Seal(nonce, nonce, p, []byte{attrs}), attrs | attrEncrypted, nil
+}
+
+// unseal decrypts the data of an encrypted frame and returns it with the frame's other attributes.
+// The data of a plaintext frame is returned as is.
+func unseal(aead cipher.AEAD, attrs byte, p []byte) (byte, []byte, error) {
+	if attrs&attrEncrypted == 0 {
+		return attrs, p, nil
+	}
+	if aead == nil {
+		return 0, nil, ErrNoKeyProvider
+	}
+
+	attrs &^= attrEncrypted
+	if len(p) < aead.NonceSize() {
+		return 0, nil, ErrCorruptRecord
+	}
+	nonce, ciphertext := p[:aead.NonceSize()], p[aead.NonceSize():]
+	b, err := aead.Open(nil, nonce, ciphertext, []byte{attrs})
+	if err != nil {
+		return 0, nil, ErrCorruptRecord
+	}
+	return attrs, b, nil
+}
+
+// decryptFrames returns a reader of the raw store file in r with its frames decrypted, as a
+// plaintext store would hold them. A plaintext store is returned as is.
+func decryptFrames(r io.Reader, keys KeyProvider) (io.Reader, error) {
+	head := make([]byte, len(encryptionMagic))
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	r = io.MultiReader(bytes.NewReader(head[:n]), r)
+	if n == 0 || !bytes.HasPrefix([]byte(encryptionMagic), head[:n]) {
+		return r, nil
+	}
+	if keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	id, err := readEncryptionHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &frameDecrypter{r: r, aead: aead}, nil
+}
+
+type frameDecrypter struct {
+	r    io.Reader
+	aead cipher.AEAD
+	buf  []byte
+}
+
+func (d *frameDecrypter) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		header := make([]byte, HeaderWidth)
+		if _, err := io.ReadFull(d.r, header); err != nil {
+			return 0, err
+		}
+
+		attrs, size := parseFrameLen(enc.Uint64(header[:LenWidth]))
+		b := make([]byte, size)
+		if _, err := io.ReadFull(d.r, b); err != nil {
+			return 0, err
+		}
+		if crc32.Checksum(b, crcTable) != enc.Uint32(header[LenWidth:]) {
+			return 0, ErrCorruptRecord
+		}
+
+		attrs, b, err := unseal(d.aead, attrs, b)
+		if err != nil {
+			return 0, err
+		}
+		d.buf = enc.AppendUint64(d.buf, frameLen(len(b), attrs))
+		d.buf = enc.AppendUint32(d.buf, crc32.Checksum(b, crcTable))
+		d.buf = append(d.buf, b...)
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// streamChunk is the amount of plaintext EncryptStream seals at a time.
+const streamChunk = 64 * 1024
+
+// EncryptStream returns a writer encrypting what's written to it into w with the current key
+// of keys. The stream has the header of an encrypted store, followed by chunks of
+//
+//	[4-byte length][12-byte nonce][AES-GCM ciphertext and tag]
+//
+// each authenticated with its sequence number and whether it's the last one, so chunks can't
+// be reordered or dropped. Close writes the last chunk and must be called.
+func EncryptStream(w io.Writer, keys KeyProvider) (io.WriteCloser, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(encryptionHeader(id)); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead}, nil
+}
+
+type encryptWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		m := streamChunk - len(e.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		e.buf = append(e.buf, p[:m]...)
+		p = p[m:]
+
+		// Keep a full chunk until more data shows it isn't the last one
+		if len(e.buf) == streamChunk && len(p) > 0 {
+			if err := e.flush(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(e.buf)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	chunk := e.aead.Seal(nonce, nonce, e.buf, chunkAD(e.seq, last))
+
+	b := enc.AppendUint32(make([]byte, 0, 4+len(chunk)), uint32(len(chunk)))
+	if _, err := e.w.Write(append(b, chunk...)); err != nil {
+		return err
+	}
+	e.seq++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// chunkAD is the additional data a stream chunk is authenticated with.
+func chunkAD(seq uint64, last bool) []byte {
+	b := enc.AppendUint64(nil, seq)
+	if last {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// DecryptStream returns a reader of what EncryptStream wrote to r. A stream without the header
+// of an encrypted store is returned as is, so streams written before encryption was turned on
+// stay readable.
+func DecryptStream(r io.Reader, keys KeyProvider) (io.Reader, error) {
+	head := make([]byte, len(encryptionMagic))
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	r = io.MultiReader(bytes.NewReader(head[:n]), r)
+	if string(head[:n]) != encryptionMagic {
+		return r, nil
+	}
+	if keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	id, err := readEncryptionHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead}, nil
+}
+
+type decryptReader struct {
+	r    io.Reader
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+	done bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(d.r, header); err != nil {
+			// The stream must end with its last chunk
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		chunk := make([]byte, enc.Uint32(header))
+		if _, err := io.ReadFull(d.r, chunk); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if len(chunk) < d.aead.NonceSize() {
+			return 0, ErrCorruptRecord
+		}
+
+		nonce, ciphertext := chunk[:d.aead.NonceSize()], chunk[d.aead.NonceSize():]
+		b, err := d.aead.Open(nil, nonce, ciphertext, chunkAD(d.seq, false))
+		if err != nil {
+			if b, err = d.aead.Open(nil, nonce, ciphertext, chunkAD(d.seq, true)); err != nil {
+				return 0, ErrCorruptRecord
+			}
+			d.done = true
+		}
+		d.seq++
+		d.buf = b
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
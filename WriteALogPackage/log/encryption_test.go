@@ -0,0 +1,157 @@
+package log
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeys(t *testing.T, dir string, id string) *FileKeyProvider {
+	keys := NewFileKeyProvider(dir)
+	rotateTestKey(t, keys, id)
+	return keys
+}
+
+func rotateTestKey(t *testing.T, keys *FileKeyProvider, id string) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	require.NoError(t, keys.Rotate(id, key))
+}
+
+func TestEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keys := newTestKeys(t, path.Join(dir, "keys"), "k1")
+	logDir := path.Join(dir, "log")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 3
+	c.Encryption.Keys = keys
+	log, err := NewLog(logDir, c)
+	require.NoError(t, err)
+
+	secret := []byte("card number 4242 4242 4242 4242")
+	_, err = log.Append(&api.Record{Value: secret})
+	require.NoError(t, err)
+
+	// the segment rolled when the batch filled it is encrypted with the rotated key,
+	// while the full segment stays with its own
+	rotateTestKey(t, keys, "k2")
+	_, err = log.AppendCompressedBatch([]*api.Record{{Value: secret}, {Value: secret}}, CompressionGzip)
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Value: secret})
+	require.NoError(t, err)
+	require.Len(t, log.segments, 2)
+
+	for i, want := range []string{"k1", "k2"} {
+		require.NoError(t, log.segments[i].store.Sync())
+		b, err := ioutil.ReadFile(log.segments[i].store.Name())
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(b, encryptionHeader(want)))
+		require.False(t, bytes.Contains(b, secret))
+	}
+
+	check := func(log *Log) {
+		for i := uint64(0); i < 4; i++ {
+			read, err := log.Read(i)
+			require.NoError(t, err)
+			require.Equal(t, i, read.Offset)
+			require.Equal(t, secret, read.Value)
+		}
+	}
+	check(log)
+
+	// the reader holds the records decrypted
+	r := log.Reader()
+	var n int
+	for {
+		records, err := ReadFrame(r)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		n += len(records)
+	}
+	require.Equal(t, 4, n)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(logDir, c)
+	require.NoError(t, err)
+	check(log)
+
+	infos, err := InspectSegments(logDir, keys)
+	require.NoError(t, err)
+	for _, info := range infos {
+		require.Empty(t, info.Problem)
+	}
+	require.NoError(t, log.Close())
+
+	// a torn tail is trimmed without touching the header
+	f, err := os.OpenFile(segmentFile(logDir, 3, ".store"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	log, err = NewLog(logDir, c)
+	require.NoError(t, err)
+	check(log)
+	off, err := log.Append(&api.Record{Value: secret})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+	require.NoError(t, log.Close())
+
+	// encrypted segments can't be opened without the keys
+	_, err = NewLog(logDir, Config{})
+	require.Equal(t, ErrNoKeyProvider, err)
+}
+
+func TestEncryptStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypt-stream-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keys := newTestKeys(t, dir, "snapshot")
+
+	// several chunks, the last one partial
+	payload := make([]byte, streamChunk*2+100)
+	_, err = rand.Read(payload)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w, err := EncryptStream(&buf, keys)
+	require.NoError(t, err)
+	_, err = w.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.False(t, bytes.Contains(buf.Bytes(), payload[:64]))
+
+	r, err := DecryptStream(bytes.NewReader(buf.Bytes()), keys)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, payload, got)
+
+	// dropping the last chunk is detected
+	r, err = DecryptStream(bytes.NewReader(buf.Bytes()[:buf.Len()-200]), keys)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	require.Error(t, err)
+
+	// a stream written without encryption is read as is
+	r, err = DecryptStream(bytes.NewReader([]byte("plain")), keys)
+	require.NoError(t, err)
+	got, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("plain"), got)
+}
@@ -3,6 +3,7 @@ package log
 import (
 	"io"
 	"os"
+	"sort"
 
 	"github.com/tysonmote/gommap"
 )
@@ -49,7 +50,8 @@ func newIndex(f *os.File, c Config) (*index, error) {
 
 	// Pre-allocate file to maximum size for performance optimization
 	// This avoids frequent file system calls during writes
-	if err = f.Truncate(int64(c.Segment.MaxIndexBytes)); err != nil {
+	// An index written with a bigger MaxIndexBytes is never shrunk, that would drop entries
+	if err = f.Truncate(int64(maxUint64(c.Segment.MaxIndexBytes, idx.size))); err != nil {
 		return nil, err
 	}
 
@@ -65,8 +67,10 @@ func newIndex(f *os.File, c Config) (*index, error) {
 
 func (i *index) Close() error {
 	// Flushes any changes from the memory-mapped region back to the underlying file
-	if err := i.mmap.Sync(gommap.MS_ASYNC); err != nil {
-		return err
+	if i.mmap != nil {
+		if err := i.mmap.Sync(gommap.MS_ASYNC); err != nil {
+			return err
+		}
 	}
 
 	//  Forces the operating system to flush any buffered data to the physical storage device
@@ -79,7 +83,38 @@ func (i *index) Close() error {
 		return err
 	}
 
-	return i.file.Close()
+	if err := i.file.Close(); err != nil {
+		return err
+	}
+
+	// Gives the address space back, a segment's readers are done with the entries by the time it's closed
+	if i.mmap != nil {
+		if err := i.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		i.mmap = nil
+	}
+	return nil
+}
+
+// reopen opens the file of a closed index again and maps its entries read-only. Only the index
+// of a sealed segment is reopened, so it's mapped at its size rather than MaxIndexBytes.
+func (i *index) reopen() (err error) {
+	if i.file, err = os.OpenFile(i.file.Name(), os.O_RDWR, 0644); err != nil {
+		return err
+	}
+
+	// An empty file can't be mapped, and there are no entries to read
+	if i.size == 0 {
+		return nil
+	}
+	i.mmap, err = gommap.Map(i.file.Fd(), gommap.PROT_READ, gommap.MAP_SHARED)
+	return err
+}
+
+// Sync writes the memory-mapped entries back to the file and waits for them to reach stable storage.
+func (i *index) Sync() error {
+	return i.mmap.Sync(gommap.MS_SYNC)
 }
 
 // Read returns the associated record's position in the store given the offset
@@ -109,6 +144,40 @@ func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
 	return out, pos, nil
 }
 
+// Find returns the first entry whose offset is at or after the given relative offset.
+func (i *index) Find(off uint32) (out uint32, pos uint64, err error) {
+	return i.find(off, i.size/entWidth)
+}
+
+// find is Find over the first n entries. It only reads the mapped entries, not the index's size,
+// so it can run concurrently with a writer appending entries after them.
+// Offsets are contiguous unless the segment was compacted, so the entry at slot `off`
+// is checked first and the entries are only binary searched when records are missing.
+func (i *index) find(off uint32, n uint64) (out uint32, pos uint64, err error) {
+	if uint64(off) < n {
+		if out, pos = i.entry(uint64(off)); out == off {
+			return out, pos, nil
+		}
+	}
+
+	slot := sort.Search(int(n), func(slot int) bool {
+		got, _ := i.entry(uint64(slot))
+		return got >= off
+	})
+
+	if uint64(slot) == n {
+		return 0, 0, io.EOF
+	}
+	out, pos = i.entry(uint64(slot))
+	return out, pos, nil
+}
+
+// entry returns the offset and position held by the given slot, which must be in use.
+func (i *index) entry(slot uint64) (off uint32, pos uint64) {
+	b := i.mmap[slot*entWidth : (slot+1)*entWidth]
+	return enc.Uint32(b[:offWidth]), enc.Uint64(b[offWidth:])
+}
+
 // Write persists an offset and position pair to the index
 func (i *index) Write(off uint32, pos uint64) error {
 	// Check if there's space for another entry
@@ -128,3 +197,26 @@ func (i *index) Write(off uint32, pos uint64) error {
 func (i *index) Name() string {
 	return i.file.Name()
 }
+
+// Truncate keeps the first n entries of the index and drops the rest.
+func (i *index) Truncate(n uint64) error {
+	size := n * entWidth
+	if size > i.size {
+		return io.EOF
+	}
+
+	// Zero the dropped entries so a later crash can't bring them back
+	for b := size; b < i.size; b++ {
+		i.mmap[b] = 0
+	}
+
+	i.size = size
+	return nil
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
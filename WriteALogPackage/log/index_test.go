@@ -53,4 +53,16 @@ func TestIndex(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, uint32(1), off)
 	require.Equal(t, entries[1].Pos, pos)
+
+	// compacted segments leave gaps, Find skips ahead to the next entry
+	require.NoError(t, idx.Write(4, 20))
+	off, pos, err = idx.Find(2)
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), off)
+	require.Equal(t, uint64(20), pos)
+	off, _, err = idx.Find(1)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), off)
+	_, _, err = idx.Find(5)
+	require.Equal(t, io.EOF, err)
 }
@@ -0,0 +1,296 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+)
+
+/*
+	* These functions work on the files of a log that isn't open, like a stopped node's log directory,
+	  and back the streamingsystem-logtool command. They don't go through recover or migrate, so
+	  they show the files exactly as they are on disk. They read the current format, so the tool
+	  checks DirFormatVersion first.
+*/
+
+// SegmentInfo describes the files of a segment as they are on disk.
+type SegmentInfo struct {
+	BaseOffset   uint64
+	NextOffset   uint64 // one past the last offset in the index
+	StoreBytes   uint64
+	IndexEntries uint64
+	Records      uint64 // records in the intact frames of the store
+	Problem      string // how the index and the store disagree, empty if they match
+}
+
+type indexEntry struct {
+	off uint32
+	pos uint64
+}
+
+// InspectSegments describes every segment in dir and checks its index against its store.
+// keys decrypts encrypted stores and may be nil if there are none.
+func InspectSegments(dir string, keys KeyProvider) ([]SegmentInfo, error) {
+	baseOffsets, err := manifestBaseOffsets(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SegmentInfo, 0, len(baseOffsets))
+	for _, base := range baseOffsets {
+		info, err := inspectSegment(dir, base, keys)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func inspectSegment(dir string, base uint64, keys KeyProvider) (SegmentInfo, error) {
+	info := SegmentInfo{BaseOffset: base, NextOffset: base}
+
+	entries, err := readIndexFile(segmentFile(dir, base, ".index"))
+	if err != nil {
+		return info, err
+	}
+	info.IndexEntries = uint64(len(entries))
+	if len(entries) > 0 {
+		info.NextOffset = base + uint64(entries[len(entries)-1].off) + 1
+	}
+
+	want, end, err := storeIndexEntries(dir, base, keys)
+	if err != nil {
+		return info, err
+	}
+	info.Records = uint64(len(want))
+
+	fi, err := os.Stat(segmentFile(dir, base, ".store"))
+	if err != nil {
+		return info, err
+	}
+	info.StoreBytes = uint64(fi.Size())
+
+	if end < info.StoreBytes {
+		info.Problem = fmt.Sprintf("store has %d bytes of torn or corrupt data after position %d", info.StoreBytes-end, end)
+	} else {
+		info.Problem = compareIndex(base, entries, want)
+	}
+	return info, nil
+}
+
+// checkIndex compares the index of the segment with the given base offset against the intact
+// frames of its store, and returns how they disagree, or an empty string if they match.
+// A torn tail in the store alone isn't a mismatch; segment.recover trims it.
+func checkIndex(dir string, base uint64, keys KeyProvider) (string, error) {
+	entries, err := readIndexFile(segmentFile(dir, base, ".index"))
+	if err != nil {
+		return "", err
+	}
+
+	// Most of the time the last index entry points at the last frame of the store,
+	// and the segment doesn't need to be walked
+	ok, err := indexEndsAtStore(dir, base, entries, keys)
+	if err != nil || ok {
+		return "", err
+	}
+
+	want, _, err := storeIndexEntries(dir, base, keys)
+	if err != nil {
+		return "", err
+	}
+	return compareIndex(base, entries, want), nil
+}
+
+// indexEndsAtStore reports whether the last index entry points at the last record of an
+// intact frame that ends where the store does.
+func indexEndsAtStore(dir string, base uint64, entries []indexEntry, keys KeyProvider) (bool, error) {
+	f, err := os.Open(segmentFile(dir, base, ".store"))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	s, err := readStore(f, keys)
+	if err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if len(entries) == 0 {
+		return s.size == s.start, nil
+	}
+
+	last := entries[len(entries)-1]
+	attrs, p, err := s.read(last.pos)
+	if err != nil || last.pos+HeaderWidth+uint64(len(p)) != s.size {
+		return false, nil
+	}
+
+	if attrs, p, err = unseal(s.aead, attrs, p); err != nil {
+		return false, nil
+	}
+	records, err := decodeFrame(attrs, p)
+	if err != nil || len(records) == 0 {
+		return false, nil
+	}
+	return records[len(records)-1].Offset == base+uint64(last.off), nil
+}
+
+// storeIndexEntries indexes the intact frames of a segment's store the way segment.write would
+// have, and returns the entries along with the position the intact frames end at.
+func storeIndexEntries(dir string, base uint64, keys KeyProvider) ([]indexEntry, uint64, error) {
+	var entries []indexEntry
+	end, err := scanStoreFile(segmentFile(dir, base, ".store"), keys, func(pos uint64, records []*api.Record) error {
+		for _, record := range records {
+			entries = append(entries, indexEntry{off: uint32(record.Offset - base), pos: pos})
+		}
+		return nil
+	})
+	return entries, end, err
+}
+
+// compareIndex describes the first difference between the entries of an index and the ones
+// its store calls for, or returns an empty string if there is none.
+func compareIndex(base uint64, entries, want []indexEntry) string {
+	if len(entries) != len(want) {
+		return fmt.Sprintf("index has %d entries but store has %d records", len(entries), len(want))
+	}
+	for i := range entries {
+		if entries[i] != want[i] {
+			return fmt.Sprintf("index entry %d is offset %d at position %d but store has offset %d at position %d",
+				i, base+uint64(entries[i].off), entries[i].pos, base+uint64(want[i].off), want[i].pos)
+		}
+	}
+	return ""
+}
+
+// DumpRecords calls fn for every record in the stores of dir in offset order.
+func DumpRecords(dir string, keys KeyProvider, fn func(*api.Record) error) error {
+	baseOffsets, err := manifestBaseOffsets(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, base := range baseOffsets {
+		if _, err = scanStoreFile(segmentFile(dir, base, ".store"), keys, func(_ uint64, records []*api.Record) error {
+			for _, record := range records {
+				if err := fn(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildIndex rewrites the index and the time index of the segment with the given base offset
+// from the intact frames of its store, and returns the number of index entries written.
+func RebuildIndex(dir string, base uint64, keys KeyProvider) (uint64, error) {
+	var index, timeIndex []byte
+	var maxTimestamp int64
+	if _, err := scanStoreFile(segmentFile(dir, base, ".store"), keys, func(pos uint64, records []*api.Record) error {
+		for _, record := range records {
+			off := uint32(record.Offset - base)
+			index = enc.AppendUint32(index, off)
+			index = enc.AppendUint64(index, pos)
+
+			if record.Timestamp > maxTimestamp {
+				timeIndex = enc.AppendUint64(timeIndex, uint64(record.Timestamp))
+				timeIndex = enc.AppendUint32(timeIndex, off)
+				maxTimestamp = record.Timestamp
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	if err := ioutil.WriteFile(segmentFile(dir, base, ".index"), index, 0644); err != nil {
+		return 0, err
+	}
+	if err := ioutil.WriteFile(segmentFile(dir, base, ".timeindex"), timeIndex, 0644); err != nil {
+		return 0, err
+	}
+	return uint64(len(index)) / entWidth, nil
+}
+
+// segmentFile returns the name of a segment's file with the given extension.
+func segmentFile(dir string, base uint64, ext string) string {
+	return path.Join(dir, fmt.Sprintf("%d%s", base, ext))
+}
+
+// readIndexFile returns the entries of an index file. The file may still be pre-allocated
+// if the log wasn't closed, so it stops at the first zero-filled entry.
+func readIndexFile(name string) ([]indexEntry, error) {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []indexEntry
+	for slot := uint64(0); (slot+1)*entWidth <= uint64(len(b)); slot++ {
+		e := b[slot*entWidth:]
+		entry := indexEntry{off: enc.Uint32(e[:offWidth]), pos: enc.Uint64(e[offWidth:entWidth])}
+		// Only the first entry can have a zero relative offset
+		if slot > 0 && entry.off == 0 {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// scanStoreFile calls fn with the position and the records of every frame in a store file.
+// It stops at the first torn or corrupt frame and returns the position the intact frames end at.
+func scanStoreFile(name string, keys KeyProvider, fn func(pos uint64, records []*api.Record) error) (uint64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s, err := readStore(f, keys)
+	if err == io.ErrUnexpectedEOF {
+		// Not even the header of the encrypted store is intact
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	pos := s.start
+	for pos < s.size {
+		attrs, p, err := s.read(pos)
+		if err != nil {
+			break
+		}
+
+		plainAttrs, plain, err := unseal(s.aead, attrs, p)
+		if err != nil {
+			break
+		}
+		records, err := decodeFrame(plainAttrs, plain)
+		if err != nil {
+			break
+		}
+
+		if err = fn(pos, records); err != nil {
+			return pos, err
+		}
+		pos += HeaderWidth + uint64(len(p))
+	}
+	return pos, nil
+}
@@ -0,0 +1,157 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestInspect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = log.Append(&api.Record{Value: write})
+		require.NoError(t, err)
+	}
+	_, err = log.AppendCompressedBatch([]*api.Record{{Value: write}, {Value: write}}, CompressionGzip)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	infos, err := InspectSegments(dir, nil)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, uint64(0), infos[0].BaseOffset)
+	require.Equal(t, uint64(5), infos[0].NextOffset)
+	require.Equal(t, uint64(5), infos[0].IndexEntries)
+	require.Equal(t, uint64(5), infos[0].Records)
+	require.Empty(t, infos[0].Problem)
+
+	var offsets []uint64
+	require.NoError(t, DumpRecords(dir, nil, func(record *api.Record) error {
+		offsets = append(offsets, record.Offset)
+		return nil
+	}))
+	require.Equal(t, []uint64{0, 1, 2, 3, 4}, offsets)
+
+	// a lost index is detected and rebuilt from the store
+	require.NoError(t, os.Remove(segmentFile(dir, 0, ".index")))
+	infos, err = InspectSegments(dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), infos[0].IndexEntries)
+	require.NotEmpty(t, infos[0].Problem)
+
+	entries, err := RebuildIndex(dir, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), entries)
+
+	infos, err = InspectSegments(dir, nil)
+	require.NoError(t, err)
+	require.Empty(t, infos[0].Problem)
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	read, err := log.Read(4)
+	require.NoError(t, err)
+	require.Equal(t, write, read.Value)
+	off, err := log.OffsetForTime(time.Unix(0, 0))
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	require.NoError(t, log.Close())
+
+	// a torn tail is reported
+	f, err := os.OpenFile(segmentFile(dir, 0, ".store"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	infos, err = InspectSegments(dir, nil)
+	require.NoError(t, err)
+	require.Contains(t, infos[0].Problem, "torn")
+}
+
+func TestRepairIndexOnStartup(t *testing.T) {
+	for scenario, damage := range map[string]func(t *testing.T, dir string){
+		"lost index": func(t *testing.T, dir string) {
+			require.NoError(t, os.Remove(segmentFile(dir, 0, ".index")))
+		},
+		"zero-length index": func(t *testing.T, dir string) {
+			require.NoError(t, os.Truncate(segmentFile(dir, 0, ".index"), 0))
+		},
+		"index shorter than store": func(t *testing.T, dir string) {
+			require.NoError(t, os.Truncate(segmentFile(dir, 0, ".index"), int64(entWidth)))
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "repair-index-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			c := Config{}
+			c.Segment.MaxIndexBytes = entWidth * 3
+			log, err := NewLog(dir, c)
+			require.NoError(t, err)
+
+			for i := 0; i < 4; i++ {
+				_, err = log.Append(&api.Record{Value: write})
+				require.NoError(t, err)
+			}
+			require.Len(t, log.segments, 2)
+			require.NoError(t, log.Close())
+
+			damage(t, dir)
+
+			log, err = NewLog(dir, c)
+			require.NoError(t, err)
+			defer log.Close()
+
+			for i := uint64(0); i < 4; i++ {
+				read, err := log.Read(i)
+				require.NoError(t, err)
+				require.Equal(t, i, read.Offset)
+			}
+
+			// the rebuilt segment keeps its records instead of being trimmed to the index
+			require.Equal(t, uint64(3), log.segments[0].nextOffset)
+			off, err := log.Append(&api.Record{Value: write})
+			require.NoError(t, err)
+			require.Equal(t, uint64(4), off)
+		})
+	}
+
+	// a record written to the store of the active segment without being indexed
+	dir, err := ioutil.TempDir("", "repair-index-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	_, err = log.Append(&api.Record{Value: write})
+	require.NoError(t, err)
+
+	p, err := proto.Marshal(&api.Record{Value: write, Offset: 1})
+	require.NoError(t, err)
+	_, _, err = log.activeSegment.store.Append(p)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+}
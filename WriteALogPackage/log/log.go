@@ -1,6 +1,8 @@
 package log
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,10 +11,20 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"io"
 
 	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrEmptyBatch is returned when AppendBatch is called without records
+	ErrEmptyBatch = errors.New("empty batch")
+	// ErrBatchTooLarge is returned when a batch has more records than a segment's index can hold
+	ErrBatchTooLarge = errors.New("batch too large for a segment")
 )
 
 type Log struct {
@@ -23,6 +35,46 @@ type Log struct {
 
 	activeSegment *segment
 	segments      []*segment
+
+	// What readers see of segments and remote, published by whoever changes them, so Read
+	// doesn't take mu and never waits for an append
+	view atomic.Pointer[view]
+
+	// Tiered storage: the segments offloaded to Config.Tiering.Store, which all come before segments,
+	// and the ones fetched back for reading, most recently used last
+	remote  []*segmentMeta
+	fetched struct {
+		sync.Mutex
+		segments []*segment
+	}
+
+	// The offset the next record gets, published once the records before it can be read.
+	// Readers waiting for records block on appended, which the next append closes, see Wait.
+	next     atomic.Uint64
+	waitMu   sync.Mutex
+	appended chan struct{}
+
+	// Closes the sealed segments read least recently beyond Config.Segment.MaxOpenSegments
+	lru *segmentLRU
+
+	// Serializes compaction passes, which run mostly without mu, see compact.go
+	compactMu sync.Mutex
+
+	// Background tasks (retention, compaction, fsync), running while the log is open
+	background []*backgroundTask
+
+	// Durability: offsets below synced are on stable storage. syncMu is taken before mu
+	// by everything that closes segments, so an fsync never races with closing a file.
+	syncMu   sync.Mutex
+	syncCond *sync.Cond
+	synced   uint64
+	syncErr  error
+}
+
+// view is a snapshot of the log's segments. It's replaced, never changed, so readers can use it without locks.
+type view struct {
+	remote   []*segmentMeta
+	segments []*segment
 }
 
 func NewLog(dir string, c Config) (*Log, error) {
@@ -34,22 +86,172 @@ func NewLog(dir string, c Config) (*Log, error) {
 		c.Segment.MaxStoreBytes = 1024
 	}
 
+	if err := validateSync(c); err != nil {
+		return nil, err
+	}
+
 	l := &Log{
 		Dir:    dir,
 		Config: c,
+		lru:    newSegmentLRU(c.Segment.MaxOpenSegments),
 	}
+	l.syncCond = sync.NewCond(&l.syncMu)
 	return l, l.setup()
 }
 
 // setup initializes the log by loading existing segments from disk or creating a new initial segment
 func (l *Log) setup() error {
-	files, err := ioutil.ReadDir(l.Dir)
+	// A reset log starts over without the segments it had
+	l.segments, l.activeSegment, l.remote = nil, nil, nil
+
+	// Finish or roll back a restore that was interrupted by a crash, see snapshot.go
+	if err := l.finishRestore(); err != nil {
+		return err
+	}
+
+	// Finish or roll back a compaction that was interrupted by a crash
+	if err := os.RemoveAll(path.Join(l.Dir, cleanerDir)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(l.Dir, compactDir)); err != nil {
+		return err
+	}
+	if err := completeSwap(l.Dir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(l.Dir, remoteCacheDir)); err != nil {
+		return err
+	}
+
+	m, err := openManifest(l.Dir, l.Config)
+	if err != nil {
+		return err
+	}
+
+	for _, ms := range m.Segments {
+		// Finish removing a segment whose deletion was interrupted by a crash
+		if ms.State == SegmentDeleting {
+			if err = removeSegmentFiles(l.Dir, ms.BaseOffset); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// An offloaded segment only has its metadata here, and maybe files left behind by a crash
+		if ms.State == SegmentRemote {
+			if l.Config.Tiering.Store == nil {
+				return fmt.Errorf("segment %d is offloaded but the log has no object store", ms.BaseOffset)
+			}
+			l.remote = append(l.remote, &segmentMeta{
+				baseOffset:   ms.BaseOffset,
+				nextOffset:   ms.NextOffset,
+				storeBytes:   ms.StoreBytes,
+				maxTimestamp: ms.MaxTimestamp,
+			})
+			if err = removeSegmentFiles(l.Dir, ms.BaseOffset); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Seal the segment opened before this one, so the LRU can close it before the next one opens
+		if l.activeSegment != nil {
+			if err = l.seal(l.activeSegment); err != nil {
+				return err
+			}
+		}
+
+		if _, err = os.Stat(segmentFile(l.Dir, ms.BaseOffset, ".store")); err != nil {
+			return fmt.Errorf("segment %d in %s: %w", ms.BaseOffset, manifestFile, err)
+		}
+
+		// Regenerate an index that doesn't match its store, like one that was lost or
+		// cut short by a crash between writing a record and indexing it
+		if err = l.repairIndex(ms.BaseOffset); err != nil {
+			return err
+		}
+
+		// Open each segment the manifest lists
+		// Each segment consists of a .store, an .index and a .timeindex file
+		if err = l.openSegment(ms.BaseOffset); err != nil {
+			return err
+		}
+
+		// Trim any torn or corrupt records left behind by a crash
+		if _, err = l.activeSegment.recover(); err != nil {
+			return err
+		}
+	}
+
+	// If no segments exist, create the initial segment
+	if l.segments == nil {
+		if err = l.openSegment(l.Config.Segment.InitialOffset); err != nil {
+			return err
+		}
+	}
+
+	if err = l.writeManifest(); err != nil {
+		return err
+	}
+	l.publish()
+
+	l.notifyAppended(l.activeSegment.nextOffset)
+
+	// Everything recovered from disk counts as synced
+	l.synced = l.activeSegment.nextOffset
+
+	l.startSync()
+	if l.Config.Segment.MaxAge > 0 {
+		l.startBackground(l.Config.Segment.MaxAge/10, l.Roll)
+	}
+	if l.Config.Retention.CheckInterval > 0 {
+		l.startBackground(l.Config.Retention.CheckInterval, l.EnforceRetention)
+	}
+	if l.Config.Compaction.CheckInterval > 0 {
+		l.startBackground(l.Config.Compaction.CheckInterval, l.Compact)
+	}
+	if l.Config.Tiering.CheckInterval > 0 {
+		l.startBackground(l.Config.Tiering.CheckInterval, l.Offload)
+	}
+	return nil
+}
+
+// repairIndex rebuilds the index of the segment with the given base offset from its store
+// if the two disagree. Otherwise recover would trust the index and trim the store to it.
+func (l *Log) repairIndex(base uint64) error {
+	problem, err := checkIndex(l.Dir, base, l.Config.Encryption.Keys)
+	if err != nil || problem == "" {
+		return err
+	}
+
+	entries, err := RebuildIndex(l.Dir, base, l.Config.Encryption.Keys)
 	if err != nil {
 		return err
 	}
 
+	zap.L().Named("log").Warn(
+		"rebuilt segment index from store",
+		zap.String("dir", l.Dir),
+		zap.Uint64("base_offset", base),
+		zap.String("problem", problem),
+		zap.Uint64("entries", entries),
+	)
+	return nil
+}
+
+// segmentBaseOffsets returns the base offsets of the segments in dir in ascending order.
+func segmentBaseOffsets(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
 	var baseOffsets []uint64
 	for _, file := range files {
+		// Each segment has exactly one .store file; its index files are opened alongside it
+		if path.Ext(file.Name()) != ".store" {
+			continue
+		}
 		// TrimSuffix returns s without the provided trailing suffix string. If s doesn't end with suffix, s is returned unchanged.
 		offStr := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
 		off, _ := strconv.ParseUint(offStr, 10, 0)
@@ -59,45 +261,125 @@ func (l *Log) setup() error {
 	sort.Slice(baseOffsets, func(i, j int) bool {
 		return baseOffsets[i] < baseOffsets[j]
 	})
+	return baseOffsets, nil
+}
 
-	for i := 0; i < len(baseOffsets); i = i + 2 {
-		// Create a new segment for each unique base offset
-		// Each segment consists of a pair of files: .store and .index
-		// So, we increment i by 2 in each iteration to skip the duplicate base offsets
-		if err = l.newSegment(baseOffsets[i]); err != nil {
-			return err
-		}
+// newSegment creates a new segment, adds it to the log as the active segment and records it in the manifest.
+// The segment it replaces is sealed.
+func (l *Log) newSegment(off uint64) error {
+	sealed := l.activeSegment
+	if err := l.openSegment(off); err != nil {
+		return err
+	}
+	if err := l.writeManifest(); err != nil {
+		return err
 	}
+	return l.seal(sealed)
+}
 
-	// If no segments exist, create the initial segment
-	if l.segments == nil {
-		if err = l.newSegment(l.Config.Segment.InitialOffset); err != nil {
-			return err
-		}
+// Roll starts a new segment if the active one's first record is older than Segment.MaxAge at now,
+// so retention can delete an idle segment without waiting for another append.
+func (l *Log) Roll(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rollExpired(now)
+}
+
+// rollExpired is Roll for a caller holding mu.
+func (l *Log) rollExpired(now time.Time) error {
+	if !l.activeSegment.expired(now, l.Config.Segment.MaxAge) {
+		return nil
 	}
+	return l.newSegment(l.activeSegment.nextOffset)
+}
 
-	return nil
+// RollOffset returns the base offset of the active segment if its first record is older than
+// maxAge at now. A replicated log doesn't roll on the clock of every replica: its leader decides
+// with RollOffset, and every replica rolls with RollSegment. It returns false if there is nothing to roll.
+func (l *Log) RollOffset(now time.Time, maxAge time.Duration) (uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if !l.activeSegment.expired(now, maxAge) {
+		return 0, false
+	}
+	return l.activeSegment.baseOffset, true
 }
 
-// newSegment creates a new segment and adds it to the log
-func (l *Log) newSegment(off uint64) error {
+// RollSegment starts a new segment if the active one has the given base offset and isn't empty,
+// whatever its age. A roll applied twice is a no-op the second time.
+func (l *Log) RollSegment(base uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.activeSegment
+	if s.baseOffset != base || s.nextOffset == s.baseOffset {
+		return nil
+	}
+	return l.newSegment(s.nextOffset)
+}
+
+// seal maps a segment that's no longer appended to and hands it to the LRU.
+func (l *Log) seal(s *segment) error {
+	if err := s.seal(); err != nil {
+		return err
+	}
+	s.lru = l.lru
+	return l.lru.add(s)
+}
+
+// openSegment opens the segment with the given base offset, creating its files if needed,
+// and adds it to the log as the active segment
+func (l *Log) openSegment(off uint64) error {
 	s, err := newSegment(l.Dir, off, l.Config)
 	if err != nil {
 		return err
 	}
 	l.segments = append(l.segments, s)
 	l.activeSegment = s
+	l.publish()
+
+	if l.Config.Segment.Sync != SyncOSManaged {
+		return syncDir(l.Dir)
+	}
 	return nil
 }
 
+// Append appends the record and returns its offset. Depending on Config.Segment.Sync,
+// it returns only once the record is on stable storage.
 func (l *Log) Append(record *api.Record) (uint64, error) {
+	// With compression on, a record is written as a batch of one
+	if l.Config.Segment.Compression != CompressionNone {
+		return l.AppendBatch([]*api.Record{record})
+	}
+
+	off, err := l.appendRecord(record)
+	if err != nil {
+		return 0, err
+	}
+	return off, l.commit(off)
+}
+
+func (l *Log) appendRecord(record *api.Record) (uint64, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	// Stamp the record with its append time unless the caller (e.g. the Raft leader) already did
+	now := time.Now()
+	if record.Timestamp == 0 {
+		record.Timestamp = now.UnixNano()
+	}
+
+	// A record isn't added to a segment that's already too old
+	if err := l.rollExpired(now); err != nil {
+		return 0, err
+	}
+
 	off, err := l.activeSegment.Append(record)
 	if err != nil {
 		return 0, err
 	}
+	l.notifyAppended(off + 1)
 
 	// If the active segment is full, create a new segment
 	if l.activeSegment.IsMaxed() {
@@ -106,29 +388,206 @@ func (l *Log) Append(record *api.Record) (uint64, error) {
 	return off, err
 }
 
+// AppendBatch appends the records with contiguous offsets and returns the offset of the first one.
+// The batch is written to a single segment, rolling to a new one first if it doesn't fit, and
+// readers see either all of its records or none of them. It's compressed with Config.Segment.Compression.
+func (l *Log) AppendBatch(records []*api.Record) (uint64, error) {
+	return l.AppendCompressedBatch(records, l.Config.Segment.Compression)
+}
+
+// AppendCompressedBatch is AppendBatch with a compression chosen for this batch.
+func (l *Log) AppendCompressedBatch(records []*api.Record, c Compression) (uint64, error) {
+	if len(records) == 0 {
+		return 0, ErrEmptyBatch
+	}
+	if c > CompressionFlate {
+		return 0, ErrUnknownCompression
+	}
+
+	off, err := l.appendRecords(records, c)
+	if err != nil {
+		return 0, err
+	}
+	return off, l.commit(off + uint64(len(records)) - 1)
+}
+
+func (l *Log) appendRecords(records []*api.Record, c Compression) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Every record of the batch gets the same append time
+	now := time.Now()
+	for _, record := range records {
+		if record.Timestamp == 0 {
+			record.Timestamp = now.UnixNano()
+		}
+	}
+
+	if err := l.rollExpired(now); err != nil {
+		return 0, err
+	}
+
+	off, err := l.activeSegment.AppendBatch(records, c)
+	if err == errSegmentFull {
+		if l.activeSegment.nextOffset == l.activeSegment.baseOffset {
+			return 0, ErrBatchTooLarge
+		}
+		if err = l.newSegment(l.activeSegment.nextOffset); err != nil {
+			return 0, err
+		}
+		off, err = l.activeSegment.AppendBatch(records, c)
+		if err == errSegmentFull {
+			return 0, ErrBatchTooLarge
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	l.notifyAppended(l.activeSegment.nextOffset)
+
+	if l.activeSegment.IsMaxed() {
+		err = l.newSegment(l.activeSegment.nextOffset)
+	}
+	return off, err
+}
+
+// notifyAppended publishes that the records before next can be read and wakes the readers waiting for them.
+func (l *Log) notifyAppended(next uint64) {
+	l.next.Store(next)
+
+	l.waitMu.Lock()
+	if l.appended != nil {
+		close(l.appended)
+		l.appended = nil
+	}
+	l.waitMu.Unlock()
+}
+
+// Wait blocks until a record at or after off was appended and returns nil, or until ctx is done
+// and returns ctx.Err(). It returns right away for offsets the log no longer holds, so the
+// caller's Read reports them. Consumers at the end of the log wait here instead of polling Read.
+func (l *Log) Wait(ctx context.Context, off uint64) error {
+	for {
+		// Checked under waitMu, so an append between the check and the wait still wakes us
+		l.waitMu.Lock()
+		if l.next.Load() > off {
+			l.waitMu.Unlock()
+			return nil
+		}
+		if l.appended == nil {
+			l.appended = make(chan struct{})
+		}
+		appended := l.appended
+		l.waitMu.Unlock()
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// publish makes the current segments visible to readers. The caller must hold mu,
+// and must publish before closing any segment it dropped.
+func (l *Log) publish() {
+	l.view.Store(&view{remote: l.remote, segments: l.segments})
+}
+
+// Read returns the record at the given offset. If compaction removed that record,
+// it returns the next surviving record instead, so callers should continue from the
+// returned record's offset. It doesn't take the log's lock, so reads scale with
+// the number of readers and don't wait for appends.
 func (l *Log) Read(off uint64) (*api.Record, error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	record, err := l.read(l.view.Load(), off)
+	if errors.Is(err, os.ErrClosed) {
+		// Compaction, retention or offloading closed the segment under us. They hold mu
+		// until the segments replacing it are published, so read again once they're done.
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return l.read(l.view.Load(), off)
+	}
+	return record, err
+}
 
-	var s *segment
-	for _, segment := range l.segments {
-		// Check if the offset falls within the range of this segment
-		if segment.baseOffset <= off && off < segment.nextOffset {
-			s = segment
-			break
+func (l *Log) read(v *view, off uint64) (*api.Record, error) {
+	// Offsets below the local segments may have been offloaded
+	record, off, err := l.readRemote(v.remote, off)
+	if err != io.EOF {
+		return record, err
+	}
+
+	// Offsets below the first segment were deleted, not compacted
+	segments := v.segments
+	if len(segments) == 0 || off < segments[0].baseOffset {
+		return nil, fmt.Errorf("offset out of range: %d", off)
+	}
+
+	// Find the last segment starting at or before the offset
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].baseOffset > off
+	}) - 1
+
+	for _, s := range segments[i:] {
+		// The offset may sit in a gap left by a segment that compaction emptied
+		if off < s.baseOffset {
+			off = s.baseOffset
+		}
+
+		record, err := s.Read(off)
+		if err == io.EOF {
+			// The offset is past this segment, or the rest of it was compacted away,
+			// keep looking in the next one
+			continue
 		}
+		return record, err
 	}
 
 	// If no segment found or offset is out of range
-	if s == nil || s.nextOffset <= off {
-		return nil, fmt.Errorf("offset out of range: %d", off)
+	return nil, fmt.Errorf("offset out of range: %d", off)
+}
+
+// OffsetForTime returns the offset of the first record appended at or after t.
+// If every record is older than t, it returns the offset the next record will get,
+// so a consumer starting there sees only records appended after t.
+func (l *Log) OffsetForTime(t time.Time) (uint64, error) {
+	ts := t.UnixNano()
+	if off, ok, err := l.remoteOffsetForTime(ts); ok || err != nil {
+		return off, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// Segments are ordered by offset and timestamps only move forward,
+	// so the first segment with a newer record holds the answer
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].maxTimestamp >= ts
+	})
+	if i < len(l.segments) {
+		return l.segments[i].OffsetForTime(ts)
 	}
 
-	return s.Read(off)
+	if len(l.segments) == 0 {
+		return 0, nil
+	}
+	return l.segments[len(l.segments)-1].nextOffset, nil
 }
 
 // Close closes all the segments in the log
 func (l *Log) Close() error {
+	l.stopBackground()
+
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+
+	// Commit the tail and release the appends waiting for an fsync
+	if l.Config.Segment.Sync != SyncOSManaged {
+		if err := l.sync(); err != nil {
+			return err
+		}
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	for _, segment := range l.segments {
@@ -136,13 +595,24 @@ func (l *Log) Close() error {
 			return err
 		}
 	}
-	return nil
+	return l.closeFetched()
 }
 
+// Remove closes the log and deletes its files, along with the segments it offloaded
 func (l *Log) Remove() error {
 	if err := l.Close(); err != nil {
 		return err
 	}
+
+	l.mu.Lock()
+	remote := l.remote
+	l.remote = nil
+	l.publish()
+	err := l.removeRemote(remote)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
 	return os.RemoveAll(l.Dir)
 }
 
@@ -151,13 +621,20 @@ func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
+	// Remove deleted the dir along with the segments
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
 	return l.setup()
 }
 
-// LowestOffset returns the lowest offset in the log
+// LowestOffset returns the lowest offset in the log, offloaded segments included
 func (l *Log) LowestOffset() (uint64, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
+	if len(l.remote) > 0 {
+		return l.remote[0].baseOffset, nil
+	}
 	if len(l.segments) == 0 {
 		return 0, nil
 	}
@@ -181,39 +658,101 @@ func (l *Log) HighestOffset() (uint64, error) {
 // Truncate(lowest uint64) removes all segments whose highest offset is lower than lowest.
 // Because we don’t have disks with infinite space,
 func (l *Log) Truncate(lowest uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	var segments []*segment
+	var remote, removedRemote []*segmentMeta
+	for _, r := range l.remote {
+		if r.nextOffset <= lowest+1 {
+			removedRemote = append(removedRemote, r)
+			continue
+		}
+		remote = append(remote, r)
+	}
+
+	var segments, removed []*segment
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
-			if err := s.Remove(); err != nil {
-				return err
-			}
+		// The active segment is always kept so the log has somewhere to append
+		if s != l.activeSegment && s.nextOffset <= lowest+1 {
+			removed = append(removed, s)
 			continue
 		}
 		segments = append(segments, s)
 	}
 
+	l.remote = remote
 	l.segments = segments
-	return nil
+	l.publish()
+	if err := l.removeSegments(removed); err != nil {
+		return err
+	}
+	return l.removeRemote(removedRemote)
+}
+
+// backgroundTask is a function the log runs periodically until it's closed.
+type backgroundTask struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startBackground runs fn every interval until the log is closed.
+func (l *Log) startBackground(interval time.Duration, fn func(now time.Time) error) {
+	task := &backgroundTask{stop: make(chan struct{}), done: make(chan struct{})}
+	l.background = append(l.background, task)
+
+	go func() {
+		defer close(task.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-task.stop:
+				return
+			case now := <-ticker.C:
+				// A failed pass is retried on the next tick
+				_ = fn(now)
+			}
+		}
+	}()
 }
 
-// originReader is a wrapper around store to implement io.Reader interface
+// stopBackground stops the background tasks and waits for them to exit.
+func (l *Log) stopBackground() {
+	for _, task := range l.background {
+		close(task.stop)
+		<-task.done
+	}
+	l.background = nil
+}
+
+// originReader is a wrapper around a segment's store to implement io.Reader interface
 // It keeps track of the current read offset within the store
 type originReader struct {
-	*store
+	*segment
 	off int64
 }
 
 // Reader returns an io.Reader to read the entire log.
 // It uses io.MultiReader to concatenate readers for each segment's store.
+// Encrypted stores are read decrypted, so the reader always holds plaintext frames.
 func (l *Log) Reader() io.Reader {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	readers := make([]io.Reader, len(l.segments))
-	for i, segment := range l.segments {
-		readers[i] = &originReader{segment.store, 0}
+	var readers []io.Reader
+	for _, r := range l.remote {
+		readers = append(readers, &remoteReader{store: l.Config.Tiering.Store, name: segmentFile("", r.baseOffset, ".store")})
+	}
+	for _, segment := range l.segments {
+		readers = append(readers, &originReader{segment, 0})
+	}
+
+	for i, r := range readers {
+		readers[i] = &lazyReader{open: func() (io.Reader, error) {
+			return decryptFrames(r, l.Config.Encryption.Keys)
+		}}
 	}
 	return io.MultiReader(readers...)
 }
@@ -221,7 +760,30 @@ func (l *Log) Reader() io.Reader {
 // ReadAt reads len(p) bytes from the store at the given offset.
 // Read implements the io.ReaderAt interface.
 func (o *originReader) Read(p []byte) (int, error) {
-	n, err := o.ReadAt(p, o.off)
+	// Pin the segment, so the LRU doesn't close it in the middle of the read
+	if err := o.acquire(); err != nil {
+		return 0, err
+	}
+	defer o.release()
+
+	n, err := o.store.ReadAt(p, o.off)
 	o.off += int64(n)
 	return n, err
 }
+
+// lazyReader opens the reader it reads from on the first Read.
+type lazyReader struct {
+	open func() (io.Reader, error)
+	r    io.Reader
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.r == nil {
+		r, err := l.open()
+		if err != nil {
+			return 0, err
+		}
+		l.r = r
+	}
+	return l.r.Read(p)
+}
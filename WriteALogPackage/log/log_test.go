@@ -1,12 +1,17 @@
 package log
 
 import (
+	"context"
+	"errors"
 	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
 	"io/ioutil"
 	"os"
+	"path"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestLog(t *testing.T) {
@@ -16,6 +21,12 @@ func TestLog(t *testing.T) {
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"recover torn tail":                 testRecoverTornTail,
+		"offset for time":                   testOffsetForTime,
+		"retention by size":                 testRetentionBytes,
+		"retention by age":                  testRetentionDuration,
+		"append batch":                      testAppendBatch,
+		"read while appending":              testReadWhileAppending,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := ioutil.TempDir("", "store-test")
@@ -104,7 +115,428 @@ func testReader(t *testing.T, log *Log) {
 	b, err := ioutil.ReadAll(reader)
 	require.NoError(t, err)
 	read := &api.Record{}
-	err = proto.Unmarshal(b[LenWidth:], read)
+	err = proto.Unmarshal(b[HeaderWidth:], read)
 	require.NoError(t, err)
 	require.Equal(t, append.Value, read.Value)
 }
+
+func testRecoverTornTail(t *testing.T, _ *Log) {
+	dir, err := ioutil.TempDir("", "recover-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// keep every record in the first segment
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	// simulate a crash: the last record only made it to disk partially,
+	// and the pre-allocated index was never shrunk back to its real size
+	storeFile := path.Join(log.Dir, "0.store")
+	indexFile := path.Join(log.Dir, "0.index")
+	fi, err := os.Stat(storeFile)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(storeFile, fi.Size()-3))
+	require.NoError(t, os.Truncate(indexFile, int64(log.Config.Segment.MaxIndexBytes)))
+
+	n, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+
+	off, err := n.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	_, err = n.Read(2)
+	require.Error(t, err)
+	read, err := n.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+
+	// the log keeps going from the last intact record
+	off, err = n.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	read, err = n.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+	require.NoError(t, n.Close())
+
+	// a first record that fails its checksum isn't a torn tail, the store is left alone
+	dir, err = ioutil.TempDir("", "recover-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	storeFile = path.Join(dir, "0.store")
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	_, err = log.Append(append)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	f, err := os.OpenFile(storeFile, os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{'X'}, int64(HeaderWidth))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	fi, err = os.Stat(storeFile)
+	require.NoError(t, err)
+
+	_, err = NewLog(dir, c)
+	require.True(t, errors.Is(err, ErrCorruptRecord))
+	after, err := os.Stat(storeFile)
+	require.NoError(t, err)
+	require.Equal(t, fi.Size(), after.Size())
+}
+
+func testOffsetForTime(t *testing.T, log *Log) {
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+
+	read, err := log.Read(2)
+	require.NoError(t, err)
+	middle := time.Unix(0, read.Timestamp)
+	require.False(t, middle.Before(start))
+
+	off, err := log.OffsetForTime(start)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	off, err = log.OffsetForTime(middle)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	// nothing appended yet after now, so consumers start at the end of the log
+	off, err = log.OffsetForTime(middle.Add(time.Nanosecond))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+
+	// the time index survives a restart
+	require.NoError(t, log.Close())
+	n, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	off, err = n.OffsetForTime(middle)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	require.NoError(t, n.Close())
+}
+
+func testRetentionBytes(t *testing.T, log *Log) {
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// every record fills a segment, so each sealed segment holds one record
+	size := log.segments[1].store.size
+	log.Config.Retention.Bytes = 2 * size
+
+	off, ok := log.RetentionOffset(time.Now())
+	require.True(t, ok)
+	require.Equal(t, uint64(1), off)
+
+	require.NoError(t, log.EnforceRetention(time.Now()))
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+	_, err = log.Read(1)
+	require.Error(t, err)
+
+	// nothing left to delete
+	_, ok = log.RetentionOffset(time.Now())
+	require.False(t, ok)
+}
+
+func testRetentionDuration(t *testing.T, log *Log) {
+	old := time.Now().Add(-time.Hour).UnixNano()
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world"), Timestamp: old + int64(i)})
+		require.NoError(t, err)
+	}
+	_, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+
+	log.Config.Retention.Duration = time.Minute
+	log.startBackground(time.Millisecond, log.EnforceRetention)
+
+	require.Eventually(t, func() bool {
+		off, err := log.LowestOffset()
+		return err == nil && off == 3
+	}, time.Second, 5*time.Millisecond)
+
+	read, err := log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), read.Value)
+	require.NoError(t, log.Close())
+}
+
+func testAppendBatch(t *testing.T, log *Log) {
+	_, err := log.Append(&api.Record{Value: []byte("single")})
+	require.NoError(t, err)
+
+	batch := []*api.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second")},
+		{Value: []byte("third")},
+	}
+	off, err := log.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+
+	// the batch overflows MaxStoreBytes but stays in one segment
+	require.Equal(t, uint64(1), log.segments[1].baseOffset)
+	require.Equal(t, uint64(4), log.segments[1].nextOffset)
+	require.Equal(t, uint64(4), log.activeSegment.baseOffset)
+
+	for i, want := range batch {
+		read, err := log.Read(off + uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, want.Value, read.Value)
+		require.Equal(t, batch[0].Timestamp, read.Timestamp)
+	}
+
+	_, err = log.AppendBatch(nil)
+	require.Equal(t, ErrEmptyBatch, err)
+
+	// more records than an index holds are rejected without writing any of them
+	large := make([]*api.Record, log.Config.Segment.MaxIndexBytes/entWidth+1)
+	for i := range large {
+		large[i] = &api.Record{Value: []byte("large")}
+	}
+	_, err = log.AppendBatch(large)
+	require.Equal(t, ErrBatchTooLarge, err)
+
+	off, err = log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func testReadWhileAppending(t *testing.T, log *Log) {
+	const records = 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < records; i++ {
+			_, err := log.Append(&api.Record{Value: []byte("hello world")})
+			require.NoError(t, err)
+		}
+	}()
+
+	// consumers follow the producer at the tail, every record they get is whole
+	var wg sync.WaitGroup
+	for c := 0; c < 4; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for off := uint64(0); off < records; {
+				read, err := log.Read(off)
+				if err != nil {
+					continue
+				}
+				require.Equal(t, off, read.Offset)
+				require.Equal(t, []byte("hello world"), read.Value)
+				off++
+			}
+		}()
+	}
+
+	<-done
+	wg.Wait()
+}
+
+// The Read benchmarks run their consumers in parallel, compare them with -cpu 1,2,4,8.
+
+func BenchmarkLogRead(b *testing.B) {
+	log, records := newBenchLog(b)
+	defer log.Remove()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var off uint64
+		for pb.Next() {
+			if _, err := log.Read(off); err != nil {
+				b.Fatal(err)
+			}
+			off = (off + 1) % records
+		}
+	})
+}
+
+// BenchmarkLogReadWhileAppending reads the newest records while a producer keeps appending.
+func BenchmarkLogReadWhileAppending(b *testing.B) {
+	log, _ := newBenchLog(b)
+	defer log.Remove()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := log.Append(&api.Record{Value: []byte("hello world")}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			off, err := log.HighestOffset()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err = log.Read(off); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.StopTimer()
+
+	close(stop)
+	<-done
+}
+
+// newBenchLog returns a log with many segments of records, and the number of records.
+func newBenchLog(b *testing.B) (*Log, uint64) {
+	dir, err := ioutil.TempDir("", "log-bench")
+	require.NoError(b, err)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 4096
+	c.Segment.MaxIndexBytes = 4096
+	log, err := NewLog(dir, c)
+	require.NoError(b, err)
+
+	const records = 10000
+	for i := 0; i < records; i++ {
+		_, err = log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(b, err)
+	}
+	return log, records
+}
+
+func TestLogRollByAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roll-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxAge = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	_, err = log.Append(&api.Record{Value: []byte("old"), Timestamp: old})
+	require.NoError(t, err)
+	require.Len(t, log.segments, 1)
+
+	// the next append finds the segment too old and starts a new one first
+	_, err = log.Append(&api.Record{Value: []byte("new")})
+	require.NoError(t, err)
+	require.Len(t, log.segments, 2)
+	require.Equal(t, uint64(1), log.activeSegment.baseOffset)
+
+	// an idle segment is rolled without an append
+	require.NoError(t, log.Roll(time.Now()))
+	require.Len(t, log.segments, 2)
+	require.NoError(t, log.Roll(time.Now().Add(2*time.Hour)))
+	require.Len(t, log.segments, 3)
+	require.Equal(t, uint64(2), log.activeSegment.baseOffset)
+
+	// an empty segment is never rolled
+	require.NoError(t, log.Roll(time.Now().Add(4*time.Hour)))
+	require.Len(t, log.segments, 3)
+
+	read, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), read.Value)
+}
+
+func TestLogRollSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roll-segment-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the log doesn't roll on its own, the caller decides with RollOffset
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, ok := log.RollOffset(time.Now(), time.Hour)
+	require.False(t, ok)
+
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	_, err = log.Append(&api.Record{Value: []byte("old"), Timestamp: old})
+	require.NoError(t, err)
+	_, ok = log.RollOffset(time.Now(), 0)
+	require.False(t, ok)
+	base, ok := log.RollOffset(time.Now(), time.Hour)
+	require.True(t, ok)
+	require.Equal(t, uint64(0), base)
+
+	require.NoError(t, log.RollSegment(base))
+	require.Len(t, log.segments, 2)
+	require.Equal(t, uint64(1), log.activeSegment.baseOffset)
+
+	// the same roll again, or a roll of an empty segment, does nothing
+	require.NoError(t, log.RollSegment(base))
+	require.NoError(t, log.RollSegment(1))
+	require.Len(t, log.segments, 2)
+}
+
+func TestLogWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wait-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+
+	// an offset the log already holds doesn't block
+	require.NoError(t, log.Wait(context.Background(), 0))
+
+	// the next offset blocks until it's appended
+	waited := make(chan error)
+	go func() {
+		waited <- log.Wait(context.Background(), 1)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait returned before the record was appended")
+	case <-time.After(10 * time.Millisecond):
+	}
+	_, err = log.Append(&api.Record{Value: []byte("second")})
+	require.NoError(t, err)
+	require.NoError(t, <-waited)
+	read, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), read.Value)
+
+	// or until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, log.Wait(ctx, 2))
+}
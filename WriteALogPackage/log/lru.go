@@ -0,0 +1,82 @@
+package log
+
+import "sync"
+
+/*
+	* Every open segment holds three file descriptors and maps its store and both indexes, the
+	  indexes at MaxIndexBytes. A log with thousands of segments runs out of descriptors or
+	  address space long before it runs out of disk.
+	* With Segment.MaxOpenSegments set, the log keeps at most that many sealed segments open and
+	  closes the ones read least recently. The next read of a closed segment reopens it, with its
+	  indexes mapped read-only at their size rather than MaxIndexBytes.
+	* The active segment is always open and doesn't count against the limit. Reads pin the segment
+	  they read, so a segment closed under a read keeps its files until the read is done.
+*/
+
+// segmentLRU limits the sealed segments of a log that are open at once. A nil segmentLRU has no limit.
+type segmentLRU struct {
+	max  int
+	mu   sync.Mutex
+	open map[*segment]struct{}
+}
+
+// newSegmentLRU returns an LRU keeping at most max sealed segments open, or nil if max is 0.
+func newSegmentLRU(max int) *segmentLRU {
+	if max <= 0 {
+		return nil
+	}
+	return &segmentLRU{max: max, open: make(map[*segment]struct{})}
+}
+
+// add records that the sealed segment s is open and closes the least recently read segments
+// beyond the limit.
+func (c *segmentLRU) add(s *segment) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.open[s] = struct{}{}
+	var cold []*segment
+	for len(c.open) > c.max {
+		var victim *segment
+		for o := range c.open {
+			if o != s && (victim == nil || o.lastUsed.Load() < victim.lastUsed.Load()) {
+				victim = o
+			}
+		}
+		delete(c.open, victim)
+		cold = append(cold, victim)
+	}
+	c.mu.Unlock()
+
+	// Close takes a segment's lock and then the LRU's, so segments are evicted after letting go of it
+	for _, victim := range cold {
+		if err := victim.evict(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove stops tracking a segment that's closed for good.
+func (c *segmentLRU) remove(s *segment) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.open, s)
+	c.mu.Unlock()
+}
+
+// count returns the number of sealed segments that are open.
+func (c *segmentLRU) count() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.open)
+}
@@ -0,0 +1,68 @@
+package log
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxOpenSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lru-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Segment.MaxOpenSegments = 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		_, err = log.Append(&api.Record{Value: write})
+		require.NoError(t, err)
+	}
+	// ten sealed segments of two records each, and an empty active segment
+	require.Len(t, log.segments, 11)
+	require.Equal(t, 2, log.lru.count())
+	require.False(t, log.segments[0].hasFiles)
+
+	// concurrent reads reopen the cold segments, their indexes mapped at their size
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := uint64(0); i < 20; i++ {
+				read, err := log.Read(i)
+				require.NoError(t, err)
+				require.Equal(t, i, read.Offset)
+				require.Equal(t, write, read.Value)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 2, log.lru.count())
+
+	_, err = log.Read(0)
+	require.NoError(t, err)
+	require.True(t, log.segments[0].hasFiles)
+	require.Equal(t, int(2*entWidth), len(log.segments[0].index.mmap))
+
+	// a restarted log opens no more sealed segments than the limit
+	require.NoError(t, log.Close())
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Equal(t, 2, log.lru.count())
+
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(19), off)
+	read, err := log.Read(3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), read.Offset)
+	require.NoError(t, log.Close())
+}
@@ -0,0 +1,343 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+	* The manifest lists the segments of the log, their states and the version of the on-disk
+	  format they're written in. setup opens the segments it lists instead of guessing them from
+	  the file names, so stray files in the directory are ignored.
+	* It's replaced atomically: the new manifest is written and synced to a temporary file that is
+	  then renamed over the old one.
+	* A segment is marked deleting before its files are removed, so a crash in between doesn't
+	  bring it back; setup finishes removing it.
+	* A directory without a manifest was written before it existed, in format version 0, and is
+	  migrated in place on open. Version 0 frames a record in the store as [8-byte length][record],
+	  without a checksum or attributes, so its segments are rewritten into CRC frames. A future
+	  format change bumps FormatVersion and appends the migration from the previous version to
+	  migrations.
+*/
+
+const (
+	manifestFile = "MANIFEST"
+
+	// FormatVersion is the version of the on-disk format the log writes.
+	FormatVersion = 2
+)
+
+// ErrUnsupportedFormat is returned when the log dir was written by a newer version of the log.
+var ErrUnsupportedFormat = errors.New("unsupported on-disk format version")
+
+// SegmentState is the state of a segment in the manifest.
+type SegmentState string
+
+const (
+	// SegmentActive is the segment being appended to, always the last one.
+	SegmentActive SegmentState = "active"
+	// SegmentSealed is a full segment that is only read from.
+	SegmentSealed SegmentState = "sealed"
+	// SegmentDeleting is a segment whose files are being removed.
+	SegmentDeleting SegmentState = "deleting"
+	// SegmentRemote is a sealed segment offloaded to the object store, see tiered.go.
+	SegmentRemote SegmentState = "remote"
+)
+
+type manifest struct {
+	Version  int               `json:"version"`
+	Segments []manifestSegment `json:"segments"`
+}
+
+type manifestSegment struct {
+	BaseOffset uint64       `json:"base_offset"`
+	State      SegmentState `json:"state"`
+
+	// What the log needs to know about a remote segment without fetching it
+	NextOffset   uint64 `json:"next_offset,omitempty"`
+	StoreBytes   uint64 `json:"store_bytes,omitempty"`
+	MaxTimestamp int64  `json:"max_timestamp,omitempty"`
+}
+
+// migrations[v] moves a log dir from format version v to v+1.
+var migrations = []func(dir string, m *manifest, c Config) error{
+	migrateToManifest,
+	migrateToRemoteSegments,
+}
+
+// openManifest reads the manifest of dir and migrates the dir to the current format if needed.
+// Segments rewritten by a migration are written with c.
+func openManifest(dir string, c Config) (*manifest, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Version > FormatVersion {
+		return nil, fmt.Errorf("%w: %d, this log supports up to %d", ErrUnsupportedFormat, m.Version, FormatVersion)
+	}
+	if m.Version == FormatVersion {
+		return m, nil
+	}
+
+	for ; m.Version < FormatVersion; m.Version++ {
+		if err = migrations[m.Version](dir, m, c); err != nil {
+			return nil, fmt.Errorf("migrating log format from version %d: %w", m.Version, err)
+		}
+	}
+	return m, m.write(dir)
+}
+
+// DirFormatVersion returns the version of the on-disk format dir is written in, without migrating
+// it. A dir without a manifest is at version 0.
+func DirFormatVersion(dir string) (int, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return 0, err
+	}
+	return m.Version, nil
+}
+
+// readManifest reads the manifest of dir, or returns an empty one with version 0 if there is none.
+func readManifest(dir string) (*manifest, error) {
+	b, err := ioutil.ReadFile(path.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return &manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", manifestFile, err)
+	}
+	return m, nil
+}
+
+// write replaces the manifest of dir with m.
+func (m *manifest) write(dir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path.Join(dir, manifestFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, path.Join(dir, manifestFile)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// migrateToManifest lists the segments of a dir written before the manifest existed, going by
+// the .store files in it, and rewrites them in CRC frames. The rewrites are built in compactDir
+// along with a manifest of version 1, and moved in through swapDir like a compaction: a crash
+// before the rename leaves the dir at version 0 to migrate again, one after it has setup finish the swap.
+func migrateToManifest(dir string, m *manifest, c Config) error {
+	baseOffsets, err := segmentBaseOffsets(dir)
+	if err != nil {
+		return err
+	}
+
+	m.Segments = nil
+	for i, base := range baseOffsets {
+		state := SegmentSealed
+		if i == len(baseOffsets)-1 {
+			state = SegmentActive
+		}
+		m.Segments = append(m.Segments, manifestSegment{BaseOffset: base, State: state})
+	}
+	if len(baseOffsets) == 0 {
+		return nil
+	}
+
+	rewriting := path.Join(dir, compactDir)
+	if err = os.RemoveAll(rewriting); err != nil {
+		return err
+	}
+	if err = os.MkdirAll(rewriting, 0755); err != nil {
+		return err
+	}
+
+	for _, base := range baseOffsets {
+		records, err := readSegmentV0(dir, base)
+		if err != nil {
+			return err
+		}
+		if err = writeSegment(rewriting, base, c, records); err != nil {
+			return err
+		}
+	}
+	if err = (&manifest{Version: 1, Segments: m.Segments}).write(rewriting); err != nil {
+		return err
+	}
+
+	if err = os.Rename(rewriting, path.Join(dir, swapDir)); err != nil {
+		return err
+	}
+	if err = syncDir(dir); err != nil {
+		return err
+	}
+	return completeSwap(dir)
+}
+
+// readSegmentV0 returns the records of a segment in format version 0. The index says which of
+// them were appended: it's pre-allocated while the segment is open, so a crash can leave a
+// zero-filled tail, or a record in the store that was never indexed.
+func readSegmentV0(dir string, base uint64) ([]*api.Record, error) {
+	store, err := ioutil.ReadFile(segmentFile(dir, base, ".store"))
+	if err != nil {
+		return nil, err
+	}
+	index, err := ioutil.ReadFile(segmentFile(dir, base, ".index"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*api.Record
+	for n := uint64(0); (n+1)*entWidth <= uint64(len(index)); n++ {
+		entry := index[n*entWidth : (n+1)*entWidth]
+		off, pos := enc.Uint32(entry[:offWidth]), enc.Uint64(entry[offWidth:])
+		if uint64(off) != n || pos+LenWidth > uint64(len(store)) {
+			break
+		}
+
+		size := enc.Uint64(store[pos : pos+LenWidth])
+		if size > uint64(len(store))-pos-LenWidth {
+			break
+		}
+
+		record := &api.Record{}
+		if err = proto.Unmarshal(store[pos+LenWidth:pos+LenWidth+size], record); err != nil {
+			return nil, fmt.Errorf("segment %d, offset %d: %w", base, base+n, err)
+		}
+		record.Offset = base + n
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// writeSegment writes the records, which carry their offsets, to a new segment in dir, and syncs and closes it.
+func writeSegment(dir string, base uint64, c Config, records []*api.Record) error {
+	s, err := newSegment(dir, base, c)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err = s.write(record); err != nil {
+			_ = s.Close()
+			return err
+		}
+	}
+	if err = s.Sync(); err != nil {
+		_ = s.Close()
+		return err
+	}
+	return s.Close()
+}
+
+// migrateToRemoteSegments moves a dir to the version that can have remote segments. The local
+// segments don't change; the version only keeps older logs from opening a dir they can't read.
+func migrateToRemoteSegments(string, *manifest, Config) error {
+	return nil
+}
+
+// manifestBaseOffsets returns the base offsets of the live local segments of dir in ascending order.
+// A dir without a manifest is listed by its .store files.
+func manifestBaseOffsets(dir string) ([]uint64, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m.Version == 0 {
+		return segmentBaseOffsets(dir)
+	}
+
+	var baseOffsets []uint64
+	for _, s := range m.Segments {
+		if s.State != SegmentDeleting && s.State != SegmentRemote {
+			baseOffsets = append(baseOffsets, s.BaseOffset)
+		}
+	}
+	return baseOffsets, nil
+}
+
+// writeManifest records the segments of the log in its manifest, with the given segments
+// marked as deleting. The caller must hold mu.
+func (l *Log) writeManifest(deleting ...*segment) error {
+	m := &manifest{Version: FormatVersion}
+	for _, r := range l.remote {
+		m.Segments = append(m.Segments, manifestSegment{
+			BaseOffset:   r.baseOffset,
+			State:        SegmentRemote,
+			NextOffset:   r.nextOffset,
+			StoreBytes:   r.storeBytes,
+			MaxTimestamp: r.maxTimestamp,
+		})
+	}
+	for _, s := range deleting {
+		m.Segments = append(m.Segments, manifestSegment{BaseOffset: s.baseOffset, State: SegmentDeleting})
+	}
+	for _, s := range l.segments {
+		state := SegmentSealed
+		if s == l.activeSegment {
+			state = SegmentActive
+		}
+		m.Segments = append(m.Segments, manifestSegment{BaseOffset: s.baseOffset, State: state})
+	}
+	return m.write(l.Dir)
+}
+
+// removeSegments marks the segments as deleting in the manifest, removes their files and
+// then drops them from the manifest. l.segments must no longer hold them. The caller must hold mu.
+func (l *Log) removeSegments(segments []*segment) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	if err := l.writeManifest(segments...); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if err := s.Remove(); err != nil {
+			return err
+		}
+	}
+	return l.writeManifest()
+}
+
+// removeSegmentFiles removes whatever files are left of the segment with the given base offset.
+func removeSegmentFiles(dir string, base uint64) error {
+	for _, ext := range segmentExts {
+		if err := os.Remove(segmentFile(dir, base, ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
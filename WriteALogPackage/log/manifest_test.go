@@ -0,0 +1,155 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err = log.Append(&api.Record{Value: write})
+		require.NoError(t, err)
+	}
+
+	m, err := readManifest(dir)
+	require.NoError(t, err)
+	require.Equal(t, FormatVersion, m.Version)
+	require.Equal(t, []manifestSegment{
+		{BaseOffset: 0, State: SegmentSealed},
+		{BaseOffset: 2, State: SegmentSealed},
+		{BaseOffset: 4, State: SegmentActive},
+	}, m.Segments)
+
+	require.NoError(t, log.Truncate(1))
+	m, err = readManifest(dir)
+	require.NoError(t, err)
+	require.Equal(t, []manifestSegment{
+		{BaseOffset: 2, State: SegmentSealed},
+		{BaseOffset: 4, State: SegmentActive},
+	}, m.Segments)
+	require.NoError(t, log.Close())
+
+	// stray files don't turn into segments
+	for _, name := range []string{"9.store", "7.index", "notes.txt"} {
+		require.NoError(t, ioutil.WriteFile(path.Join(dir, name), []byte("stray"), 0644))
+	}
+
+	// a crash after marking a segment deleting but before removing all its files
+	m.Segments[0].State = SegmentDeleting
+	require.NoError(t, m.write(dir))
+	require.NoError(t, os.Remove(segmentFile(dir, 2, ".index")))
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	require.Len(t, log.segments, 1)
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), lowest)
+	for _, ext := range []string{".store", ".timeindex"} {
+		_, err = os.Stat(segmentFile(dir, 2, ext))
+		require.True(t, os.IsNotExist(err))
+	}
+	require.NoError(t, log.Close())
+
+	// a segment the manifest lists must still have its store
+	require.NoError(t, os.Remove(segmentFile(dir, 4, ".store")))
+	_, err = NewLog(dir, c)
+	require.Error(t, err)
+}
+
+func TestManifestMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest-migration-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a log written before the manifest existed, with [length][record] frames and no checksums:
+	// a sealed segment, and an active one that crashed with its index pre-allocated and a record
+	// written to the store but not indexed
+	writeSegmentV0(t, dir, 0, 2, 0, false)
+	writeSegmentV0(t, dir, 2, 2, entWidth*2, true)
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 4
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), highest)
+	for off := uint64(0); off < 4; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, off, read.Offset)
+		require.Equal(t, []byte(fmt.Sprintf("record %d", off)), read.Value)
+	}
+
+	// the migrated segments are appended to like any other
+	off, err := log.Append(&api.Record{Value: []byte("record 4")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+	require.NoError(t, log.Close())
+	_, err = os.Stat(path.Join(dir, swapDir))
+	require.True(t, os.IsNotExist(err))
+
+	m, err := readManifest(dir)
+	require.NoError(t, err)
+	require.Equal(t, FormatVersion, m.Version)
+	require.Equal(t, []manifestSegment{
+		{BaseOffset: 0, State: SegmentSealed},
+		{BaseOffset: 2, State: SegmentActive},
+	}, m.Segments)
+
+	log, err = NewLog(dir, c)
+	require.NoError(t, err)
+	log = requireRecords(t, log, 0, 4)
+	require.NoError(t, log.Close())
+
+	// a log written by a newer version isn't touched
+	m.Version = FormatVersion + 1
+	require.NoError(t, m.write(dir))
+	_, err = NewLog(dir, c)
+	require.True(t, errors.Is(err, ErrUnsupportedFormat))
+}
+
+// writeSegmentV0 writes a segment of n records in format version 0, with its index padded with
+// zeros to padding bytes and, if orphan is set, a last record in the store that isn't indexed.
+func writeSegmentV0(t *testing.T, dir string, base uint64, n int, padding uint64, orphan bool) {
+	t.Helper()
+	var store, index []byte
+	frame := func(off uint64) {
+		p, err := proto.Marshal(&api.Record{Value: []byte(fmt.Sprintf("record %d", off)), Offset: off})
+		require.NoError(t, err)
+		store = enc.AppendUint64(store, uint64(len(p)))
+		store = append(store, p...)
+	}
+	for i := 0; i < n; i++ {
+		index = enc.AppendUint32(index, uint32(i))
+		index = enc.AppendUint64(index, uint64(len(store)))
+		frame(base + uint64(i))
+	}
+	if orphan {
+		frame(base + uint64(n))
+	}
+	for uint64(len(index)) < padding {
+		index = append(index, 0)
+	}
+
+	require.NoError(t, ioutil.WriteFile(segmentFile(dir, base, ".store"), store, 0644))
+	require.NoError(t, ioutil.WriteFile(segmentFile(dir, base, ".index"), index, 0644))
+}
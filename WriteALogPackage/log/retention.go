@@ -0,0 +1,66 @@
+package log
+
+import "time"
+
+// RetentionOffset returns the highest offset the retention policy allows to delete at the given time.
+// Only whole sealed segments at the front of the log qualify, and the active segment is always kept.
+// It returns false if there is nothing to delete.
+func (l *Log) RetentionOffset(now time.Time) (uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	policy := l.Config.Retention
+	if policy.Bytes == 0 && policy.Duration == 0 {
+		return 0, false
+	}
+
+	// Offloaded segments count too, and are older than the local ones
+	var segments []segmentMeta
+	for _, r := range l.remote {
+		segments = append(segments, *r)
+	}
+	for _, s := range l.segments {
+		segments = append(segments, s.meta())
+	}
+
+	var total uint64
+	for _, s := range segments {
+		total += s.storeBytes
+	}
+
+	var cut *segmentMeta
+	for i := range segments {
+		s := &segments[i]
+		// The active segment is always the last one
+		if i == len(segments)-1 {
+			break
+		}
+		if s.nextOffset == s.baseOffset {
+			continue
+		}
+
+		oversized := policy.Bytes > 0 && total > policy.Bytes
+		// Segments without a time index (written before timestamps existed) never expire by age
+		expired := policy.Duration > 0 && s.maxTimestamp > 0 && s.maxTimestamp < now.Add(-policy.Duration).UnixNano()
+		if !oversized && !expired {
+			break
+		}
+
+		total -= s.storeBytes
+		cut = s
+	}
+
+	if cut == nil {
+		return 0, false
+	}
+	return cut.nextOffset - 1, true
+}
+
+// EnforceRetention deletes the segments the retention policy no longer allows to keep.
+func (l *Log) EnforceRetention(now time.Time) error {
+	off, ok := l.RetentionOffset(now)
+	if !ok {
+		return nil
+	}
+	return l.Truncate(off)
+}
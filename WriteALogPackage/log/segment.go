@@ -1,19 +1,57 @@
 package log
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"path"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
 	"google.golang.org/protobuf/proto"
 )
 
+// errSegmentFull is returned when a batch doesn't fit in the remaining space of a segment.
+var errSegmentFull = errors.New("segment full")
+
+// segmentState is where a segment is in its life, see segment.acquire.
+type segmentState uint8
+
+const (
+	segmentOpen   segmentState = iota // the files are open
+	segmentCold                       // the LRU closed the segment, a read reopens it
+	segmentClosed                     // closed for good
+)
+
 type segment struct {
 	store                  *store
 	index                  *index
+	timeIndex              *timeIndex
 	baseOffset, nextOffset uint64
+	maxTimestamp           int64 // newest timestamp in the time index
 	config                 Config
+
+	// A sealed segment's files are closed while it's cold and reopened by the next read, see lru.go.
+	// refs pins the open files: one for the segment while it's open, plus one per reader.
+	mu       sync.Mutex // guards state and hasFiles, and opening and closing the files
+	state    segmentState
+	hasFiles bool
+	refs     atomic.Int64
+	lastUsed atomic.Int64 // unix nanoseconds of the last read
+	lru      *segmentLRU  // tracks the segment once the log sealed it (nil = no limit)
+
+	// The number of index entries readers may see. The writer publishes it once a write is
+	// indexed, so reads don't need the log's lock, see Log.Read.
+	published atomic.Uint64
+
+	// The last batch read, so sequential reads don't decode it for every record
+	cache struct {
+		sync.Mutex
+		pos         uint64
+		compression Compression
+		records     []*api.Record
+	}
 }
 
 // newSegment creates a new segment with the given base offset and configuration.
@@ -27,7 +65,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 
 	// Open the store file in read-write and append mode, creating it if it doesn't exist
 	storeFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
+		segmentFile(dir, baseOffset, ".store"),
 		os.O_RDWR|os.O_CREATE|os.O_APPEND,
 		0644,
 	)
@@ -36,7 +74,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 
-	if s.store, err = newStore(storeFile); err != nil {
+	if s.store, err = openStore(storeFile, c.Encryption.Keys); err != nil {
 		return nil, err
 	}
 
@@ -44,7 +82,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 
 	// Open the index file in read-write and append mode, creating it if it doesn't exist
 	indexFile, err := os.OpenFile(
-		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
+		segmentFile(dir, baseOffset, ".index"),
 		os.O_RDWR|os.O_CREATE,
 		0644,
 	)
@@ -57,6 +95,26 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		return nil, err
 	}
 
+	// ---------- Initialize the time index ----------
+
+	timeIndexFile, err := os.OpenFile(
+		segmentFile(dir, baseOffset, ".timeindex"),
+		os.O_RDWR|os.O_CREATE,
+		0644,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.timeIndex, err = newTimeIndex(timeIndexFile, c); err != nil {
+		return nil, err
+	}
+
+	if ts, _, err := s.timeIndex.Read(-1); err == nil {
+		s.maxTimestamp = ts
+	}
+
 	// ---------- Set the next offset ----------
 	// Read the last entry in the index to determine the next offset
 	// If the index is empty, start at the base offset
@@ -67,58 +125,494 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		s.nextOffset = baseOffset + uint64(off) + 1
 	}
 
+	if err = s.publish(); err != nil {
+		return nil, err
+	}
+
+	s.hasFiles = true
+	s.refs.Store(1)
+	s.lastUsed.Store(time.Now().UnixNano())
 	return s, nil
 }
 
+// acquire pins the segment's files for a read, reopening them if the segment is cold.
+// It returns os.ErrClosed if the segment was closed for good. Every acquire needs a release.
+func (s *segment) acquire() error {
+	for {
+		if refs := s.refs.Load(); refs > 0 {
+			if s.refs.CompareAndSwap(refs, refs+1) {
+				s.lastUsed.Store(time.Now().UnixNano())
+				return nil
+			}
+			continue
+		}
+		if err := s.reopen(); err != nil {
+			return err
+		}
+	}
+}
+
+// release unpins the segment's files, closing them if the segment was evicted or closed during the read.
+func (s *segment) release() {
+	if s.refs.Add(-1) > 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs.Load() == 0 && s.hasFiles {
+		// Nothing is waiting for the files to close, so there's no one to report a failure to
+		_ = s.closeFiles()
+	}
+}
+
+// reopen opens a cold segment's files again and reports it to the LRU.
+func (s *segment) reopen() error {
+	s.mu.Lock()
+	switch s.state {
+	case segmentClosed:
+		s.mu.Unlock()
+		return os.ErrClosed
+	case segmentOpen:
+		// Another read reopened it first
+		s.mu.Unlock()
+		return nil
+	}
+
+	if !s.hasFiles {
+		if err := s.openFiles(); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	s.state = segmentOpen
+	s.refs.Add(1)
+	s.lastUsed.Store(time.Now().UnixNano())
+	s.mu.Unlock()
+
+	return s.lru.add(s)
+}
+
+// evict closes the files of a sealed segment until it's read again. Reads in progress keep them
+// open until they're done.
+func (s *segment) evict() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != segmentOpen {
+		return nil
+	}
+	s.state = segmentCold
+	if s.refs.Add(-1) == 0 {
+		return s.closeFiles()
+	}
+	return nil
+}
+
+// openFiles reopens the files of a sealed segment. The caller must hold mu.
+func (s *segment) openFiles() error {
+	if err := s.store.reopen(); err != nil {
+		return err
+	}
+	if err := s.index.reopen(); err != nil {
+		return err
+	}
+	if err := s.timeIndex.reopen(); err != nil {
+		return err
+	}
+	s.hasFiles = true
+	return nil
+}
+
+// closeFiles closes the files of the segment. The caller must hold mu.
+func (s *segment) closeFiles() error {
+	s.hasFiles = false
+	if err := s.index.Close(); err != nil {
+		return err
+	}
+	if err := s.timeIndex.Close(); err != nil {
+		return err
+	}
+	return s.store.Close()
+}
+
+// publish flushes the store and makes the records indexed so far visible to readers.
+func (s *segment) publish() error {
+	if err := s.store.Flush(); err != nil {
+		return err
+	}
+	s.published.Store(s.index.size / entWidth)
+	return nil
+}
+
 // Append adds a new record to the segment and returns its offset.
 func (s *segment) Append(record *api.Record) (offset uint64, err error) {
-	cur := s.nextOffset
-	record.Offset = cur
+	record.Offset = s.nextOffset
+	if err = s.write(record); err != nil {
+		return 0, err
+	}
+	return record.Offset, nil
+}
 
+// write adds a record to the segment at the offset it already carries, which must not be lower than nextOffset.
+// Compaction uses it to copy the surviving records of a segment while leaving gaps for the dropped ones.
+func (s *segment) write(record *api.Record) error {
 	p, err := proto.Marshal(record)
 	if err != nil {
-		return 0, nil
+		return err
 	}
 
 	_, pos, err := s.store.Append(p)
 	if err != nil {
-		return 0, nil
+		return err
+	}
+
+	if err = s.indexRecord(record, pos); err != nil {
+		return err
+	}
+
+	s.nextOffset = record.Offset + 1
+	return s.publish()
+}
+
+// AppendBatch adds the records to the segment with contiguous offsets and returns the first one.
+// The whole batch is written as a single store frame, compressed with c.
+// It returns errSegmentFull without writing anything if the batch doesn't fit in a segment that
+// already has records, so the log can roll to a new segment first.
+func (s *segment) AppendBatch(records []*api.Record, c Compression) (uint64, error) {
+	for i, record := range records {
+		record.Offset = s.nextOffset + uint64(i)
+	}
+
+	attrs, frame, err := encodeBatch(records, c)
+	if err != nil {
+		return 0, err
+	}
+
+	// The index can't grow, so a batch that overflows it can never be written to this segment.
+	// The store limit is soft, but a batch is kept whole rather than spread over two segments.
+	if s.index.size+uint64(len(records))*entWidth > uint64(len(s.index.mmap)) ||
+		(s.index.size > 0 && s.store.size+HeaderWidth+uint64(len(frame)) > s.config.Segment.MaxStoreBytes) {
+		return 0, errSegmentFull
+	}
+
+	return records[0].Offset, s.writeFrame(records, attrs, frame)
+}
+
+// writeBatch adds records that already carry their offsets, like write, compressed together with c.
+func (s *segment) writeBatch(records []*api.Record, c Compression) error {
+	attrs, frame, err := encodeBatch(records, c)
+	if err != nil {
+		return err
+	}
+	return s.writeFrame(records, attrs, frame)
+}
+
+// writeFrame writes the frame encodeBatch made of the records and indexes the records.
+// Either every record is written or, if anything fails, the segment is rolled back and none is.
+func (s *segment) writeFrame(records []*api.Record, attrs byte, frame []byte) error {
+	entries, timeEntries := s.index.size/entWidth, s.timeIndex.size/timeEntWidth
+	storeSize, maxTimestamp := s.store.size, s.maxTimestamp
+
+	positions, err := s.store.AppendBatch([][]byte{frame}, attrs)
+	if err != nil {
+		return err
+	}
+
+	// The records of a batch share its frame
+	for _, record := range records {
+		if err = s.indexRecord(record, positions[0]); err != nil {
+			// Nothing reads past nextOffset, but the files must not keep a partial batch either
+			_ = s.index.Truncate(entries)
+			_ = s.timeIndex.Truncate(timeEntries)
+			_ = s.store.Truncate(storeSize)
+			s.maxTimestamp = maxTimestamp
+			return err
+		}
 	}
 
-	if err = s.index.Write(
+	s.nextOffset = records[len(records)-1].Offset + 1
+	return s.publish()
+}
+
+// indexRecord adds the index and time index entries of a record written to the store at pos.
+func (s *segment) indexRecord(record *api.Record, pos uint64) error {
+	if err := s.index.Write(
 		// index offsets are relative to base offset: <baseOffset + indexOffset> = absoluteOffset
-		// So, to get the indexOffset, we subtract baseOffset from nextOffset
+		// So, to get the indexOffset, we subtract baseOffset from the record's offset
 		/*
 			           - baseOffset: the starting offset of the segment
-					   - nextOffset: the next available offset in the segment
-					   - (nextOffset - baseOffset): gives the relative offset within the segment
+					   - record.Offset: the offset of the record being written (nextOffset, unless compacting)
+					   - (record.Offset - baseOffset): gives the relative offset within the segment
 		*/
-		uint32(s.nextOffset-uint64(s.baseOffset)),
+		uint32(record.Offset-s.baseOffset),
 		pos,
 	); err != nil {
-		return 0, err
+		return err
 	}
 
-	s.nextOffset++
-	return cur, nil
+	// Only index timestamps that move forward, so the time index stays sorted
+	if record.Timestamp > s.maxTimestamp {
+		if err := s.timeIndex.Write(record.Timestamp, uint32(record.Offset-s.baseOffset)); err != nil {
+			return err
+		}
+		s.maxTimestamp = record.Timestamp
+	}
+	return nil
 }
 
-// Read retrieves a record by its offset from the segment.
+// Read retrieves the record at the given offset from the segment. If that record was
+// compacted away, it returns the next record in the segment instead, so callers should
+// use the returned record's offset. It returns io.EOF if no published record is left at or after off.
+// It's safe to call while the segment is appended to.
 func (s *segment) Read(off uint64) (*api.Record, error) {
-	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	rel, pos, err := s.index.find(uint32(off-s.baseOffset), s.published.Load())
 	if err != nil {
 		return nil, err
 	}
 
-	p, err := s.store.Read(pos)
+	c, records, err := s.readFrame(pos)
 	if err != nil {
 		return nil, err
 	}
 
-	record := &api.Record{}
-	//  Deserializes the raw bytes (p) back into the structured record:
-	err = proto.Unmarshal(p, record)
-	return record, err
+	off = s.baseOffset + uint64(rel)
+	for _, record := range records {
+		if record.Offset != off {
+			continue
+		}
+		// Records of a batch are cached and shared between readers
+		if c != CompressionNone || len(records) > 1 {
+			record = proto.Clone(record).(*api.Record)
+		}
+		return record, nil
+	}
+	return nil, ErrCorruptRecord
+}
+
+// readFrame returns the compression and the records of the store frame at pos.
+// The last batch is kept, so reading it record by record decodes it once.
+func (s *segment) readFrame(pos uint64) (Compression, []*api.Record, error) {
+	s.cache.Lock()
+	if s.cache.records != nil && s.cache.pos == pos {
+		c, records := s.cache.compression, s.cache.records
+		s.cache.Unlock()
+		return c, records, nil
+	}
+	s.cache.Unlock()
+
+	// The records are decoded while the frame is still mapped, they don't point into it
+	var c Compression
+	var records []*api.Record
+	if err := s.store.ViewFrame(pos, func(attrs byte, p []byte) error {
+		var err error
+		c = frameCompression(attrs)
+		records, err = decodeFrame(attrs, p)
+		return err
+	}); err != nil {
+		return 0, nil, err
+	}
+
+	if c != CompressionNone || len(records) > 1 {
+		s.cache.Lock()
+		s.cache.pos, s.cache.compression, s.cache.records = pos, c, records
+		s.cache.Unlock()
+	}
+	return c, records, nil
+}
+
+// scan calls fn for every record in the segment in offset order.
+func (s *segment) scan(fn func(record *api.Record) error) error {
+	return s.scanFrames(func(records []*api.Record, _ Compression) error {
+		for _, record := range records {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// scanFrames calls fn with the records of every store frame in the segment in offset order,
+// along with the frame's compression.
+func (s *segment) scanFrames(fn func(records []*api.Record, c Compression) error) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+
+	// Only the published entries, the active segment may be appended to meanwhile
+	var last uint64
+	for slot := uint64(0); slot < s.published.Load(); slot++ {
+		_, pos := s.index.entry(slot)
+
+		// The records of a batch share a frame
+		if slot > 0 && pos == last {
+			continue
+		}
+		last = pos
+
+		c, records, err := s.readFrame(pos)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(records, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// frameAt returns the end and the records of the frame at pos if it's intact and holds the
+// record with the given offset.
+func (s *segment) frameAt(pos, off uint64) (end uint64, records []*api.Record, ok bool) {
+	end, err := s.store.Verify(pos)
+	if err != nil {
+		return 0, nil, false
+	}
+
+	if _, records, err = s.readFrame(pos); err != nil {
+		return 0, nil, false
+	}
+
+	for _, record := range records {
+		if record.Offset == off {
+			return end, records, true
+		}
+	}
+	return 0, nil, false
+}
+
+// recover checks the tail of the segment after it's opened. A crash can leave the last
+// records half-written in the store, or index entries that point past the flushed data
+// (the index file is pre-allocated, so its tail may also be all zeros). It walks the index
+// backwards until it finds an entry pointing at an intact record, then trims both the
+// index and the store back to that record. It returns the number of store bytes dropped, or an
+// error if the first record of the store is corrupt rather than torn.
+func (s *segment) recover() (uint64, error) {
+	var entries uint64
+	end := s.store.start
+	for n := s.index.size / entWidth; n > 0; n-- {
+		off, pos, err := s.index.Read(int64(n - 1))
+		if err != nil {
+			return 0, err
+		}
+
+		// Only the first entry can have a zero relative offset, the rest of the file is zero-filled
+		if n > 1 && off == 0 {
+			continue
+		}
+
+		frameEnd, records, ok := s.frameAt(pos, s.baseOffset+uint64(off))
+		if !ok {
+			continue
+		}
+
+		// A batch is all or nothing: if the crash cut its index entries short, drop all of them
+		end = frameEnd
+		if records[len(records)-1].Offset != s.baseOffset+uint64(off) {
+			for ; n > 0; n-- {
+				if _, p, err := s.index.Read(int64(n - 1)); err != nil || p != pos {
+					break
+				}
+			}
+			end = pos
+		}
+		entries = n
+		break
+	}
+
+	// A crash tears the tail of the store, not its first record: trimming the whole store over a
+	// first record that fails its checksum would drop every record of the segment
+	if entries == 0 && s.store.size > s.store.start {
+		if _, err := s.store.Verify(s.store.start); err == ErrCorruptRecord {
+			return 0, fmt.Errorf("segment %d: first record fails its checksum: %w", s.baseOffset, err)
+		}
+	}
+
+	if err := s.index.Truncate(entries); err != nil {
+		return 0, err
+	}
+
+	// A torn batch may have been cached while checking the tail
+	s.cache.Lock()
+	s.cache.records = nil
+	s.cache.Unlock()
+
+	dropped := s.store.size - end
+	if dropped > 0 {
+		if err := s.store.Truncate(end); err != nil {
+			return 0, err
+		}
+	}
+
+	if entries == 0 {
+		s.nextOffset = s.baseOffset
+	} else {
+		off, _, err := s.index.Read(-1)
+		if err != nil {
+			return 0, err
+		}
+		s.nextOffset = s.baseOffset + uint64(off) + 1
+	}
+
+	// Drop time index entries that are zero-filled or point at records that no longer exist
+	n := s.timeIndex.size / timeEntWidth
+	for ; n > 0; n-- {
+		ts, off, err := s.timeIndex.Read(int64(n - 1))
+		if err != nil {
+			return 0, err
+		}
+		if ts != 0 && s.baseOffset+uint64(off) < s.nextOffset {
+			break
+		}
+	}
+
+	if err := s.timeIndex.Truncate(n); err != nil {
+		return 0, err
+	}
+
+	s.maxTimestamp = 0
+	if ts, _, err := s.timeIndex.Read(-1); err == nil {
+		s.maxTimestamp = ts
+	}
+
+	return dropped, s.publish()
+}
+
+// OffsetForTime returns the offset of the first record in the segment appended at or after ts.
+func (s *segment) OffsetForTime(ts int64) (uint64, error) {
+	if err := s.acquire(); err != nil {
+		return 0, err
+	}
+	defer s.release()
+
+	off, err := s.timeIndex.Lookup(ts)
+	if err != nil {
+		return 0, err
+	}
+	return s.baseOffset + uint64(off), nil
+}
+
+// expired reports whether the segment's first record is older than maxAge at now.
+// An empty segment never expires, and nothing does without a maxAge.
+func (s *segment) expired(now time.Time, maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	ts, _, err := s.timeIndex.Read(0)
+	return err == nil && ts <= now.Add(-maxAge).UnixNano()
+}
+
+// seal maps the store of a segment that's no longer appended to, so reads come straight from memory.
+func (s *segment) seal() error {
+	return s.store.Map()
 }
 
 // IsMaxed checks if the segment has reached its maximum size for either the store or the index.
@@ -126,6 +620,23 @@ func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.Segment.MaxStoreBytes || s.index.size >= s.config.Segment.MaxIndexBytes
 }
 
+// Sync commits the store and both indexes to stable storage. The store goes first,
+// so a synced index entry never points at a record that isn't on disk.
+func (s *segment) Sync() error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	if err := s.index.Sync(); err != nil {
+		return err
+	}
+	return s.timeIndex.Sync()
+}
+
 func (s *segment) Remove() error {
 	if err := s.Close(); err != nil {
 		return err
@@ -133,18 +644,29 @@ func (s *segment) Remove() error {
 	if err := os.Remove(s.index.Name()); err != nil {
 		return err
 	}
+	if err := os.Remove(s.timeIndex.Name()); err != nil {
+		return err
+	}
 	if err := os.Remove(s.store.Name()); err != nil {
 		return err
 	}
 	return nil
 }
 
+// Close closes the segment for good. If reads are in progress, the last of them closes the files.
 func (s *segment) Close() error {
-	if err := s.index.Close(); err != nil {
-		return err
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == segmentClosed {
+		return nil
 	}
-	if err := s.store.Close(); err != nil {
-		return err
+	open := s.state == segmentOpen
+	s.state = segmentClosed
+	s.lru.remove(s)
+
+	if open && s.refs.Add(-1) == 0 {
+		return s.closeFiles()
 	}
 	return nil
 }
@@ -0,0 +1,350 @@
+package log
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+/*
+	* Reader streams every record, and a log rebuilt from it appends them again one at a time. A
+	  checkpoint ships the segment files instead, and a restored log opens them as they are.
+	* Sealed segments never change in place: compaction, truncation and retention write new files
+	  and rename them over the old ones, and the active segment is only appended to. So a checkpoint
+	  hard-links the files of every local segment into its own dir, falling back to a copy across
+	  filesystems, and records how much of each one it holds. Appends made after it are past that
+	  size and aren't streamed; the log may go on compacting or deleting its segments meanwhile.
+	* Offloaded segments are fetched from the object store when the checkpoint is streamed, so a
+	  log restored from it doesn't need the store of the log it was taken from.
+	* The stream is checkpointMagic followed by a tar of the files, with the manifest last. Restore
+	  extracts it next to the log dir in <dir>.restore. Once it's complete, the log dir is moved
+	  aside to <dir>.replaced, the restored dir is moved in, and only then are the replaced log's
+	  files and offloaded segments deleted. setup finishes a restore a crash interrupted: without
+	  <dir>.replaced the log is as it was and <dir>.restore is dropped, with it the restored dir is
+	  moved in if it isn't yet and the replaced log is deleted. The segment files are written with
+	  Config.Encryption, so the log restoring them needs the same keys.
+*/
+
+const (
+	checkpointMagic = "LOGSNAP\x01"
+	restoreSuffix   = ".restore"
+	replacedSuffix  = ".replaced"
+)
+
+// ErrNotCheckpoint is returned by Restore when the stream doesn't start like a checkpoint.
+var ErrNotCheckpoint = errors.New("not a log checkpoint")
+
+// Checkpoint is a point-in-time copy of a log's segments, see Log.Checkpoint.
+type Checkpoint struct {
+	dir    string
+	files  []checkpointFile
+	remote []segmentMeta
+	store  ObjectStore
+}
+
+// checkpointFile is a file of the checkpoint dir, of which only the first size bytes are in the checkpoint.
+type checkpointFile struct {
+	name string
+	size uint64
+}
+
+// Checkpoint links the files of the log's segments into dir, creating it if needed, and returns
+// a checkpoint of the records appended so far. It holds the log's lock only while linking the files.
+// The caller must Release the checkpoint once it's streamed.
+func (l *Log) Checkpoint(dir string) (*Checkpoint, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := &Checkpoint{dir: dir, store: l.Config.Tiering.Store}
+	m := &manifest{Version: FormatVersion}
+	for _, r := range l.remote {
+		c.remote = append(c.remote, *r)
+		m.Segments = append(m.Segments, manifestSegment{BaseOffset: r.baseOffset, State: SegmentSealed})
+	}
+
+	for _, s := range l.segments {
+		// The active store buffers its last appends
+		if err := s.store.Flush(); err != nil {
+			return nil, c.release(err)
+		}
+		sizes := []uint64{s.store.size, s.index.size, s.timeIndex.size}
+		for i, ext := range segmentExts {
+			name := segmentFile("", s.baseOffset, ext)
+			if err := linkOrCopy(path.Join(l.Dir, name), path.Join(dir, name), sizes[i]); err != nil {
+				return nil, c.release(err)
+			}
+			c.files = append(c.files, checkpointFile{name: name, size: sizes[i]})
+		}
+
+		state := SegmentSealed
+		if s == l.activeSegment {
+			state = SegmentActive
+		}
+		m.Segments = append(m.Segments, manifestSegment{BaseOffset: s.baseOffset, State: state})
+	}
+
+	if err := m.write(dir); err != nil {
+		return nil, c.release(err)
+	}
+	return c, nil
+}
+
+// linkOrCopy hard-links src to dst, or copies the first size bytes of src if it can't be linked.
+func linkOrCopy(src, dst string, size uint64) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, io.LimitReader(in, int64(size))); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Stream writes the checkpoint to w in the format Restore reads.
+func (c *Checkpoint) Stream(w io.Writer) error {
+	// The offloaded segments come first in the log, and in the stream
+	var files []checkpointFile
+	for _, r := range c.remote {
+		for _, ext := range segmentExts {
+			name := segmentFile("", r.baseOffset, ext)
+			size, err := c.download(name)
+			if err != nil {
+				return err
+			}
+			files = append(files, checkpointFile{name: name, size: size})
+		}
+	}
+	files = append(files, c.files...)
+
+	fi, err := os.Stat(path.Join(c.dir, manifestFile))
+	if err != nil {
+		return err
+	}
+	files = append(files, checkpointFile{name: manifestFile, size: uint64(fi.Size())})
+
+	if _, err = io.WriteString(w, checkpointMagic); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, file := range files {
+		if err = c.writeFile(tw, file); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// download copies the offloaded object with the given name into the checkpoint dir and returns its size.
+func (c *Checkpoint) download(name string) (uint64, error) {
+	if c.store == nil {
+		return 0, fmt.Errorf("segment file %s is offloaded but the log has no object store", name)
+	}
+	rc, err := c.store.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(path.Join(c.dir, name))
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, rc)
+	if err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	return uint64(n), f.Close()
+}
+
+// writeFile adds the checkpointed part of file to the tar.
+func (c *Checkpoint) writeFile(tw *tar.Writer, file checkpointFile) error {
+	f, err := os.Open(path.Join(c.dir, file.name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     file.name,
+		Mode:     0644,
+		Size:     int64(file.size),
+	}); err != nil {
+		return err
+	}
+	// A linked file of the active segment goes on growing, or is pre-allocated if it's an index
+	n, err := io.Copy(tw, io.LimitReader(f, int64(file.size)))
+	if err != nil {
+		return err
+	}
+	if uint64(n) != file.size {
+		return fmt.Errorf("checkpoint file %s: %w", file.name, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// Release removes the checkpoint's files. The log's segments aren't affected.
+func (c *Checkpoint) Release() error {
+	return os.RemoveAll(c.dir)
+}
+
+// release removes the files of a checkpoint that failed with err, and returns err.
+func (c *Checkpoint) release(err error) error {
+	_ = c.Release()
+	return err
+}
+
+// IsCheckpoint reports whether r starts with a checkpoint stream, without consuming any of it.
+func IsCheckpoint(r *bufio.Reader) bool {
+	b, err := r.Peek(len(checkpointMagic))
+	return err == nil && string(b) == checkpointMagic
+}
+
+// Restore replaces the content of the log with the checkpoint read from r. The log's current
+// segments, offloaded ones included, are removed once the restored ones are in place.
+func (l *Log) Restore(r io.Reader) error {
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+	if string(magic) != checkpointMagic {
+		return ErrNotCheckpoint
+	}
+
+	staging := path.Clean(l.Dir) + restoreSuffix
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	if err := extractCheckpoint(tar.NewReader(r), staging); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path.Join(staging, manifestFile)); err != nil {
+		return fmt.Errorf("checkpoint without a %s: %w", manifestFile, err)
+	}
+	if err := syncDir(staging); err != nil {
+		return err
+	}
+
+	// The checkpoint is complete, so from the rename on setup moves it in if a crash interrupts
+	if err := l.Close(); err != nil {
+		return err
+	}
+	parent := path.Dir(path.Clean(l.Dir))
+	if err := os.Rename(l.Dir, path.Clean(l.Dir)+replacedSuffix); err != nil {
+		return err
+	}
+	if err := syncDir(parent); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, l.Dir); err != nil {
+		return err
+	}
+	if err := syncDir(parent); err != nil {
+		return err
+	}
+	// setup deletes the replaced log
+	return l.setup()
+}
+
+// finishRestore completes a Restore interrupted by a crash, see the top of the file.
+func (l *Log) finishRestore() error {
+	dir := path.Clean(l.Dir)
+	staging, replaced := dir+restoreSuffix, dir+replacedSuffix
+	if _, err := os.Stat(replaced); os.IsNotExist(err) {
+		return os.RemoveAll(staging)
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err = os.Rename(staging, dir); err != nil {
+			return err
+		}
+		if err = syncDir(path.Dir(dir)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// The restored log has every segment locally, the replaced log's offloaded segments go
+	m, err := readManifest(replaced)
+	if err != nil {
+		return err
+	}
+	for _, ms := range m.Segments {
+		if ms.State != SegmentRemote {
+			continue
+		}
+		if l.Config.Tiering.Store == nil {
+			return fmt.Errorf("replaced segment %d is offloaded but the log has no object store", ms.BaseOffset)
+		}
+		for _, ext := range segmentExts {
+			if err = l.Config.Tiering.Store.Delete(segmentFile("", ms.BaseOffset, ext)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(replaced)
+}
+
+// extractCheckpoint writes the files of a checkpoint to dir and syncs them.
+func extractCheckpoint(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// A checkpoint only holds the files of a log dir, nothing outside of it
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg || name != path.Base(name) || name == "." || name == ".." {
+			return fmt.Errorf("unexpected file %q in checkpoint", name)
+		}
+
+		f, err := os.OpenFile(path.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(f, tr); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err = f.Sync(); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+	}
+}
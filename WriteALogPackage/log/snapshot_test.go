@@ -0,0 +1,184 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, c Config){
+		"local segments":     testCheckpointLocal,
+		"offloaded segments": testCheckpointOffloaded,
+		"encrypted segments": testCheckpointEncrypted,
+		"not a checkpoint":   testRestoreNotCheckpoint,
+		"interrupted":        testRestoreInterrupted,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			c := Config{}
+			c.Segment.MaxIndexBytes = entWidth * 3
+			fn(t, c)
+		})
+	}
+}
+
+// checkpoint takes a checkpoint of log into a new dir and returns its stream. fn runs between
+// taking the checkpoint and streaming it.
+func checkpoint(t *testing.T, log *Log, fn func()) *bytes.Buffer {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "checkpoint-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := log.Checkpoint(path.Join(dir, "checkpoint"))
+	require.NoError(t, err)
+	fn()
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Stream(&buf))
+	require.NoError(t, c.Release())
+	_, err = os.Stat(path.Join(dir, "checkpoint"))
+	require.True(t, os.IsNotExist(err))
+
+	require.True(t, IsCheckpoint(bufio.NewReader(bytes.NewReader(buf.Bytes()))))
+	return &buf
+}
+
+func testCheckpointLocal(t *testing.T, c Config) {
+	log := newTruncateLog(t, c, 8)
+	defer func() { _ = log.Close() }()
+
+	// the log goes on after the checkpoint, rewriting and removing the segments it linked
+	buf := checkpoint(t, log, func() {
+		for i := 8; i < 12; i++ {
+			_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+			require.NoError(t, err)
+		}
+		require.NoError(t, log.TruncateBefore(4))
+		require.NoError(t, log.TruncateAfter(6))
+	})
+
+	// the restored log replaces whatever the other log had
+	restored := newTruncateLog(t, c, 20)
+	defer func() { _ = restored.Close() }()
+	require.NoError(t, restored.Restore(buf))
+	_, err := os.Stat(restored.Dir + restoreSuffix)
+	require.True(t, os.IsNotExist(err))
+
+	// segments [0,2] [3,5] are sealed, [6,7] is active
+	require.Len(t, restored.segments, 3)
+	restored = requireRecords(t, restored, 0, 7)
+	require.NoError(t, restored.Close())
+}
+
+func testCheckpointOffloaded(t *testing.T, c Config) {
+	dir, err := ioutil.TempDir("", "checkpoint-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewLocalObjectStore(path.Join(dir, "bucket"))
+	require.NoError(t, err)
+
+	c.Tiering.Store = store
+	log := newTruncateLog(t, c, 8)
+	defer func() { _ = log.Close() }()
+	log.Config.Tiering.LocalBytes = 1
+	require.NoError(t, log.Offload(time.Now()))
+	require.Len(t, log.remote, 2)
+
+	buf := checkpoint(t, log, func() {})
+
+	// the restored log has every segment locally, and doesn't need the store
+	restored := newTruncateLog(t, Config{Segment: c.Segment}, 0)
+	defer func() { _ = restored.Close() }()
+	require.NoError(t, restored.Restore(buf))
+	require.Len(t, restored.remote, 0)
+	require.Len(t, restored.segments, 3)
+	restored = requireRecords(t, restored, 0, 7)
+	require.NoError(t, restored.Close())
+}
+
+func testCheckpointEncrypted(t *testing.T, c Config) {
+	dir, err := ioutil.TempDir("", "checkpoint-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c.Encryption.Keys = newTestKeys(t, dir, "k1")
+	log := newTruncateLog(t, c, 5)
+	defer func() { _ = log.Close() }()
+
+	buf := checkpoint(t, log, func() {})
+	require.False(t, strings.Contains(buf.String(), "record 1"))
+
+	restored := newTruncateLog(t, c, 0)
+	defer func() { _ = restored.Close() }()
+	require.NoError(t, restored.Restore(buf))
+	restored = requireRecords(t, restored, 0, 4)
+	require.NoError(t, restored.Close())
+}
+
+func testRestoreNotCheckpoint(t *testing.T, c Config) {
+	log := newTruncateLog(t, c, 2)
+	defer func() { _ = log.Close() }()
+
+	// a stream that isn't a checkpoint leaves the log as it was
+	require.False(t, IsCheckpoint(bufio.NewReader(log.Reader())))
+	require.Equal(t, ErrNotCheckpoint, log.Restore(log.Reader()))
+	log = requireRecords(t, log, 0, 1)
+	require.NoError(t, log.Close())
+}
+
+func testRestoreInterrupted(t *testing.T, c Config) {
+	dir, err := ioutil.TempDir("", "checkpoint-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewLocalObjectStore(path.Join(dir, "bucket"))
+	require.NoError(t, err)
+
+	c.Tiering.Store = store
+	log := newTruncateLog(t, c, 8)
+	log.Config.Tiering.LocalBytes = 1
+	require.NoError(t, log.Offload(time.Now()))
+	require.NoError(t, log.Close())
+	staging, replaced := log.Dir+restoreSuffix, log.Dir+replacedSuffix
+	t.Cleanup(func() {
+		_ = os.RemoveAll(staging)
+		_ = os.RemoveAll(replaced)
+	})
+
+	// a crash while the checkpoint was extracted leaves the log as it was
+	require.NoError(t, os.MkdirAll(staging, 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(staging, "0.store"), []byte("partial"), 0644))
+	log, err = NewLog(log.Dir, c)
+	require.NoError(t, err)
+	log = requireRecords(t, log, 0, 7)
+	require.NoError(t, log.Close())
+	_, err = os.Stat(staging)
+	require.True(t, os.IsNotExist(err))
+
+	// a crash once the log was moved aside moves the restored log in and deletes the replaced one
+	restored := newTruncateLog(t, Config{Segment: c.Segment}, 5)
+	require.NoError(t, restored.Close())
+	require.NoError(t, os.Rename(log.Dir, replaced))
+	require.NoError(t, os.Rename(restored.Dir, staging))
+
+	log, err = NewLog(log.Dir, c)
+	require.NoError(t, err)
+	log = requireRecords(t, log, 0, 4)
+	require.NoError(t, log.Close())
+	for _, name := range []string{staging, replaced} {
+		_, err = os.Stat(name)
+		require.True(t, os.IsNotExist(err))
+	}
+	objects, err := ioutil.ReadDir(store.Dir)
+	require.NoError(t, err)
+	require.Len(t, objects, 0)
+}
@@ -2,26 +2,58 @@ package log
 
 import (
 	"bufio"
+	"crypto/cipher"
 	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
+
+	"github.com/tysonmote/gommap"
 )
 
 var (
 	// Encode data to persist it to a disk
 	enc = binary.BigEndian
+
+	// Castagnoli has hardware support on most platforms and better error detection than IEEE
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrCorruptRecord is returned when a record's checksum does not match its data
+	ErrCorruptRecord = errors.New("corrupt record")
 )
 
 const (
 	// Each record is written to the segment file with an 8-byte length prefix
 	LenWidth = 8
+	// followed by a 4-byte CRC-32C checksum of the data
+	CRCWidth = 4
+	// HeaderWidth is the size of the frame that precedes every record
+	HeaderWidth = LenWidth + CRCWidth
+
+	// The top byte of the length holds the frame's attributes, the data length uses the other 56 bits
+	attrShift = 56
+	lenMask   = 1<<attrShift - 1
 )
 
 type store struct {
 	*os.File
-	mu   sync.Mutex
+	mu   sync.Mutex // serializes writers; reads of flushed frames don't take it
 	buf  *bufio.Writer
 	size uint64
+
+	// Every frame below flushed is in the file. It's published by Flush, so readers see it
+	// without flushing the buffer themselves and without waiting for an append.
+	flushed atomic.Uint64
+
+	// A sealed store is mapped read-only, so reads don't make syscalls or copy the frame
+	mapped atomic.Pointer[mapping]
+
+	// An encrypted store starts with a header, and its frames begin at start, see encryption.go
+	start uint64
+	aead  cipher.AEAD
 }
 
 func newStore(f *os.File) (*store, error) {
@@ -30,20 +62,23 @@ func newStore(f *os.File) (*store, error) {
 		return nil, err
 	}
 
-	size := uint64(fi.Size())
-	return &store{
+	s := &store{
 		File: f,
-		size: size,
+		size: uint64(fi.Size()),
 		buf:  bufio.NewWriter(f),
-	}, nil
+	}
+	s.flushed.Store(s.size)
+	return s, nil
 }
 
 /*
-  - [8-byte length][actual data][8-byte length][actual data]...
-  - The 8-byte length stores the size of the actual data that follows it.
-  - File content: [0,0,0,0,0,0,0,11] [H,e,l,l,o, ,W,o,r,l,d]
-                  ^^^^^^^^^^^^^^^^^^ ^^^^^^^^^^^^^^^^^^^^^^^
-              8-byte length     actual data (11 bytes)
+  - [8-byte length][4-byte crc][actual data][8-byte length][4-byte crc][actual data]...
+  - The 8-byte length stores the size of the actual data that follows the header.
+    Its top byte holds the frame's attributes (the compression of a record batch), which are 0 for a plain record.
+  - The 4-byte crc is the CRC-32C checksum of the actual data, so torn or corrupted writes can be detected.
+  - File content: [0,0,0,0,0,0,0,11] [c,c,c,c] [H,e,l,l,o, ,W,o,r,l,d]
+                  ^^^^^^^^^^^^^^^^^^ ^^^^^^^^^ ^^^^^^^^^^^^^^^^^^^^^^^
+              8-byte length      checksum   actual data (11 bytes)
               (value = 11)
 */
 
@@ -51,9 +86,19 @@ func (s *store) Append(p []byte) (n, pos uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	p, attrs, err := s.seal(p, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
 	pos = s.size
 	// Write the length of the data first (8 bytes)
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
+	if err := binary.Write(s.buf, enc, frameLen(len(p), attrs)); err != nil {
+		return 0, 0, err
+	}
+
+	// Then the checksum of the data (4 bytes)
+	if err := binary.Write(s.buf, enc, crc32.Checksum(p, crcTable)); err != nil {
 		return 0, 0, err
 	}
 
@@ -63,48 +108,317 @@ func (s *store) Append(p []byte) (n, pos uint64, err error) {
 		return 0, 0, err
 	}
 
-	w += LenWidth       // Add the length prefix size
+	w += HeaderWidth    // Add the length prefix and checksum size
 	s.size += uint64(w) // Update total size
 	return uint64(w), pos, nil
 }
 
-func (s *store) Read(pos uint64) ([]byte, error) {
+// AppendBatch frames every record of the batch with the given attributes and writes them
+// with a single write, returning the position of each frame. If the write fails, the store
+// is rolled back to where it was, so no part of the batch is left behind.
+func (s *store) AppendBatch(ps [][]byte, attrs byte) (positions []uint64, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// To flush the buffer to the file.
-	if err := s.buf.Flush(); err != nil {
+	// The rollback discards the buffer, which must only hold the batch by then
+	if err = s.flush(); err != nil {
 		return nil, err
 	}
 
-	size := make([]byte, LenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
-		return nil, err
+	sealed := make([][]byte, len(ps))
+	frameAttrs := attrs
+	var size int
+	for i, p := range ps {
+		if sealed[i], frameAttrs, err = s.seal(p, attrs); err != nil {
+			return nil, err
+		}
+		size += HeaderWidth + len(sealed[i])
+	}
+
+	b := make([]byte, 0, size)
+	positions = make([]uint64, len(ps))
+	for i, p := range sealed {
+		positions[i] = s.size + uint64(len(b))
+		b = enc.AppendUint64(b, frameLen(len(p), frameAttrs))
+		b = enc.AppendUint32(b, crc32.Checksum(p, crcTable))
+		b = append(b, p...)
 	}
 
-	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+LenWidth)); err != nil {
+	if _, err = s.buf.Write(b); err != nil {
+		// The buffer may have flushed part of the batch to the file before failing
+		s.buf.Reset(s.File)
+		if terr := s.File.Truncate(int64(s.size)); terr != nil {
+			return nil, terr
+		}
 		return nil, err
 	}
-	return b, nil
+
+	s.size += uint64(len(b))
+	return positions, nil
 }
 
-func (s *store) ReadAt(p []byte, off int64) (int, error) {
+// mapping is the read-only memory map of a sealed store. Readers hold a reference while they
+// use it, so the store is only unmapped once the last of them is done.
+type mapping struct {
+	data gommap.MMap
+	refs atomic.Int64 // one for the store while it's mapped, plus one per reader
+}
+
+// Map maps the store read-only. It must only be called once the store is sealed, nothing
+// may be appended to a mapped store. An empty store isn't mapped.
+func (s *store) Map() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// To flush the buffer to the file.v
-	if err := s.buf.Flush(); err != nil {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if s.mapped.Load() != nil || s.size == 0 {
+		return nil
+	}
+
+	data, err := gommap.Map(s.File.Fd(), gommap.PROT_READ, gommap.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	m := &mapping{data: data}
+	m.refs.Store(1)
+	s.mapped.Store(m)
+	return nil
+}
+
+// unmap drops the store's reference to its mapping. The caller must hold the lock.
+func (s *store) unmap() error {
+	if m := s.mapped.Swap(nil); m != nil {
+		return m.release()
+	}
+	return nil
+}
+
+// acquire returns the store's mapping with a reference held for the caller, or nil if the store isn't mapped.
+func (s *store) acquire() *mapping {
+	for {
+		m := s.mapped.Load()
+		if m == nil {
+			return nil
+		}
+		// A mapping without references is being unmapped
+		refs := m.refs.Load()
+		if refs == 0 {
+			return nil
+		}
+		if m.refs.CompareAndSwap(refs, refs+1) {
+			return m
+		}
+	}
+}
+
+// release drops a reference to the mapping and unmaps it with the last one.
+func (m *mapping) release() error {
+	if m.refs.Add(-1) == 0 {
+		return m.data.UnsafeUnmap()
+	}
+	return nil
+}
+
+// frame returns the attributes and the data of the frame at pos, after checking the data
+// against its checksum. The data points into the mapping.
+func (m *mapping) frame(pos uint64) (byte, []byte, error) {
+	size := uint64(len(m.data))
+	if pos > size || size-pos < HeaderWidth {
+		return 0, nil, io.EOF
+	}
+
+	header := m.data[pos : pos+HeaderWidth]
+	attrs, n := parseFrameLen(enc.Uint64(header[:LenWidth]))
+	if n > size || pos+HeaderWidth+n > size {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+
+	b := m.data[pos+HeaderWidth : pos+HeaderWidth+n]
+	if crc32.Checksum(b, crcTable) != enc.Uint32(header[LenWidth:]) {
+		return 0, nil, ErrCorruptRecord
+	}
+	return attrs, b, nil
+}
+
+func (s *store) Read(pos uint64) ([]byte, error) {
+	_, b, err := s.ReadFrame(pos)
+	return b, err
+}
+
+// ReadFrame returns the attributes and the data of the frame at pos, decrypted if the store is encrypted.
+// A frame that was flushed is read without the lock, concurrently with other reads and with appends;
+// only a frame still in the buffer makes the read flush it first.
+func (s *store) ReadFrame(pos uint64) (attrs byte, b []byte, err error) {
+	if pos >= s.flushed.Load() {
+		if err := s.Flush(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if attrs, b, err = s.read(pos); err != nil {
+		return 0, nil, err
+	}
+	return unseal(s.aead, attrs, b)
+}
+
+// ViewFrame calls fn with the attributes and the data of the frame at pos, decrypted if the store
+// is encrypted. In a mapped store a plaintext frame points into the mapping, so fn must not keep
+// it; otherwise the frame is read like ReadFrame does.
+func (s *store) ViewFrame(pos uint64, fn func(attrs byte, b []byte) error) error {
+	m := s.acquire()
+	if m == nil {
+		attrs, b, err := s.ReadFrame(pos)
+		if err != nil {
+			return err
+		}
+		return fn(attrs, b)
+	}
+
+	attrs, b, err := m.frame(pos)
+	if err == nil {
+		attrs, b, err = unseal(s.aead, attrs, b)
+	}
+	if err == nil {
+		err = fn(attrs, b)
+	}
+	if rerr := m.release(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// read returns the attributes and the data of the frame at pos after checking the data against
+// its checksum. Only the flushed part of the store is read.
+func (s *store) read(pos uint64) (byte, []byte, error) {
+	flushed := s.flushed.Load()
+	header := make([]byte, HeaderWidth)
+	if _, err := s.File.ReadAt(header, int64(pos)); err != nil {
+		return 0, nil, err
+	}
+
+	// A torn write can leave a length that points past the end of the file
+	attrs, size := parseFrameLen(enc.Uint64(header[:LenWidth]))
+	if size > flushed || pos+HeaderWidth+size > flushed {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+
+	b := make([]byte, size)
+	if _, err := s.File.ReadAt(b, int64(pos+HeaderWidth)); err != nil {
+		return 0, nil, err
+	}
+
+	if crc32.Checksum(b, crcTable) != enc.Uint32(header[LenWidth:]) {
+		return 0, nil, ErrCorruptRecord
+	}
+	return attrs, b, nil
+}
+
+// frameLen packs a frame's attributes and data length into the length field of its header.
+func frameLen(n int, attrs byte) uint64 {
+	return uint64(attrs)<<attrShift | uint64(n)
+}
+
+// parseFrameLen splits the length field of a frame header into the attributes and the data length.
+func parseFrameLen(v uint64) (attrs byte, n uint64) {
+	return byte(v >> attrShift), v & lenMask
+}
+
+// Verify checks that an intact record starts at pos and returns the position right after it.
+func (s *store) Verify(pos uint64) (end uint64, err error) {
+	if err := s.Flush(); err != nil {
+		return 0, err
+	}
+
+	_, b, err := s.read(pos)
+	if err != nil {
 		return 0, err
 	}
+	return pos + HeaderWidth + uint64(len(b)), nil
+}
+
+// Truncate discards everything in the store from size onwards.
+func (s *store) Truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.unmap(); err != nil {
+		return err
+	}
+
+	if err := s.File.Truncate(int64(size)); err != nil {
+		return err
+	}
+
+	s.size = size
+	s.flushed.Store(size)
+	return nil
+}
+
+// Flush writes the buffered frames to the file and publishes them to readers.
+func (s *store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// flush is Flush for a caller holding the lock.
+func (s *store) flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	s.flushed.Store(s.size)
+	return nil
+}
+
+// Sync flushes the buffer and commits the store file to stable storage.
+func (s *store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.File.Sync()
+}
+
+func (s *store) ReadAt(p []byte, off int64) (int, error) {
+	// Reading past the flushed frames flushes the buffer to the file first
+	if uint64(off)+uint64(len(p)) > s.flushed.Load() {
+		if err := s.Flush(); err != nil {
+			return 0, err
+		}
+	}
 	return s.File.ReadAt(p, off)
 }
 
+// reopen opens the file of a closed store again and maps it. Only a sealed store is reopened.
+func (s *store) reopen() error {
+	s.mu.Lock()
+	f, err := os.OpenFile(s.File.Name(), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.File = f
+	s.buf.Reset(f)
+	s.mu.Unlock()
+
+	return s.Map()
+}
+
 func (s *store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if err := s.buf.Flush(); err != nil {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if err := s.unmap(); err != nil {
 		return err
 	}
 	return s.File.Close()
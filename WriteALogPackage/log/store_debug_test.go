@@ -93,13 +93,13 @@ func testReadAtDebug(t *testing.T, s *store) {
 		fmt.Printf("\nReadAt iteration %d:\n", i)
 		fmt.Printf("  - Starting offset: %d\n", off)
 
-		// Read length prefix
-		b := make([]byte, LenWidth)
+		// Read length prefix and checksum
+		b := make([]byte, HeaderWidth)
 		n, err := s.ReadAt(b, off)
 		require.NoError(t, err)
-		require.Equal(t, LenWidth, n)
+		require.Equal(t, HeaderWidth, n)
 
-		size := enc.Uint64(b)
+		size := enc.Uint64(b[:LenWidth])
 		fmt.Printf("  - Length prefix read: %d bytes\n", size)
 		off += int64(n)
 
@@ -10,7 +10,7 @@ import (
 
 var (
 	write = []byte("hello world")
-	width = uint64(len(write)) + LenWidth
+	width = uint64(len(write)) + HeaderWidth
 )
 
 func TestStoreAppendRead(t *testing.T) {
@@ -30,6 +30,30 @@ func TestStoreAppendRead(t *testing.T) {
 	testRead(t, s)
 }
 
+func TestStoreAppendBatch(t *testing.T) {
+	f, err := ioutil.TempFile("", "StoreAppendBatchTest")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(f)
+	require.NoError(t, err)
+
+	// a record still buffered is written out first, so rolling back a failed batch can't drop it
+	_, _, err = s.Append(write)
+	require.NoError(t, err)
+	positions, err := s.AppendBatch([][]byte{write, write, write}, 0)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{width, 2 * width, 3 * width}, positions)
+	require.Equal(t, 4*width, s.size)
+	require.Equal(t, width, s.flushed.Load())
+
+	for _, pos := range positions {
+		read, err := s.Read(pos)
+		require.NoError(t, err)
+		require.Equal(t, write, read)
+	}
+}
+
 func TestStoreClose(t *testing.T) {
 	f, err := ioutil.TempFile("", "StoreAppendReadTest")
 	require.NoError(t, err)
@@ -50,6 +74,64 @@ func TestStoreClose(t *testing.T) {
 	require.True(t, afterSize > beforeSize)
 }
 
+func TestStoreCorruptRecord(t *testing.T) {
+	f, err := ioutil.TempFile("", "StoreCorruptRecordTest")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(f)
+	require.NoError(t, err)
+	testAppend(t, s)
+
+	// flip a byte in the data of the second record
+	_, err = s.ReadAt(make([]byte, 1), 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{'X'}, int64(width+HeaderWidth))
+	require.NoError(t, err)
+
+	_, err = s.Read(width)
+	require.Equal(t, ErrCorruptRecord, err)
+	_, err = s.Verify(width)
+	require.Equal(t, ErrCorruptRecord, err)
+
+	end, err := s.Verify(0)
+	require.NoError(t, err)
+	require.Equal(t, width, end)
+
+	require.NoError(t, s.Truncate(end))
+	require.Equal(t, width, s.size)
+	_, err = s.Read(width)
+	require.Error(t, err)
+}
+
+func TestStoreMap(t *testing.T) {
+	f, err := ioutil.TempFile("", "StoreMapTest")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := newStore(f)
+	require.NoError(t, err)
+	testAppend(t, s)
+
+	require.NoError(t, s.Map())
+	require.NotNil(t, s.mapped.Load())
+	testRead(t, s)
+
+	// a mapped frame points into the mapping, which stays mapped while it's viewed
+	err = s.ViewFrame(width, func(_ byte, b []byte) error {
+		require.Equal(t, write, b)
+		require.NoError(t, s.Close())
+		require.Equal(t, write, b)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Nil(t, s.mapped.Load())
+
+	// once closed, reads fall back to the file and fail
+	_, err = s.Read(0)
+	require.ErrorIs(t, err, os.ErrClosed)
+}
+
 // -- Helper functions --
 
 func testAppend(t *testing.T, s *store) {
@@ -75,13 +157,13 @@ func testRead(t *testing.T, s *store) {
 func testReadAt(t *testing.T, s *store) {
 	t.Helper()
 	for i, off := uint64(1), int64(0); i < 4; i++ {
-		b := make([]byte, LenWidth)
+		b := make([]byte, HeaderWidth)
 		n, err := s.ReadAt(b, off)
 		require.NoError(t, err)
-		require.Equal(t, LenWidth, n)
+		require.Equal(t, HeaderWidth, n)
 		off += int64(n)
 
-		size := enc.Uint64(b)
+		size := enc.Uint64(b[:LenWidth])
 		b = make([]byte, size)
 		n, err = s.ReadAt(b, off)
 		require.NoError(t, err)
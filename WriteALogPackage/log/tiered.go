@@ -0,0 +1,402 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+)
+
+/*
+	* Tiered storage moves the oldest sealed segments to an ObjectStore and evicts them from local
+	  disk, so the log keeps more history than the disk holds. Offloaded segments are always a
+	  prefix of the log: the newest Tiering.LocalBytes of sealed segments and the active segment
+	  stay local.
+	* A segment is uploaded as three objects named like its files: <base>.store, <base>.index and
+	  <base>.timeindex. Switching it to remote in the manifest is the commit point, the local files
+	  are removed after that.
+	* A read below the local segments fetches the remote segment into <dir>/.remote and keeps the
+	  last Tiering.CacheSegments fetched segments there. Remote reads are serialized.
+	* Compaction only rewrites local segments. Retention and Truncate delete remote segments too,
+	  and a crash in between can leave their objects behind in the store.
+	* Each log needs an object store, or a prefix of one, of its own.
+*/
+
+const remoteCacheDir = ".remote"
+
+// ObjectStore holds the segments a log offloaded.
+type ObjectStore interface {
+	// Put stores the content of r under name, replacing the object with that name if there is one.
+	Put(name string, r io.Reader) error
+	// Get returns the content of the object with the given name.
+	Get(name string) (io.ReadCloser, error)
+	// Delete removes the object with the given name. Deleting a missing object isn't an error.
+	Delete(name string) error
+}
+
+// LocalObjectStore is an ObjectStore keeping the objects as files in a directory,
+// for tests or a filesystem mounted from elsewhere.
+type LocalObjectStore struct {
+	Dir string
+}
+
+// NewLocalObjectStore returns an ObjectStore keeping the objects in dir, creating it if needed.
+func NewLocalObjectStore(dir string) (*LocalObjectStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalObjectStore{Dir: dir}, nil
+}
+
+// Put writes the object to a temporary file and renames it, so a Get never sees half of it.
+func (s *LocalObjectStore) Put(name string, r io.Reader) error {
+	f, err := ioutil.TempFile(s.Dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path.Join(s.Dir, name))
+}
+
+func (s *LocalObjectStore) Get(name string) (io.ReadCloser, error) {
+	return os.Open(path.Join(s.Dir, name))
+}
+
+func (s *LocalObjectStore) Delete(name string) error {
+	if err := os.Remove(path.Join(s.Dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// segmentMeta is what the log knows about a segment without opening it.
+type segmentMeta struct {
+	baseOffset, nextOffset uint64
+	storeBytes             uint64
+	maxTimestamp           int64
+}
+
+func (s *segment) meta() segmentMeta {
+	return segmentMeta{
+		baseOffset:   s.baseOffset,
+		nextOffset:   s.nextOffset,
+		storeBytes:   s.store.size,
+		maxTimestamp: s.maxTimestamp,
+	}
+}
+
+// segmentExts are the extensions of the files, and the objects, a segment consists of.
+var segmentExts = []string{".store", ".index", ".timeindex"}
+
+// Offload uploads the oldest sealed segments beyond Tiering.LocalBytes to Tiering.Store and
+// removes their local files. Reads of their records fetch them back from the store.
+func (l *Log) Offload(_ time.Time) error {
+	if l.Config.Tiering.Store == nil {
+		return nil
+	}
+
+	// Keep compaction, truncation and closing away from the segments while they're uploaded
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+
+	l.mu.RLock()
+	var sealed uint64
+	for _, s := range l.segments {
+		if s != l.activeSegment {
+			sealed += s.store.size
+		}
+	}
+	var offload []*segment
+	for _, s := range l.segments {
+		if s == l.activeSegment || sealed <= l.Config.Tiering.LocalBytes {
+			break
+		}
+		sealed -= s.store.size
+		offload = append(offload, s)
+	}
+	l.mu.RUnlock()
+
+	if len(offload) == 0 {
+		return nil
+	}
+
+	for _, s := range offload {
+		if err := s.upload(l.Config.Tiering.Store); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, s := range offload {
+		meta := s.meta()
+		l.remote = append(l.remote, &meta)
+	}
+	l.segments = l.segments[len(offload):]
+	l.publish()
+	if err := l.writeManifest(); err != nil {
+		return err
+	}
+
+	for _, s := range offload {
+		if err := s.Remove(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upload puts the files of the sealed segment in the object store.
+func (s *segment) upload(store ObjectStore) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	defer s.release()
+
+	// Flush the store's buffer so the file holds every record
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+
+	// The index files are pre-allocated while open, only their used part is uploaded
+	files := []struct {
+		name string
+		size uint64
+	}{
+		{s.store.Name(), s.store.size},
+		{s.index.Name(), s.index.size},
+		{s.timeIndex.Name(), s.timeIndex.size},
+	}
+	for _, file := range files {
+		f, err := os.Open(file.name)
+		if err != nil {
+			return err
+		}
+		err = store.Put(path.Base(file.name), io.LimitReader(f, int64(file.size)))
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRemote reads off from the offloaded segments. If off is past them, or the rest of them
+// was compacted away, it returns io.EOF along with the offset to continue from in the local segments.
+func (l *Log) readRemote(remote []*segmentMeta, off uint64) (*api.Record, uint64, error) {
+	if len(remote) == 0 || off >= remote[len(remote)-1].nextOffset {
+		return nil, off, io.EOF
+	}
+	if off < remote[0].baseOffset {
+		return nil, off, fmt.Errorf("offset out of range: %d", off)
+	}
+
+	i := sort.Search(len(remote), func(i int) bool {
+		return remote[i].nextOffset > off
+	})
+	for _, r := range remote[i:] {
+		if off < r.baseOffset {
+			off = r.baseOffset
+		}
+
+		var record *api.Record
+		err := l.withFetched(r, func(s *segment) error {
+			var err error
+			record, err = s.Read(off)
+			return err
+		})
+		if err == io.EOF {
+			continue
+		}
+		return record, off, err
+	}
+	return nil, remote[len(remote)-1].nextOffset, io.EOF
+}
+
+// remoteOffsetForTime looks up the first offset appended at or after ts in the offloaded segments,
+// and reports whether it found one.
+func (l *Log) remoteOffsetForTime(ts int64) (uint64, bool, error) {
+	l.mu.RLock()
+	remote := l.remote
+	l.mu.RUnlock()
+
+	for _, r := range remote {
+		if r.maxTimestamp < ts {
+			continue
+		}
+
+		var off uint64
+		err := l.withFetched(r, func(s *segment) error {
+			var err error
+			off, err = s.OffsetForTime(ts)
+			return err
+		})
+		return off, true, err
+	}
+	return 0, false, nil
+}
+
+// withFetched calls fn with the offloaded segment, fetching it into the cache if it isn't there.
+func (l *Log) withFetched(r *segmentMeta, fn func(s *segment) error) error {
+	l.fetched.Lock()
+	defer l.fetched.Unlock()
+
+	for i, s := range l.fetched.segments {
+		if s.baseOffset == r.baseOffset {
+			// Move it to the back, the most recently used end
+			l.fetched.segments = append(append(l.fetched.segments[:i:i], l.fetched.segments[i+1:]...), s)
+			return fn(s)
+		}
+	}
+
+	s, err := l.fetch(r.baseOffset)
+	if err != nil {
+		return err
+	}
+	l.fetched.segments = append(l.fetched.segments, s)
+
+	limit := l.Config.Tiering.CacheSegments
+	if limit <= 0 {
+		limit = 1
+	}
+	for len(l.fetched.segments) > limit {
+		if err = l.fetched.segments[0].Remove(); err != nil {
+			return err
+		}
+		l.fetched.segments = l.fetched.segments[1:]
+	}
+	return fn(s)
+}
+
+// fetch downloads the offloaded segment with the given base offset into the cache dir and opens it.
+func (l *Log) fetch(base uint64) (*segment, error) {
+	dir := path.Join(l.Dir, remoteCacheDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, ext := range segmentExts {
+		if err := l.download(segmentFile("", base, ext), segmentFile(dir, base, ext)); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := newSegment(dir, base, l.Config)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.seal(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// download copies the object with the given name to a local file.
+func (l *Log) download(name, file string) error {
+	rc, err := l.Config.Tiering.Store.Get(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, rc); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// removeRemote drops the offloaded segments from the manifest and the cache, then deletes
+// their objects. l.remote must no longer hold them. The caller must hold mu.
+func (l *Log) removeRemote(remote []*segmentMeta) error {
+	if len(remote) == 0 {
+		return nil
+	}
+
+	if err := l.writeManifest(); err != nil {
+		return err
+	}
+
+	l.fetched.Lock()
+	var fetched []*segment
+	for _, s := range l.fetched.segments {
+		if s.baseOffset > remote[len(remote)-1].baseOffset {
+			fetched = append(fetched, s)
+			continue
+		}
+		if err := s.Remove(); err != nil {
+			l.fetched.Unlock()
+			return err
+		}
+	}
+	l.fetched.segments = fetched
+	l.fetched.Unlock()
+
+	for _, r := range remote {
+		for _, ext := range segmentExts {
+			if err := l.Config.Tiering.Store.Delete(segmentFile("", r.baseOffset, ext)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// closeFetched closes the segments fetched from the object store.
+func (l *Log) closeFetched() error {
+	l.fetched.Lock()
+	defer l.fetched.Unlock()
+
+	for _, s := range l.fetched.segments {
+		if err := s.Close(); err != nil {
+			return err
+		}
+	}
+	l.fetched.segments = nil
+	return nil
+}
+
+// remoteReader reads an offloaded store object, getting it only once it's read.
+type remoteReader struct {
+	store ObjectStore
+	name  string
+	rc    io.ReadCloser
+}
+
+func (r *remoteReader) Read(p []byte) (int, error) {
+	if r.rc == nil {
+		rc, err := r.store.Get(r.name)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+
+	n, err := r.rc.Read(p)
+	if err == io.EOF {
+		_ = r.rc.Close()
+	}
+	return n, err
+}
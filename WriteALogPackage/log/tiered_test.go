@@ -0,0 +1,139 @@
+package log
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiered-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalObjectStore(path.Join(dir, "bucket"))
+	require.NoError(t, err)
+	logDir := path.Join(dir, "log")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Tiering.Store = store
+	log, err := NewLog(logDir, c)
+	require.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 7; i++ {
+		_, err = log.Append(&api.Record{Value: write})
+		require.NoError(t, err)
+	}
+	// segments [0,1] [2,3] [4,5] are sealed, [6] is active
+	require.Len(t, log.segments, 4)
+
+	// keep only the newest sealed segment locally
+	log.Config.Tiering.LocalBytes = log.segments[2].store.size
+	require.NoError(t, log.Offload(time.Now()))
+	require.Len(t, log.remote, 2)
+	require.Len(t, log.segments, 2)
+	for _, base := range []uint64{0, 2} {
+		_, err = os.Stat(segmentFile(logDir, base, ".store"))
+		require.True(t, os.IsNotExist(err))
+		_, err = os.Stat(path.Join(store.Dir, segmentFile("", base, ".store")))
+		require.NoError(t, err)
+	}
+
+	check := func(log *Log) {
+		lowest, err := log.LowestOffset()
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), lowest)
+
+		for i := uint64(0); i < 7; i++ {
+			read, err := log.Read(i)
+			require.NoError(t, err)
+			require.Equal(t, i, read.Offset)
+			require.Equal(t, write, read.Value)
+		}
+
+		off, err := log.OffsetForTime(start)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), off)
+	}
+	check(log)
+
+	// only one fetched segment stays cached
+	require.Len(t, log.fetched.segments, 1)
+
+	// the reader covers the offloaded segments too
+	r := log.Reader()
+	var offsets []uint64
+	for {
+		records, err := ReadFrame(r)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		for _, record := range records {
+			offsets = append(offsets, record.Offset)
+		}
+	}
+	require.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6}, offsets)
+
+	require.NoError(t, log.Close())
+	log, err = NewLog(logDir, c)
+	require.NoError(t, err)
+	check(log)
+
+	// truncation deletes offloaded segments from the store
+	require.NoError(t, log.Truncate(1))
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lowest)
+	_, err = os.Stat(path.Join(store.Dir, segmentFile("", 0, ".store")))
+	require.True(t, os.IsNotExist(err))
+	_, err = log.Read(0)
+	require.Error(t, err)
+	require.NoError(t, log.Close())
+
+	// a log with offloaded segments can't be opened without its store
+	_, err = NewLog(logDir, Config{})
+	require.Error(t, err)
+}
+
+func TestTieredRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiered-retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewLocalObjectStore(path.Join(dir, "bucket"))
+	require.NoError(t, err)
+	logDir := path.Join(dir, "log")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = entWidth * 2
+	c.Tiering.Store = store
+	log, err := NewLog(logDir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = log.Append(&api.Record{Value: write})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Offload(time.Now()))
+	require.Len(t, log.remote, 2)
+
+	// retention counts the offloaded bytes and deletes the oldest segments first
+	log.Config.Retention.Bytes = log.remote[1].storeBytes + log.activeSegment.store.size
+	require.NoError(t, log.EnforceRetention(time.Now()))
+	require.Len(t, log.remote, 1)
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lowest)
+}
@@ -0,0 +1,171 @@
+package log
+
+import (
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tysonmote/gommap"
+)
+
+/*
+	* Time Index Entries: Each entry contains:
+		Timestamp (8 bytes): The append time of a record in unix nanoseconds
+		Offset (4 bytes): The relative offset of that record in the segment
+
+	* Entries are only written when a record's timestamp is greater than every timestamp before it,
+	  so the timestamps in the file are strictly increasing and can be binary searched.
+
+	* This index lives next to the offset index (<baseOffset>.timeindex) and answers
+	  "which is the first record appended at or after time t?" without scanning the store.
+*/
+
+var (
+	tsWidth      uint64 = 8
+	timeEntWidth        = tsWidth + offWidth
+)
+
+type timeIndex struct {
+	file *os.File
+	mmap gommap.MMap
+	size uint64
+}
+
+// newTimeIndex creates and initializes a time index, pre-allocated and memory-mapped like the offset index.
+func newTimeIndex(f *os.File, c Config) (*timeIndex, error) {
+	idx := &timeIndex{
+		file: f,
+	}
+
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	idx.size = uint64(fi.Size())
+
+	if err = f.Truncate(int64(maxUint64(c.Segment.MaxIndexBytes, idx.size))); err != nil {
+		return nil, err
+	}
+
+	if idx.mmap, err = gommap.Map(idx.file.Fd(), gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+func (t *timeIndex) Close() error {
+	if t.mmap != nil {
+		if err := t.mmap.Sync(gommap.MS_ASYNC); err != nil {
+			return err
+		}
+	}
+
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+
+	if err := t.file.Truncate(int64(t.size)); err != nil {
+		return err
+	}
+
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+
+	if t.mmap != nil {
+		if err := t.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		t.mmap = nil
+	}
+	return nil
+}
+
+// reopen opens the file of a closed time index again and maps it read-only, like index.reopen.
+func (t *timeIndex) reopen() (err error) {
+	if t.file, err = os.OpenFile(t.file.Name(), os.O_RDWR, 0644); err != nil {
+		return err
+	}
+
+	if t.size == 0 {
+		return nil
+	}
+	t.mmap, err = gommap.Map(t.file.Fd(), gommap.PROT_READ, gommap.MAP_SHARED)
+	return err
+}
+
+// Sync writes the memory-mapped entries back to the file and waits for them to reach stable storage.
+func (t *timeIndex) Sync() error {
+	return t.mmap.Sync(gommap.MS_SYNC)
+}
+
+// Read returns the entry at the given position, or the last entry if in is -1.
+func (t *timeIndex) Read(in int64) (ts int64, off uint32, err error) {
+	if t.size == 0 {
+		return 0, 0, io.EOF
+	}
+
+	if in == -1 {
+		in = int64(t.size/timeEntWidth) - 1
+	}
+
+	pos := uint64(in) * timeEntWidth
+	if t.size < pos+timeEntWidth {
+		return 0, 0, io.EOF
+	}
+
+	ts = int64(enc.Uint64(t.mmap[pos : pos+tsWidth]))
+	off = enc.Uint32(t.mmap[pos+tsWidth : pos+timeEntWidth])
+	return ts, off, nil
+}
+
+// Write persists a timestamp and relative offset pair to the time index.
+func (t *timeIndex) Write(ts int64, off uint32) error {
+	if uint64(len(t.mmap)) < t.size+timeEntWidth {
+		return io.EOF
+	}
+
+	enc.PutUint64(t.mmap[t.size:t.size+tsWidth], uint64(ts))
+	enc.PutUint32(t.mmap[t.size+tsWidth:t.size+timeEntWidth], off)
+
+	t.size += timeEntWidth
+	return nil
+}
+
+// Lookup returns the relative offset of the first entry whose timestamp is at or after ts.
+// It returns io.EOF if every entry is older than ts.
+func (t *timeIndex) Lookup(ts int64) (uint32, error) {
+	n := int(t.size / timeEntWidth)
+	i := sort.Search(n, func(i int) bool {
+		got, _, _ := t.Read(int64(i))
+		return got >= ts
+	})
+
+	if i == n {
+		return 0, io.EOF
+	}
+
+	_, off, err := t.Read(int64(i))
+	return off, err
+}
+
+// Truncate keeps the first n entries of the time index and drops the rest.
+func (t *timeIndex) Truncate(n uint64) error {
+	size := n * timeEntWidth
+	if size > t.size {
+		return io.EOF
+	}
+
+	for b := size; b < t.size; b++ {
+		t.mmap[b] = 0
+	}
+
+	t.size = size
+	return nil
+}
+
+func (t *timeIndex) Name() string {
+	return t.file.Name()
+}
@@ -0,0 +1,73 @@
+package log
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeIndex(t *testing.T) {
+	f, err := ioutil.TempFile(os.TempDir(), "timeIndexTest")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := Config{}
+	c.Segment.MaxIndexBytes = 1024
+	idx, err := newTimeIndex(f, c)
+	require.NoError(t, err)
+
+	_, err = idx.Lookup(0)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, f.Name(), idx.Name())
+
+	entries := []struct {
+		Ts  int64
+		Off uint32
+	}{
+		{Ts: 100, Off: 0},
+		{Ts: 200, Off: 3},
+		{Ts: 300, Off: 4},
+	}
+
+	for _, want := range entries {
+		require.NoError(t, idx.Write(want.Ts, want.Off))
+	}
+
+	lookups := []struct {
+		Ts  int64
+		Off uint32
+	}{
+		{Ts: 0, Off: 0},
+		{Ts: 100, Off: 0},
+		{Ts: 150, Off: 3},
+		{Ts: 300, Off: 4},
+	}
+
+	for _, want := range lookups {
+		off, err := idx.Lookup(want.Ts)
+		require.NoError(t, err)
+		require.Equal(t, want.Off, off)
+	}
+
+	// nothing was appended after the last entry
+	_, err = idx.Lookup(301)
+	require.Equal(t, io.EOF, err)
+	_ = idx.Close()
+
+	// time index should build its state from the existing file
+	f, _ = os.OpenFile(f.Name(), os.O_RDWR, 0600)
+	idx, err = newTimeIndex(f, c)
+	require.NoError(t, err)
+
+	ts, off, err := idx.Read(-1)
+	require.NoError(t, err)
+	require.Equal(t, int64(300), ts)
+	require.Equal(t, uint32(4), off)
+
+	require.NoError(t, idx.Truncate(1))
+	_, err = idx.Lookup(150)
+	require.Equal(t, io.EOF, err)
+}
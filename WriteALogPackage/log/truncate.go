@@ -0,0 +1,236 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+)
+
+/*
+	* Truncate only drops whole segments from the front, which is all retention needs. A Raft log
+	  store also deletes an exact prefix once a snapshot covers it, and a suffix that conflicts
+	  with the leader's log. TruncateBefore and TruncateAfter do that to the record.
+	* Segments entirely on the dropped side are removed like retention removes them. The segment
+	  the cut falls in is rewritten with only the records on the kept side, the same way compaction
+	  rewrites a segment, rather than edited in place: its index may be mapped read-only, and
+	  readers may be in the middle of it.
+	* A prefix cut rewrites the segment at the first kept offset, so reads below it are out of range
+	  rather than returning the next record. The new files are moved in from the cleaner dir and the
+	  manifest listing them is the commit point.
+	* A suffix cut rewrites the segment at its own base offset, so it's committed through the swap
+	  dir like a compaction. The rewritten segment becomes the active segment if it was, otherwise a
+	  new active segment starts right after the cut.
+	* Offloaded segments are only deleted whole, and a suffix can't be cut from them.
+*/
+
+// TruncateBefore removes the records below lowest, so lowest becomes the lowest offset of the log.
+// If lowest is past the end of the log, every record is removed and the next one appended gets lowest.
+func (l *Log) TruncateBefore(lowest uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var remote, removedRemote []*segmentMeta
+	for _, r := range l.remote {
+		if r.nextOffset <= lowest {
+			removedRemote = append(removedRemote, r)
+			continue
+		}
+		remote = append(remote, r)
+	}
+
+	var segments, removed []*segment
+	var cut *segment
+	for _, s := range l.segments {
+		switch {
+		case s.baseOffset >= lowest:
+			segments = append(segments, s)
+		case s.nextOffset > lowest:
+			cut = s
+		default:
+			removed = append(removed, s)
+		}
+	}
+	if cut == nil && len(removed) == 0 && len(removedRemote) == 0 {
+		return nil
+	}
+
+	if cut != nil {
+		n, err := l.rewritePrefix(cut, lowest)
+		if err != nil {
+			return err
+		}
+		segments = append([]*segment{n}, segments...)
+		removed = append(removed, cut)
+	}
+
+	l.remote = remote
+	l.segments = segments
+	if len(segments) == 0 {
+		// Everything was removed, the active segment included
+		if err := l.openSegment(lowest); err != nil {
+			return err
+		}
+	}
+	l.publish()
+
+	if err := l.removeSegments(removed); err != nil {
+		return err
+	}
+	if err := l.removeRemote(removedRemote); err != nil {
+		return err
+	}
+	l.notifyAppended(l.activeSegment.nextOffset)
+	return nil
+}
+
+// rewritePrefix writes the records of s from lowest on into a new segment starting at lowest in
+// the log dir and opens it. The caller must hold mu and still has to swap it for s.
+func (l *Log) rewritePrefix(s *segment, lowest uint64) (*segment, error) {
+	cleaner := path.Join(l.Dir, cleanerDir)
+	if err := os.RemoveAll(cleaner); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cleaner, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := s.rewriteInto(cleaner, lowest, func(record *api.Record) bool {
+		return record.Offset >= lowest
+	}); err != nil {
+		return nil, err
+	}
+
+	// Files left behind by an earlier attempt aren't in the manifest, and must not be opened as the new segment
+	if err := removeSegmentFiles(l.Dir, lowest); err != nil {
+		return nil, err
+	}
+	for _, ext := range segmentExts {
+		if err := os.Rename(segmentFile(cleaner, lowest, ext), segmentFile(l.Dir, lowest, ext)); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(cleaner); err != nil {
+		return nil, err
+	}
+	if err := syncDir(l.Dir); err != nil {
+		return nil, err
+	}
+
+	n, err := newSegment(l.Dir, lowest, l.Config)
+	if err != nil {
+		return nil, err
+	}
+	if s == l.activeSegment {
+		l.activeSegment = n
+		return n, nil
+	}
+	return n, l.seal(n)
+}
+
+// TruncateAfter removes the records above offset, so the next record appended gets offset+1.
+// It does nothing if the log doesn't go past offset.
+func (l *Log) TruncateAfter(offset uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.activeSegment.nextOffset <= offset+1 {
+		return nil
+	}
+	if len(l.remote) > 0 && offset+1 < l.segments[0].baseOffset {
+		return fmt.Errorf("offset %d is in an offloaded segment", offset)
+	}
+
+	var segments, removed []*segment
+	for _, s := range l.segments {
+		if s.baseOffset > offset {
+			removed = append(removed, s)
+			continue
+		}
+		segments = append(segments, s)
+	}
+
+	var tail *segment
+	if len(segments) > 0 {
+		tail = segments[len(segments)-1]
+	}
+	active := tail != nil && tail == l.activeSegment
+
+	// The tail is rewritten before anything is removed, a failure leaves the log as it was
+	cleaner := path.Join(l.Dir, cleanerDir)
+	rewrite := tail != nil && tail.nextOffset > offset+1
+	if rewrite {
+		if err := os.RemoveAll(cleaner); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(cleaner, 0755); err != nil {
+			return err
+		}
+		if err := tail.rewriteInto(cleaner, tail.baseOffset, func(record *api.Record) bool {
+			return record.Offset <= offset
+		}); err != nil {
+			return err
+		}
+	}
+
+	l.segments = segments
+	l.activeSegment = tail
+	l.publish()
+	if err := l.removeSegments(removed); err != nil {
+		return err
+	}
+
+	if rewrite {
+		// Renaming the directory commits the rewrite: from here on a crash is finished by setup
+		if err := os.Rename(cleaner, path.Join(l.Dir, swapDir)); err != nil {
+			return err
+		}
+		if err := syncDir(l.Dir); err != nil {
+			return err
+		}
+		if err := tail.Close(); err != nil {
+			return err
+		}
+		if err := completeSwap(l.Dir); err != nil {
+			return err
+		}
+
+		n, err := newSegment(l.Dir, tail.baseOffset, l.Config)
+		if err != nil {
+			return err
+		}
+		l.segments[len(l.segments)-1] = n
+		l.activeSegment = n
+		l.publish()
+	}
+
+	// A sealed segment isn't appended to again, the log goes on in a new one
+	var err error
+	switch {
+	case active:
+	case rewrite:
+		// Seals the rewritten segment
+		err = l.newSegment(offset + 1)
+	default:
+		// The tail, if any, is still sealed, and may be closed by the LRU
+		if err = l.openSegment(offset + 1); err == nil {
+			err = l.writeManifest()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// The records past the cut are gone, synced or not
+	next := l.activeSegment.nextOffset
+	if l.synced > next {
+		l.synced = next
+	}
+	l.notifyAppended(next)
+	return nil
+}
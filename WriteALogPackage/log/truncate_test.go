@@ -0,0 +1,197 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	api "github.com/GergesHany/Event-Streaming-System/StructureDataWithProtobuf/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncatePrefixSuffix(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, c Config){
+		"suffix in the active segment": testTruncateAfterActive,
+		"suffix in a sealed segment":   testTruncateAfterSealed,
+		"suffix in a closed segment":   testTruncateAfterClosed,
+		"suffix in a compressed batch": testTruncateAfterBatch,
+		"suffix below the log":         testTruncateAfterEverything,
+		"prefix inside a segment":      testTruncateBefore,
+		"prefix past the end":          testTruncateBeforeEverything,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			c := Config{}
+			c.Segment.MaxIndexBytes = entWidth * 3
+			fn(t, c)
+		})
+	}
+}
+
+// newTruncateLog returns a log in a new dir holding the records 0 to n-1, three to a segment.
+func newTruncateLog(t *testing.T, c Config, n int) *Log {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "truncate-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		_, err = log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+	return log
+}
+
+// requireRecords checks that log holds exactly the records from lowest to highest, before and after
+// it's reopened, and that the next record appended gets highest+1.
+func requireRecords(t *testing.T, log *Log, lowest, highest uint64) *Log {
+	t.Helper()
+	check := func(log *Log) {
+		for off := lowest; off <= highest; off++ {
+			read, err := log.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, off, read.Offset)
+			require.Equal(t, fmt.Sprintf("record %d", off), string(read.Value))
+		}
+		_, err := log.Read(highest + 1)
+		require.Error(t, err)
+		if lowest > 0 {
+			_, err = log.Read(lowest - 1)
+			require.Error(t, err)
+		}
+
+		off, err := log.LowestOffset()
+		require.NoError(t, err)
+		require.Equal(t, lowest, off)
+		off, err = log.HighestOffset()
+		require.NoError(t, err)
+		require.Equal(t, highest, off)
+	}
+
+	check(log)
+	require.NoError(t, log.Close())
+	log, err := NewLog(log.Dir, log.Config)
+	require.NoError(t, err)
+	check(log)
+
+	off, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", highest+1))})
+	require.NoError(t, err)
+	require.Equal(t, highest+1, off)
+	return log
+}
+
+func testTruncateAfterActive(t *testing.T, c Config) {
+	log := newTruncateLog(t, c, 5)
+	defer func() { _ = log.Close() }()
+
+	require.NoError(t, log.TruncateAfter(3))
+	log = requireRecords(t, log, 0, 3)
+
+	// truncating past the end does nothing
+	require.NoError(t, log.TruncateAfter(10))
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+}
+
+func testTruncateAfterSealed(t *testing.T, c Config) {
+	log := newTruncateLog(t, c, 8)
+	defer func() { _ = log.Close() }()
+
+	require.NoError(t, log.TruncateAfter(4))
+	require.Len(t, log.segments, 3)
+	log = requireRecords(t, log, 0, 4)
+}
+
+func testTruncateAfterClosed(t *testing.T, c Config) {
+	// the sealed segments are closed and reopened read-only, so they're never written in place
+	c.Segment.MaxOpenSegments = 1
+	log := newTruncateLog(t, c, 9)
+	defer func() { _ = log.Close() }()
+	for _, off := range []uint64{4, 7, 1} {
+		_, err := log.Read(off)
+		require.NoError(t, err)
+	}
+	require.False(t, log.segments[1].hasFiles)
+
+	// a cut at the end of a closed segment leaves it as it is
+	require.NoError(t, log.TruncateAfter(5))
+	require.False(t, log.segments[1].hasFiles)
+	log = requireRecords(t, log, 0, 5)
+
+	// one inside it rewrites it
+	require.NoError(t, log.TruncateAfter(0))
+	log = requireRecords(t, log, 0, 0)
+}
+
+func testTruncateAfterBatch(t *testing.T, c Config) {
+	log := newTruncateLog(t, c, 0)
+	defer func() { _ = log.Close() }()
+
+	var records []*api.Record
+	for i := 0; i < 3; i++ {
+		records = append(records, &api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+	}
+	_, err := log.AppendCompressedBatch(records, CompressionGzip)
+	require.NoError(t, err)
+
+	// the survivors of the batch are compressed again
+	require.NoError(t, log.TruncateAfter(1))
+	log = requireRecords(t, log, 0, 1)
+}
+
+func testTruncateAfterEverything(t *testing.T, c Config) {
+	c.Segment.InitialOffset = 10
+	log := newTruncateLog(t, c, 0)
+	defer func() { _ = log.Close() }()
+	for i := 10; i < 14; i++ {
+		_, err := log.Append(&api.Record{Value: []byte(fmt.Sprintf("record %d", i))})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, log.TruncateAfter(5))
+	require.Len(t, log.segments, 1)
+	_, err := log.Read(10)
+	require.Error(t, err)
+
+	off, err := log.Append(&api.Record{Value: []byte("record 6")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), off)
+}
+
+func testTruncateBefore(t *testing.T, c Config) {
+	log := newTruncateLog(t, c, 8)
+	defer func() { _ = log.Close() }()
+
+	// inside a sealed segment
+	require.NoError(t, log.TruncateBefore(4))
+	require.Equal(t, uint64(4), log.segments[0].baseOffset)
+	log = requireRecords(t, log, 4, 7)
+
+	// inside the active segment, which is still appended to
+	require.NoError(t, log.TruncateBefore(7))
+	log = requireRecords(t, log, 7, 8)
+
+	// below the log does nothing
+	require.NoError(t, log.TruncateBefore(2))
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), off)
+}
+
+func testTruncateBeforeEverything(t *testing.T, c Config) {
+	log := newTruncateLog(t, c, 5)
+	defer func() { _ = log.Close() }()
+
+	require.NoError(t, log.TruncateBefore(20))
+	require.Len(t, log.segments, 1)
+	_, err := log.Read(4)
+	require.Error(t, err)
+
+	off, err := log.Append(&api.Record{Value: []byte("record 20")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), off)
+	log = requireRecords(t, log, 20, 20)
+}